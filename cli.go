@@ -0,0 +1,586 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+	"github.com/mangalaman93/giggle/svc"
+	"github.com/sevlyar/go-daemon"
+)
+
+const (
+	cRunCommand      = "run"
+	cStopTimeout     = 30 * time.Second
+	cStopPollPeriod  = 100 * time.Millisecond
+	cLogsPollPeriod  = 500 * time.Millisecond
+	cLogsDefaultTail = 50
+	cHandoverTimeout = 30 * time.Second
+)
+
+// command is a subcommand of giggle.
+type command struct {
+	usage string
+	run   func(args []string) error
+}
+
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		cRunCommand: {"run [-headless] [-foreground]     run the sync service, the default", run},
+		"sync":      {"sync [name...]                    sync once and exit", syncCmd},
+		"status":    {"status                            show whether giggle is running and the syncs", status},
+		"validate":  {"validate                          validate the config file", validate},
+		"add":       {"add [flags] <name> <from> <to>... add a sync to the config file", add},
+		"remove":    {"remove [-purge] <name>            remove a sync from the config file", remove},
+		"stop":      {"stop                              stop the running giggle", stop},
+		"pause":     {"pause [name...]                   pause syncs of the running giggle", pause},
+		"resume":    {"resume [name...]                  resume syncs of the running giggle", resume},
+		"reload":    {"reload                            make the running giggle reload the config", reloadCmd},
+		"history":   {"history [flags] [name]            show the history of the sync runs", history},
+		"logs":      {"logs [-f] [-n lines]              print the log file", logs},
+		"secret":    {"secret <set|delete|list|keyring>  manage the secrets", runSecret},
+		"help":      {"help                              show this help", help},
+	}
+}
+
+func usage() string {
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var sb strings.Builder
+	sb.WriteString("usage: giggle [-config file] <command> [arguments]\n\ncommands:\n")
+	for _, name := range names {
+		sb.WriteString("  giggle " + commands[name].usage + "\n")
+	}
+
+	return sb.String()
+}
+
+func help([]string) error {
+	fmt.Print(usage())
+	return nil
+}
+
+// readValidConfig reads and validates the config file, resolving
+// the secrets from the credential store if it exists.
+func readValidConfig() (*conf.Config, error) {
+	if _, _, err := unlockStore(); err != nil {
+		return nil, fmt.Errorf("error unlocking credential store :: %w", err)
+	}
+
+	cf, err := conf.ReadConfig(conf.SettingsFilePath())
+	if err != nil {
+		return nil, err
+	}
+	if err := cf.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config :: %w", err)
+	}
+
+	return cf, nil
+}
+
+func syncCmd(args []string) error {
+	fs := flag.NewFlagSet("sync", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+
+	// the running giggle does the sync, as it may be syncing the same local clones
+	if pid, err := runningPid(); err != nil {
+		return err
+	} else if pid != 0 {
+		results, err := svc.NewClient(conf.SocketFilePath()).SyncNow(ctx, true, fs.Args()...)
+		if err != nil {
+			return err
+		}
+
+		var errs []error
+		for _, r := range results {
+			if r.Error != "" {
+				errs = append(errs, fmt.Errorf("error syncing %v :: %v", r.Name, r.Error))
+			} else {
+				fmt.Printf("synced %v\n", r.Name)
+			}
+		}
+		return errors.Join(errs...)
+	}
+
+	cf, err := readValidConfig()
+	if err != nil {
+		return err
+	}
+
+	return svc.SyncNow(ctx, cf, fs.Args()...)
+}
+
+func status(args []string) error {
+	fs := flag.NewFlagSet("status", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pid, err := runningPid()
+	if err != nil {
+		return err
+	}
+	if pid != 0 {
+		fmt.Printf("giggle is running with pid %v\n", pid)
+		return runningStatus()
+	}
+	fmt.Println("giggle is not running")
+
+	cf, err := conf.ReadConfig(conf.SettingsFilePath())
+	if err != nil {
+		return err
+	}
+	persisted, err := svc.ReadStatus(conf.StatusFilePath())
+	if err != nil {
+		return err
+	}
+	lastSuccess := make(map[string]time.Time)
+	for _, ss := range persisted {
+		lastSuccess[ss.Name] = ss.LastSuccess
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nSYNC\tFROM\tTO\tSCHEDULE\tLAST SUCCESS\tCONFLICTS")
+	for _, sc := range cf.Sync {
+		to := make([]string, len(sc.ToList))
+		for i, r := range sc.ToList {
+			to[i] = r.Name
+		}
+
+		schedule := "every " + cf.Period.String()
+		switch {
+		case sc.Cron != "":
+			schedule = "cron " + sc.Cron
+		case sc.Period.Duration != 0:
+			schedule = "every " + sc.Period.String()
+		}
+
+		conflicts, err := svc.ReadConflicts(sc.Name)
+		if err != nil {
+			return err
+		}
+		var from []string
+		for _, r := range sc.FromRepos() {
+			from = append(from, r.Name)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", sc.Name, strings.Join(from, ","),
+			strings.Join(to, ","), schedule, formatTime(lastSuccess[sc.Name]), len(conflicts))
+	}
+
+	return tw.Flush()
+}
+
+// runningStatus prints the status of the syncs of the running giggle.
+func runningStatus() error {
+	status, err := svc.NewClient(conf.SocketFilePath()).Status(context.Background())
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\nSYNC\tSTATE\tLAST ATTEMPT\tLAST SUCCESS\tNEXT RUN\tFAILURES\tLAST ERROR")
+	for _, ss := range status.Syncs {
+		state := "idle"
+		switch {
+		case ss.Running:
+			state = "running"
+		case ss.Paused:
+			state = "paused"
+		case ss.Diverged:
+			state = "diverged"
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\t%v\n", ss.Name, state, formatTime(ss.LastAttempt),
+			formatTime(ss.LastSuccess), formatTime(ss.NextRun), ss.ConsecutiveFailures, ss.LastError)
+	}
+	for _, bs := range status.Breakers {
+		fmt.Fprintf(tw, "\ncircuit for %v: %v failures, open until %v\n",
+			bs.Remote, bs.Failures, formatTime(bs.OpenUntil))
+	}
+
+	return tw.Flush()
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+
+	return t.Local().Format(time.DateTime)
+}
+
+func pause(args []string) error {
+	return controlSyncs("pause", args, (*svc.Client).Pause)
+}
+
+func resume(args []string) error {
+	return controlSyncs("resume", args, (*svc.Client).Resume)
+}
+
+func reloadCmd(args []string) error {
+	return controlSyncs("reload", args, func(c *svc.Client, ctx context.Context, names ...string) error {
+		if len(names) != 0 {
+			return errors.New("usage: giggle reload")
+		}
+		return c.Reload(ctx)
+	})
+}
+
+// controlSyncs runs the control API call against the running giggle.
+func controlSyncs(name string, args []string,
+	call func(*svc.Client, context.Context, ...string) error) error {
+
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if pid, err := runningPid(); err != nil {
+		return err
+	} else if pid == 0 {
+		return errors.New("giggle is not running")
+	}
+
+	return call(svc.NewClient(conf.SocketFilePath()), context.Background(), fs.Args()...)
+}
+
+func history(args []string) error {
+	var q svc.HistoryQuery
+	var since time.Duration
+	var asJSON bool
+	fs := flag.NewFlagSet("history", flag.ContinueOnError)
+	fs.DurationVar(&since, "since", 0, "only show the runs in this duration, e.g. 24h")
+	fs.IntVar(&q.Limit, "n", 0, "only show the last n runs")
+	fs.StringVar(&q.Commit, "commit", "", "only show the runs that pushed this commit or a descendant")
+	fs.StringVar(&q.Target, "target", "", "only consider the pushes to this repo for -commit")
+	fs.BoolVar(&asJSON, "json", false, "print the records as JSON lines")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() > 1 {
+		return errors.New("usage: giggle history [flags] [name]")
+	}
+	q.Sync = fs.Arg(0)
+	if since > 0 {
+		q.Since = time.Now().Add(-since)
+	}
+
+	records, err := svc.ReadHistory(conf.HistoryFilePath(), q)
+	if err != nil {
+		return err
+	}
+
+	if asJSON {
+		enc := json.NewEncoder(os.Stdout)
+		for _, rec := range records {
+			if err := enc.Encode(rec); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	tw := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "STARTED\tSYNC\tTRIGGER\tDURATION\tPUSHED\tERROR")
+	for _, rec := range records {
+		var pushed []string
+		for remote, branches := range rec.Pushed {
+			for branch, hash := range branches {
+				pushed = append(pushed, fmt.Sprintf("%v/%v@%.8v", remote, branch, hash))
+			}
+		}
+		sort.Strings(pushed)
+
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\t%v\n", formatTime(rec.StartedAt), rec.Sync, rec.Trigger,
+			rec.Duration.Round(time.Millisecond), strings.Join(pushed, ","), rec.Error)
+	}
+
+	return tw.Flush()
+}
+
+func validate(args []string) error {
+	fs := flag.NewFlagSet("validate", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	if _, err := readValidConfig(); err != nil {
+		return err
+	}
+
+	fmt.Println("config is valid")
+	return nil
+}
+
+func add(args []string) error {
+	var fromAuth, toAuth, branches, direction, cron string
+	var period time.Duration
+	fs := flag.NewFlagSet("add", flag.ContinueOnError)
+	fs.StringVar(&fromAuth, "from-auth", "", "auth to use for the `from` repo")
+	fs.StringVar(&toAuth, "to-auth", "", "auth to use for the `to` repos")
+	fs.StringVar(&branches, "branches", "", "comma separated branch rules, e.g. master->main")
+	fs.StringVar(&direction, "direction", "", "direction of the sync, one-way or both")
+	fs.DurationVar(&period, "period", 0, "period of the sync, overrides the global period")
+	fs.StringVar(&cron, "cron", "", "cron expression for the sync, overrides the period")
+	fs.Usage = func() {
+		fmt.Fprintln(fs.Output(), "usage: giggle add [flags] <name> <from-url> <to-url>...")
+		fs.PrintDefaults()
+	}
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() < 3 {
+		fs.Usage()
+		return errors.New("a name, a from URL and at least one to URL are required")
+	}
+
+	sc := conf.SyncConfig{
+		Name:      fs.Arg(0),
+		From:      conf.Repo{Name: repoName(fs.Arg(1), nil), URLToRepo: fs.Arg(1), AuthToUse: fromAuth},
+		Direction: direction,
+		Cron:      cron,
+	}
+	sc.Period.Duration = period
+
+	used := map[string]bool{sc.From.Name: true}
+	for _, rawURL := range fs.Args()[2:] {
+		name := repoName(rawURL, used)
+		used[name] = true
+		sc.ToList = append(sc.ToList, conf.Repo{Name: name, URLToRepo: rawURL, AuthToUse: toAuth})
+	}
+
+	if branches != "" {
+		for _, s := range strings.Split(branches, ",") {
+			br, err := conf.ParseBranchRule(strings.TrimSpace(s))
+			if err != nil {
+				return err
+			}
+			sc.Branches = append(sc.Branches, br)
+		}
+	}
+
+	if err := conf.AddSync(conf.SettingsFilePath(), sc); err != nil {
+		return err
+	}
+
+	fmt.Printf("added sync %v\n", sc.Name)
+	return nil
+}
+
+// repoName returns a name for the repo derived from the host of its URL, e.g. github for
+// https://github.com/user/repo, which is different from the names that are already used.
+func repoName(rawURL string, used map[string]bool) string {
+	host := rawURL
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		host = u.Hostname()
+	} else {
+		if _, after, ok := strings.Cut(host, "@"); ok {
+			host = after
+		}
+		host, _, _ = strings.Cut(host, ":")
+	}
+
+	name := "repo"
+	if labels := strings.Split(host, "."); len(labels) > 1 {
+		name = labels[len(labels)-2]
+	} else if host != "" {
+		name = host
+	}
+
+	candidate := name
+	for i := 2; used[candidate]; i++ {
+		candidate = fmt.Sprintf("%v-%v", name, i)
+	}
+
+	return candidate
+}
+
+func remove(args []string) error {
+	var purge bool
+	fs := flag.NewFlagSet("remove", flag.ContinueOnError)
+	fs.BoolVar(&purge, "purge", false, "delete the local clone of the sync as well")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if fs.NArg() != 1 {
+		return errors.New("usage: giggle remove [-purge] <name>")
+	}
+
+	name := fs.Arg(0)
+	if err := conf.RemoveSync(conf.SettingsFilePath(), name); err != nil {
+		return err
+	}
+	if purge {
+		// the running giggle deletes the clone, as it may be syncing it
+		if pid, err := runningPid(); err != nil {
+			return err
+		} else if pid != 0 {
+			if err := svc.NewClient(conf.SocketFilePath()).Purge(context.Background(), name); err != nil {
+				return err
+			}
+		} else if err := os.RemoveAll(conf.GetSyncTarget(name)); err != nil {
+			return fmt.Errorf("error deleting local clone :: %w", err)
+		}
+	}
+
+	fmt.Printf("removed sync %v\n", name)
+	return nil
+}
+
+func stop(args []string) error {
+	fs := flag.NewFlagSet("stop", flag.ContinueOnError)
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	pid, err := runningPid()
+	if err != nil {
+		return err
+	}
+	if pid == 0 {
+		return errors.New("giggle is not running")
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return fmt.Errorf("error finding process %v :: %w", pid, err)
+	}
+	if err := process.Signal(syscall.SIGTERM); err != nil {
+		return fmt.Errorf("error signalling process %v :: %w", pid, err)
+	}
+
+	for deadline := time.Now().Add(cStopTimeout); time.Now().Before(deadline); {
+		if !processAlive(pid) {
+			fmt.Printf("stopped giggle with pid %v\n", pid)
+			return nil
+		}
+		time.Sleep(cStopPollPeriod)
+	}
+
+	return fmt.Errorf("giggle with pid %v didn't stop within %v", pid, cStopTimeout)
+}
+
+// runningPid returns the pid of the running giggle, or 0 if giggle isn't running.
+func runningPid() (int, error) {
+	pid, err := daemon.ReadPidFile(conf.PidFilePath())
+	if errors.Is(err, os.ErrNotExist) {
+		return 0, nil
+	} else if err != nil {
+		return 0, fmt.Errorf("error reading pid file :: %w", err)
+	}
+
+	// the pid file is left behind if giggle crashed
+	if !processAlive(pid) {
+		return 0, nil
+	}
+
+	return pid, nil
+}
+
+func processAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	return process.Signal(syscall.Signal(0)) == nil
+}
+
+func logs(args []string) error {
+	var follow bool
+	var lines int
+	fs := flag.NewFlagSet("logs", flag.ContinueOnError)
+	fs.BoolVar(&follow, "f", false, "follow the log file as it grows")
+	fs.IntVar(&lines, "n", cLogsDefaultTail, "number of lines to print from the end, 0 for all")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	logFilePath := conf.LogFilePath()
+	f, err := os.Open(logFilePath)
+	if err != nil {
+		return fmt.Errorf("error opening log file :: %w", err)
+	}
+	defer func() { f.Close() }()
+
+	if err := printTail(f, lines); err != nil {
+		return err
+	}
+	if !follow {
+		return nil
+	}
+
+	ctx, cancel := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer cancel()
+	ticker := time.NewTicker(cLogsPollPeriod)
+	defer ticker.Stop()
+	for {
+		if _, err := io.Copy(os.Stdout, f); err != nil {
+			return fmt.Errorf("error reading log file :: %w", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		// the log file is replaced by a new one when it is rotated
+		current, errCurrent := os.Stat(logFilePath)
+		opened, errOpened := f.Stat()
+		if errCurrent != nil || errOpened != nil || os.SameFile(current, opened) {
+			continue
+		}
+		if rotated, err := os.Open(logFilePath); err == nil {
+			if _, err := io.Copy(os.Stdout, f); err != nil {
+				return fmt.Errorf("error reading log file :: %w", err)
+			}
+			f.Close()
+			f = rotated
+		}
+	}
+}
+
+// printTail prints the last n lines of the file, or the whole file if n is 0.
+func printTail(f *os.File, n int) error {
+	var tail []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		tail = append(tail, scanner.Text())
+		if n > 0 && len(tail) > n {
+			tail = tail[1:]
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading log file :: %w", err)
+	}
+
+	for _, line := range tail {
+		fmt.Println(line)
+	}
+
+	return nil
+}
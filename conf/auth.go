@@ -1,26 +1,79 @@
 package conf
 
 import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
 	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	sshagent "github.com/xanzy/ssh-agent"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
+const cDefaultSSHUser = "git"
+
 // AuthMethod is a wrapper around authorization methods for git authentication.
+// The http methods are used for http(s) URLs, and SSH for ssh URLs.
 type AuthMethod struct {
 	*http.BasicAuth
 	*http.TokenAuth
+	SSH *SSHAuth `json:"ssh"`
+}
+
+// SSHAuth stores configuration for authenticating ssh remotes, either
+// using a private key file or using the keys loaded in ssh-agent.
+type SSHAuth struct {
+	User       string `json:"user"`
+	KeyPath    string `json:"key_path"`
+	Passphrase string `json:"passphrase"`
+	Agent      bool   `json:"agent"`
+
+	// KnownHosts is the path to the known_hosts file used for verifying the host
+	// key of the remote. The default known_hosts files are used if it is empty.
+	KnownHosts            string `json:"known_hosts"`
+	InsecureIgnoreHostKey bool   `json:"insecure_ignore_host_key"`
+}
+
+// IsSSHURL returns whether the URL of a repo uses the ssh protocol,
+// including the scp like syntax, e.g. git@github.com:user/repo.git.
+func IsSSHURL(rawURL string) bool {
+	ep, err := transport.NewEndpoint(rawURL)
+	return err == nil && ep.Protocol == "ssh"
+}
+
+func isHTTPURL(rawURL string) bool {
+	ep, err := transport.NewEndpoint(rawURL)
+	return err == nil && (ep.Protocol == "http" || ep.Protocol == "https")
 }
 
-// GetAuth returns the non nil authorization method.
-func (m *AuthMethod) GetAuth() http.AuthMethod {
+// GetAuth returns the authorization method to use for the given URL of a repo.
+func (m *AuthMethod) GetAuth(rawURL string) (transport.AuthMethod, error) {
 	if m == nil {
-		return nil
+		return nil, nil
+	}
+
+	if IsSSHURL(rawURL) {
+		if m.SSH == nil {
+			return nil, fmt.Errorf("no ssh auth configured for [%v]", rawURL)
+		}
+		return m.SSH.authMethod()
 	}
 
 	if m.BasicAuth != nil {
-		return m.BasicAuth
+		return m.BasicAuth, nil
+	}
+	if m.TokenAuth != nil {
+		return m.TokenAuth, nil
 	}
 
-	return m.TokenAuth
+	return nil, fmt.Errorf("no http auth configured for [%v]", rawURL)
 }
 
 func (m *AuthMethod) String() string {
@@ -28,5 +81,111 @@ func (m *AuthMethod) String() string {
 		return ""
 	}
 
-	return m.GetAuth().String()
+	var methods []string
+	if m.BasicAuth != nil {
+		methods = append(methods, m.BasicAuth.String())
+	}
+	if m.TokenAuth != nil {
+		methods = append(methods, m.TokenAuth.String())
+	}
+	if m.SSH != nil {
+		methods = append(methods, m.SSH.String())
+	}
+
+	return strings.Join(methods, ", ")
+}
+
+func (sa *SSHAuth) user() string {
+	if sa.User == "" {
+		return cDefaultSSHUser
+	}
+
+	return sa.User
+}
+
+func (sa *SSHAuth) authMethod() (transport.AuthMethod, error) {
+	var cb ssh.HostKeyCallback
+	switch {
+	case sa.InsecureIgnoreHostKey:
+		cb = ssh.InsecureIgnoreHostKey() //nolint:gosec
+	case sa.KnownHosts != "":
+		var err error
+		if cb, err = gitssh.NewKnownHostsCallback(ExpandHome(sa.KnownHosts)); err != nil {
+			return nil, fmt.Errorf("error loading known hosts [%v] :: %w", sa.KnownHosts, err)
+		}
+	}
+
+	if sa.Agent {
+		if !sshagent.Available() {
+			return nil, errors.New("error connecting to ssh-agent :: SSH_AUTH_SOCK is not set")
+		}
+		am := &gitssh.PublicKeysCallback{User: sa.user(), Callback: sharedAgent.signers}
+		am.HostKeyCallback = cb
+		return am, nil
+	}
+
+	am, err := gitssh.NewPublicKeysFromFile(sa.user(), ExpandHome(sa.KeyPath), sa.Passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("error loading ssh key [%v] :: %w", sa.KeyPath, err)
+	}
+	am.HostKeyCallback = cb
+	return am, nil
+}
+
+// sharedAgent is the connection to ssh-agent that all the auth methods using the agent share,
+// rather than connecting to the agent for every remote operation.
+var sharedAgent = &agentConn{}
+
+// agentConn is a connection to ssh-agent, that is opened again if the agent went away.
+type agentConn struct {
+	mu     sync.Mutex
+	client agent.Agent
+	conn   net.Conn
+}
+
+// signers returns the signers of the keys loaded in ssh-agent.
+func (ac *agentConn) signers() ([]ssh.Signer, error) {
+	ac.mu.Lock()
+	defer ac.mu.Unlock()
+
+	if ac.client != nil {
+		if signers, err := ac.client.Signers(); err == nil {
+			return signers, nil
+		}
+		// the agent restarted, or SSH_AUTH_SOCK changed since
+		if ac.conn != nil {
+			_ = ac.conn.Close()
+		}
+		ac.client, ac.conn = nil, nil
+	}
+
+	client, conn, err := sshagent.New()
+	if err != nil {
+		return nil, fmt.Errorf("error connecting to ssh-agent :: %w", err)
+	}
+	ac.client, ac.conn = client, conn
+
+	return client.Signers()
+}
+
+func (sa *SSHAuth) String() string {
+	if sa.Agent {
+		return fmt.Sprintf("ssh-agent - user: %s", sa.user())
+	}
+
+	return fmt.Sprintf("ssh-key - user: %s, key: %s", sa.user(), sa.KeyPath)
+}
+
+// ExpandHome replaces a leading ~ in the path with the home directory of the user.
+func ExpandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+
+	return filepath.Join(home, path[1:])
 }
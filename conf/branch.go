@@ -0,0 +1,142 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"path"
+	"strings"
+)
+
+const cBranchRenameSep = "->"
+
+// BranchRule selects source branches matching Pattern and optionally renames them.
+// It is written in config as "pattern" or "pattern->rename", e.g. "master->main".
+// When both Pattern and Rename contain a single '*', the part of the branch name
+// matched by '*' is substituted in Rename, e.g. "feature/*->overleaf/*". That '*'
+// may not be escaped or be part of a character class.
+// Patterns follow path.Match, hence '*' doesn't match a '/'.
+type BranchRule struct {
+	Pattern string
+	Rename  string
+}
+
+// BranchPolicy is the list of branch rules of a sync. An empty policy selects
+// every branch of the source repo without renaming it.
+type BranchPolicy []BranchRule
+
+// ParseBranchRule parses a branch rule in the "pattern" or "pattern->rename" format.
+func ParseBranchRule(s string) (BranchRule, error) {
+	var br BranchRule
+	parts := strings.Split(s, cBranchRenameSep)
+	switch len(parts) {
+	case 1:
+		br.Pattern = strings.TrimSpace(parts[0])
+	case 2:
+		br.Pattern = strings.TrimSpace(parts[0])
+		br.Rename = strings.TrimSpace(parts[1])
+		if br.Rename == "" {
+			return br, fmt.Errorf("empty branch rename in rule [%v]", s)
+		}
+	default:
+		return br, fmt.Errorf("invalid branch rule [%v]", s)
+	}
+
+	if br.Pattern == "" {
+		return br, fmt.Errorf("empty branch pattern in rule [%v]", s)
+	}
+	if _, err := path.Match(br.Pattern, ""); err != nil {
+		return br, fmt.Errorf("invalid branch pattern [%v] :: %w", br.Pattern, err)
+	}
+	if strings.Count(br.Rename, "*") > 1 ||
+		(strings.Contains(br.Rename, "*") && strings.Count(br.Pattern, "*") != 1) {
+		return br, fmt.Errorf("rename [%v] needs exactly one '*' in both sides", s)
+	}
+	if strings.Contains(br.Rename, "*") {
+		prefix, suffix, _ := strings.Cut(br.Pattern, "*")
+		_, errPrefix := path.Match(prefix, "")
+		_, errSuffix := path.Match(suffix, "")
+		if errPrefix != nil || errSuffix != nil {
+			return br, fmt.Errorf("'*' of [%v] must not be escaped or in a character class", s)
+		}
+	}
+
+	return br, nil
+}
+
+// Target returns the name of the target branch for the given source branch
+// and whether the branch is selected by the rule at all.
+func (br BranchRule) Target(branch string) (string, bool) {
+	if ok, _ := path.Match(br.Pattern, branch); !ok {
+		return "", false
+	}
+
+	if br.Rename == "" {
+		return branch, true
+	}
+
+	if !strings.Contains(br.Rename, "*") {
+		return br.Rename, true
+	}
+
+	// exactly one '*' on both sides is ensured while parsing the rule. The parts of the pattern
+	// around it may have character classes or escapes, hence the text matched by '*' is found by
+	// matching the parts rather than by their lengths.
+	prefix, suffix, _ := strings.Cut(br.Pattern, "*")
+	for i := 0; i <= len(branch); i++ {
+		if ok, _ := path.Match(prefix, branch[:i]); !ok {
+			continue
+		}
+		for j := len(branch); j >= i; j-- {
+			if ok, _ := path.Match(suffix, branch[j:]); ok && !strings.Contains(branch[i:j], "/") {
+				return strings.Replace(br.Rename, "*", branch[i:j], 1), true
+			}
+		}
+	}
+
+	return "", false
+}
+
+func (br BranchRule) String() string {
+	if br.Rename == "" {
+		return br.Pattern
+	}
+
+	return br.Pattern + cBranchRenameSep + br.Rename
+}
+
+// UnmarshalJSON parses the branch rule from a JSON string.
+func (br *BranchRule) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("branch rule must be a string :: %w", err)
+	}
+
+	parsed, err := ParseBranchRule(s)
+	if err != nil {
+		return err
+	}
+
+	*br = parsed
+	return nil
+}
+
+// MarshalJSON writes the branch rule as a JSON string.
+func (br BranchRule) MarshalJSON() ([]byte, error) {
+	return json.Marshal(br.String())
+}
+
+// Target returns the target branch for the given source branch using the
+// first matching rule, and whether the branch needs to be synced at all.
+func (bp BranchPolicy) Target(branch string) (string, bool) {
+	if len(bp) == 0 {
+		return branch, true
+	}
+
+	for _, br := range bp {
+		if target, ok := br.Target(branch); ok {
+			return target, true
+		}
+	}
+
+	return "", false
+}
@@ -8,24 +8,171 @@ import (
 
 // Config stores all the configuration.
 type Config struct {
-	Sync   []SyncConfig           `json:"sync"`
-	Auth   map[string]*AuthMethod `json:"auth"`
-	Period duration               `json:"period"`
+	Sync        []SyncConfig           `json:"sync"`
+	Auth        map[string]*AuthMethod `json:"auth"`
+	Period      duration               `json:"period"`
+	Concurrency ConcurrencyConfig      `json:"concurrency"`
+	Retry       RetryConfig            `json:"retry"`
+	Breaker     BreakerConfig          `json:"breaker"`
+	Notify      NotifyConfig           `json:"notify"`
+	Metrics     MetricsConfig          `json:"metrics"`
+	Logging     LoggingConfig          `json:"logging"`
+	Paths       PathsConfig            `json:"paths"`
+
+	// problems are the keys in the config file that don't map to any
+	// field, and the secret references that couldn't be resolved.
+	problems ValidationErrors
+}
+
+// ConcurrencyConfig limits how many syncs and remote operations run in parallel.
+type ConcurrencyConfig struct {
+	MaxSyncs   int `json:"max_syncs"`
+	MaxPerHost int `json:"max_per_host"`
+}
+
+// SyncLimit returns the maximum number of syncs that can run at the same time.
+func (cc ConcurrencyConfig) SyncLimit() int {
+	if cc.MaxSyncs <= 0 {
+		return cDefaultMaxSyncs
+	}
+
+	return cc.MaxSyncs
+}
+
+// HostLimit returns the maximum number of fetches and pushes
+// that can run against a single host at the same time.
+func (cc ConcurrencyConfig) HostLimit() int {
+	if cc.MaxPerHost <= 0 {
+		return cDefaultMaxPerHost
+	}
+
+	return cc.MaxPerHost
+}
+
+// MetricsConfig exposes the metrics of the syncs for Prometheus on
+// Listen, e.g. "127.0.0.1:9090", at Path. It is disabled if Listen is empty.
+type MetricsConfig struct {
+	Listen string `json:"listen,omitempty"`
+	Path   string `json:"path,omitempty"`
+}
+
+// MetricsPath returns the HTTP path at which the metrics are served.
+func (mc MetricsConfig) MetricsPath() string {
+	if mc.Path == "" {
+		return cDefaultMetricsPath
+	}
+
+	return mc.Path
 }
 
+// Sync directions, see SyncConfig.Direction.
+const (
+	// DirectionOneWay pushes changes from the `from` repo to the `to` repos.
+	DirectionOneWay = "one-way"
+	// DirectionBoth fast forwards whichever of the `from` or `to` repos is behind.
+	DirectionBoth = "both"
+)
+
+// Conflict policies for diverged histories in DirectionBoth, see SyncConfig.OnConflict.
+const (
+	// ConflictStop records the conflict and pushes nothing for the branch.
+	ConflictStop = "stop"
+	// ConflictMerge merges the diverged histories and pushes the merge to both sides.
+	ConflictMerge = "merge"
+)
+
+// Push policies of the `to` repos for branches whose history diverged, see Repo.PushPolicy.
+const (
+	// PushFastForwardOnly records the diverged branch as a conflict and doesn't push it.
+	PushFastForwardOnly = "fast-forward-only"
+	// PushForceWithLease overwrites the diverged branch only if it is still at the commit
+	// that giggle last pushed to it, i.e. if only the `from` repo was rewritten.
+	PushForceWithLease = "force-with-lease"
+	// PushForce overwrites the diverged branch.
+	PushForce = "force"
+)
+
 // SyncConfig stores configuration for completing sync.
 type SyncConfig struct {
-	Name   string `json:"name"`
-	From   Repo   `json:"from"`
-	ToList []Repo `json:"to"`
+	Name       string       `json:"name"`
+	From       Repo         `json:"from,omitzero"`
+	Sources    []Source     `json:"sources,omitempty"`
+	ToList     []Repo       `json:"to"`
+	Branches   BranchPolicy `json:"branches,omitempty"`
+	Direction  string       `json:"direction,omitempty"`
+	OnConflict string       `json:"conflict,omitempty"`
+	Tags       *TagConfig   `json:"tags,omitempty"`
+
+	// Period overrides the global period for the sync, Cron overrides both.
+	// The sync is skipped when triggered outside of ActiveHours, if set.
+	Period      duration    `json:"period,omitzero"`
+	Cron        string      `json:"cron,omitempty"`
+	ActiveHours hoursWindow `json:"active_hours,omitzero"`
+}
+
+// FromRepos returns the `from` repos of the sync, i.e. its sources if it has any.
+func (sc SyncConfig) FromRepos() []Repo {
+	if len(sc.Sources) == 0 {
+		return []Repo{sc.From}
+	}
+
+	repos := make([]Repo, len(sc.Sources))
+	for i, src := range sc.Sources {
+		repos[i] = src.Repo
+	}
+	return repos
+}
+
+// Source is one of the `from` repos of a sync that combines several repos in place of `from`.
+// The default branch of the repo is synced to Branch of the `to` repos, into the folder Path.
+// The sources with a Path are combined into their Branch, a source without one is synced to
+// a Branch of its own. Branch is "main" by default.
+type Source struct {
+	Repo
+	Path   string `json:"path,omitempty"`
+	Branch string `json:"branch,omitempty"`
+}
+
+// TargetBranch returns the branch of the `to` repos that the source is synced to.
+func (s Source) TargetBranch() string {
+	if s.Branch == "" {
+		return cDefaultSourceBranch
+	}
+
+	return s.Branch
 }
 
 // Repo is one of the repos (github or overleaf).
 type Repo struct {
 	Name      string `json:"name"`
-	Kind      string `json:"kind"`
+	Kind      string `json:"kind,omitempty"`
 	URLToRepo string `json:"url"`
-	AuthToUse string `json:"auth"`
+	AuthToUse string `json:"auth,omitempty"`
+
+	// Create makes giggle create a `to` repo that doesn't exist through the API of its
+	// host before pushing to it, with Visibility (private by default), Description and
+	// DefaultBranch, which is set once the first push is done.
+	Create        bool   `json:"create,omitempty"`
+	Visibility    string `json:"visibility,omitempty"`
+	Description   string `json:"description,omitempty"`
+	DefaultBranch string `json:"default_branch,omitempty"`
+
+	// PullRequest makes giggle open pull requests to a `to` repo instead of pushing to its branches.
+	PullRequest *PullRequestConfig `json:"pull_request,omitempty"`
+
+	// PushPolicy decides whether the branches of a `to` repo whose history diverged from the
+	// `from` repo are overwritten, PushFastForwardOnly by default. The overwritten commits are
+	// kept in backup refs of the local clone.
+	PushPolicy string `json:"push_policy,omitempty"`
+}
+
+// PullRequestConfig makes giggle push the synced branches of a `to` repo to Branch instead,
+// and open a pull request, or update the open one, from Branch into each of them. A '*' in
+// Branch is replaced by the name of the synced branch, and is required to sync more than one
+// branch. Branch is "<name of the from repo>-sync" by default, and Title "Sync from <name>".
+type PullRequestConfig struct {
+	Branch string `json:"branch,omitempty"`
+	Title  string `json:"title,omitempty"`
 }
 
 // ReadConfig reads the config from file on disk.
@@ -40,11 +187,16 @@ func ReadConfig(configFile string) (*Config, error) {
 		return nil, fmt.Errorf("error reading conf file :: %w", err)
 	}
 
+	problems := checkFields(data)
 	var config Config
 	err = json.Unmarshal(data, &config)
 	if err != nil {
+		if len(problems) > 0 {
+			err = problems
+		}
 		return nil, fmt.Errorf("error unmarshalling conf file :: %w", err)
 	}
 
+	config.problems = append(problems, config.resolveSecrets()...)
 	return &config, nil
 }
@@ -1,7 +1,24 @@
 package conf
 
 import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
 	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	gitssh "github.com/go-git/go-git/v5/plumbing/transport/ssh"
+	"github.com/zalando/go-keyring"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 func TestReadConfig(t *testing.T) {
@@ -9,3 +26,532 @@ func TestReadConfig(t *testing.T) {
 		t.Fatalf("error in loading config :: %v", err)
 	}
 }
+
+func TestBranchRule(t *testing.T) {
+	tests := []struct {
+		rule   string
+		branch string
+		target string
+		ok     bool
+	}{
+		{"master", "master", "master", true},
+		{"master", "main", "", false},
+		{"master->main", "master", "main", true},
+		{"feature/*", "feature/a", "feature/a", true},
+		{"feature/*", "feature/a/b", "", false},
+		{"feature/*->overleaf/*", "feature/a", "overleaf/a", true},
+		{"v*-rc->rc/*", "v1.2-rc", "rc/1.2", true},
+		{"[ab]x*->y/*", "ax1", "y/1", true},
+		{`rel\-*->r/*`, "rel-2.0", "r/2.0", true},
+		{"?-*-[0-9]->fix/*", "a-crash-1", "fix/crash", true},
+	}
+	for _, tc := range tests {
+		br, err := ParseBranchRule(tc.rule)
+		if err != nil {
+			t.Fatalf("error parsing branch rule [%v] :: %v", tc.rule, err)
+		}
+		target, ok := br.Target(tc.branch)
+		if ok != tc.ok || target != tc.target {
+			t.Fatalf("unexpected target for [%v] with [%v]: %v, %v", tc.branch, tc.rule, target, ok)
+		}
+	}
+
+	for _, rule := range []string{"", "a->b->c", "a->", "->b", "[->b", "a->*", "a*b*->c*", `a\*->b/*`,
+		"[*]->b/*"} {
+		if _, err := ParseBranchRule(rule); err == nil {
+			t.Fatalf("expected error parsing branch rule [%v]", rule)
+		}
+	}
+}
+
+func TestHoursWindow(t *testing.T) {
+	at := func(hour, min int) time.Time {
+		return time.Date(2020, 5, 1, hour, min, 0, 0, time.Local)
+	}
+
+	var w hoursWindow
+	if !w.Contains(at(3, 0)) {
+		t.Fatal("zero window should contain every time")
+	}
+
+	if err := json.Unmarshal([]byte(`"08:00-20:00"`), &w); err != nil {
+		t.Fatalf("error parsing hours window :: %v", err)
+	}
+	if !w.Contains(at(8, 0)) || !w.Contains(at(19, 59)) || w.Contains(at(20, 0)) || w.Contains(at(3, 0)) {
+		t.Fatalf("unexpected window: %v", w)
+	}
+
+	if err := json.Unmarshal([]byte(`"22:00-06:00"`), &w); err != nil {
+		t.Fatalf("error parsing hours window :: %v", err)
+	}
+	if !w.Contains(at(23, 0)) || !w.Contains(at(5, 0)) || w.Contains(at(12, 0)) {
+		t.Fatalf("unexpected wrapped window: %v", w)
+	}
+
+	for _, s := range []string{`"08:00"`, `"8-20"`, `"10:00-10:00"`, `10`} {
+		if err := json.Unmarshal([]byte(s), &w); err == nil {
+			t.Fatalf("expected error parsing hours window %v", s)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	cf, err := ReadConfig("../config.json.example")
+	if err != nil {
+		t.Fatalf("error in loading config :: %v", err)
+	}
+	if err := cf.Validate(); err != nil {
+		t.Fatalf("example config is invalid :: %v", err)
+	}
+
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	data := `{
+  "priod": "1m",
+  "sync": [
+    {
+      "name": "paper",
+      "from": {"name": "overleaf", "url": "https://git.overleaf.com/abc", "auth": "overleaf", "pull_request": {}},
+      "to": [
+        {"name": "github", "url": "", "auth": "gh", "craete": true},
+        {"name": "gitlab", "url": "https://gitlab.com/u/paper", "pull_request": {"branch": "*-*"}, "push_policy": "rebase"}
+      ]
+    },
+    {
+      "name": "paper",
+      "cron": "every day",
+      "direction": "sideways",
+      "from": {"name": "overleaf", "url": "https://git.overleaf.com/def"},
+      "to": [{"name": "overleaf", "kind": "overleaf", "url": "https://github.com/u/def", "create": true, "visibility": "secret"}]
+    },
+    {
+      "name": "../escape",
+      "from": {"name": "overleaf", "kind": "sourcehut", "url": "https://git.overleaf.com/ghi"}
+    },
+    {
+      "name": "thesis",
+      "period": "1h",
+      "from": {"name": "overleaf", "url": "https://git.overleaf.com/jkl"},
+      "sources": [
+        {"name": "intro", "url": "https://git.overleaf.com/c1", "path": "chapters/intro"},
+        {"name": "figures", "url": "https://git.overleaf.com/c2", "path": "chapters/intro/figures"},
+        {"name": "slides", "url": "https://git.overleaf.com/s", "path": "../slides", "branch": "slides"},
+        {"name": "poster", "url": "https://git.overleaf.com/p", "branch": "slides"}
+      ],
+      "to": [{"name": "github", "url": "https://github.com/u/thesis"}]
+    }
+  ],
+  "auth": {"overleaf": {"username": "a@b.c", "password": "x", "tokn": "y"}},
+  "notify": {
+    "desktop": {"events": ["failed", "pushed"]},
+    "webhooks": [
+      {"url": "env:SLACK_WEBHOOK", "format": "slack"},
+      {"url": "ftp://example.com", "format": "teams", "template": "{{.Sync"}
+    ],
+    "email": {"host": "smtp.example.com", "from": "giggle@example.com"}
+  },
+  "logging": {"level": "verbose", "format": "xml", "max_backups": -1}
+}`
+	if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing config :: %v", err)
+	}
+	cf, err = ReadConfig(configFile)
+	if err != nil {
+		t.Fatalf("error in loading config :: %v", err)
+	}
+
+	var ve ValidationErrors
+	if !errors.As(cf.Validate(), &ve) {
+		t.Fatal("expected validation errors")
+	}
+	paths := make(map[string]bool)
+	for _, e := range ve {
+		paths[e.Path] = true
+	}
+	expected := []string{
+		"priod",
+		"sync[0].to[0].craete",
+		"auth.overleaf.tokn",
+		"sync[0].period",
+		"sync[0].to[0].url",
+		"sync[0].to[0].auth",
+		"sync[0].from.pull_request",
+		"sync[0].to[1].pull_request.branch",
+		"sync[0].to[1].auth",
+		"sync[0].to[1].push_policy",
+		"sync[1].name",
+		"sync[1].cron",
+		"sync[1].direction",
+		"sync[1].to[0].name",
+		"sync[1].to[0].url",
+		"sync[1].to[0].create",
+		"sync[1].to[0].visibility",
+		"sync[1].to[0].auth",
+		"sync[2].name",
+		"sync[2].from.kind",
+		"sync[2].period",
+		"sync[2].to",
+		"sync[3].from",
+		"sync[3].sources[1].path",
+		"sync[3].sources[2].path",
+		"sync[3].sources[3].branch",
+		"notify.desktop.events[1]",
+		"notify.webhooks[0].url",
+		"notify.webhooks[1].url",
+		"notify.webhooks[1].format",
+		"notify.webhooks[1].template",
+		"notify.email.to",
+		"logging.level",
+		"logging.format",
+		"logging.max_backups",
+	}
+	for _, path := range expected {
+		if !paths[path] {
+			t.Fatalf("expected error for %v, got:\n%v", path, ve)
+		}
+	}
+	if len(ve) != len(expected) {
+		t.Fatalf("unexpected errors:\n%v", ve)
+	}
+}
+
+func TestSetup(t *testing.T) {
+	saved := startup
+	defer func() { startup = saved }()
+
+	home := t.TempDir()
+	t.Setenv(HomeEnv(), home)
+	if err := Setup(""); err != nil {
+		t.Fatalf("error setting up :: %v", err)
+	}
+	if SettingsFilePath() != filepath.Join(home, "config.json") || GetSyncTarget("paper") !=
+		filepath.Join(home, "repos", "paper") || LogFileMaxSize() != cLogFileMaxSize {
+		t.Fatalf("unexpected defaults: %v, %v", SettingsFilePath(), GetSyncTarget("paper"))
+	}
+
+	groupDir := filepath.Join(t.TempDir(), "group")
+	configFile := filepath.Join(groupDir, "config.json")
+	data := `{
+  "paths": {"data": "data", "repos": "/srv/repos"},
+  "logging": {"max_size_mb": 10, "max_backups": 2}
+}`
+	if err := os.MkdirAll(groupDir, DirPerm()); err != nil {
+		t.Fatalf("error creating folder :: %v", err)
+	}
+	if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing config :: %v", err)
+	}
+	if err := Setup(configFile); err != nil {
+		t.Fatalf("error setting up :: %v", err)
+	}
+
+	dataDir := filepath.Join(groupDir, "data")
+	for _, tc := range []struct{ got, want string }{
+		{SettingsFilePath(), configFile},
+		{PidFilePath(), filepath.Join(dataDir, "giggle.pid")},
+		{LogFilePath(), filepath.Join(dataDir, "log", "giggle.log")},
+		{GetSyncTarget("paper"), filepath.Join("/srv/repos", "paper")},
+	} {
+		if tc.got != tc.want {
+			t.Fatalf("unexpected path %v, expected %v", tc.got, tc.want)
+		}
+	}
+	if LogFileMaxSize() != 10 || LogMaxNumBackups() != 2 || LogFileMaxAge() != cLogFileMaxAge {
+		t.Fatalf("unexpected rotation: %v, %v, %v", LogFileMaxSize(), LogMaxNumBackups(), LogFileMaxAge())
+	}
+}
+
+func TestHostKind(t *testing.T) {
+	for _, tc := range []struct {
+		repo Repo
+		kind string
+	}{
+		{Repo{URLToRepo: "https://git.overleaf.com/abcdef"}, KindOverleaf},
+		{Repo{URLToRepo: "git@github.com:user/paper.git"}, KindGitHub},
+		{Repo{URLToRepo: "https://codeberg.org/user/paper"}, KindGitea},
+		{Repo{URLToRepo: "/srv/git/paper"}, KindLocal},
+		{Repo{URLToRepo: "https://git.example.com/paper"}, KindGeneric},
+		{Repo{Kind: KindGitLab, URLToRepo: "https://git.example.com/paper"}, KindGitLab},
+	} {
+		if kind := tc.repo.HostKind(); kind != tc.kind {
+			t.Fatalf("unexpected kind of %v: %v", tc.repo.URLToRepo, kind)
+		}
+	}
+
+	for rawURL, valid := range map[string]bool{
+		"https://git.overleaf.com/abcdef":          true,
+		"https://www.overleaf.com/project/abcdef":  true,
+		"https://git.overleaf.com/abcdef/settings": false,
+		"https://www.overleaf.com/abcdef":          false,
+		"git@git.overleaf.com:abcdef":              false,
+	} {
+		if id, ok := OverleafProjectID(rawURL); ok != valid || (ok && id != "abcdef") {
+			t.Fatalf("unexpected project id of %v: %v, %v", rawURL, id, ok)
+		}
+	}
+}
+
+func TestReadConfigTypeErrors(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(configFile, []byte(`{"period": 60, "sync": [{"name": 1}]}`), 0600); err != nil {
+		t.Fatalf("error writing config :: %v", err)
+	}
+
+	_, err := ReadConfig(configFile)
+	var ve ValidationErrors
+	if !errors.As(err, &ve) || len(ve) != 2 || ve[0].Path != "period" || ve[1].Path != "sync[0].name" {
+		t.Fatalf("unexpected error :: %v", err)
+	}
+}
+
+func TestGetAuth(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key :: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(key, "")
+	if err != nil {
+		t.Fatalf("error marshalling key :: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("error writing key :: %v", err)
+	}
+
+	am := &AuthMethod{
+		TokenAuth: &http.TokenAuth{Token: "token"},
+		SSH:       &SSHAuth{KeyPath: keyPath, InsecureIgnoreHostKey: true},
+	}
+	for _, rawURL := range []string{"git@github.com:user/repo.git", "ssh://git@github.com/user/repo.git"} {
+		auth, err := am.GetAuth(rawURL)
+		if err != nil {
+			t.Fatalf("error getting auth for %v :: %v", rawURL, err)
+		}
+		pk, ok := auth.(*gitssh.PublicKeys)
+		if !ok || pk.User != "git" {
+			t.Fatalf("unexpected auth for %v: %v", rawURL, auth)
+		}
+	}
+
+	auth, err := am.GetAuth("https://github.com/user/repo")
+	if err != nil {
+		t.Fatalf("error getting auth for https :: %v", err)
+	}
+	if _, ok := auth.(*http.TokenAuth); !ok {
+		t.Fatalf("unexpected auth for https: %v", auth)
+	}
+
+	if _, err := (&AuthMethod{SSH: am.SSH}).GetAuth("https://github.com/user/repo"); err == nil {
+		t.Fatal("expected error for https url without http auth")
+	}
+	if _, err := (&AuthMethod{TokenAuth: am.TokenAuth}).GetAuth("git@github.com:u/r.git"); err == nil {
+		t.Fatal("expected error for ssh url without ssh auth")
+	}
+	if _, err := (&AuthMethod{SSH: &SSHAuth{KeyPath: keyPath + ".missing"}}).GetAuth(
+		"git@github.com:u/r.git"); err == nil {
+		t.Fatal("expected error for missing key")
+	}
+}
+
+func TestSSHAgentAuth(t *testing.T) {
+	_, key, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("error generating key :: %v", err)
+	}
+	keyring := agent.NewKeyring()
+	if err := keyring.Add(agent.AddedKey{PrivateKey: key}); err != nil {
+		t.Fatalf("error adding key to agent :: %v", err)
+	}
+
+	socket := filepath.Join(t.TempDir(), "agent.sock")
+	ln, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("error listening on agent socket :: %v", err)
+	}
+	defer ln.Close()
+	var conns atomic.Int32
+	go func() {
+		for {
+			c, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			conns.Add(1)
+			go func() {
+				defer c.Close()
+				_ = agent.ServeAgent(keyring, c)
+			}()
+		}
+	}()
+	t.Setenv("SSH_AUTH_SOCK", socket)
+
+	// the connection to the agent is shared across the remote operations
+	am := &AuthMethod{SSH: &SSHAuth{Agent: true}}
+	for i := 0; i < 3; i++ {
+		auth, err := am.GetAuth("git@github.com:user/repo.git")
+		if err != nil {
+			t.Fatalf("error getting auth :: %v", err)
+		}
+		signers, err := auth.(*gitssh.PublicKeysCallback).Callback()
+		if err != nil || len(signers) != 1 {
+			t.Fatalf("unexpected signers from agent: %v, %v", signers, err)
+		}
+	}
+	if n := conns.Load(); n != 1 {
+		t.Fatalf("expected a single connection to the agent, got %v", n)
+	}
+
+	t.Setenv("SSH_AUTH_SOCK", "")
+	if _, err := am.GetAuth("git@github.com:user/repo.git"); err == nil {
+		t.Fatal("expected error without ssh-agent")
+	}
+}
+
+func TestResolveSecrets(t *testing.T) {
+	keyring.MockInit()
+	if err := SetKeyringSecret("overleaf", "keyring-pass"); err != nil {
+		t.Fatalf("error setting keyring secret :: %v", err)
+	}
+	t.Setenv("GIGGLE_TEST_TOKEN", "env-token")
+	secretFile := filepath.Join(t.TempDir(), "token")
+	if err := os.WriteFile(secretFile, []byte("file-pass\n"), 0600); err != nil {
+		t.Fatalf("error writing secret file :: %v", err)
+	}
+
+	cf := &Config{Auth: map[string]*AuthMethod{
+		"keyring": {BasicAuth: &http.BasicAuth{Username: "user", Password: "keyring:overleaf"}},
+		"env":     {TokenAuth: &http.TokenAuth{Token: "env:GIGGLE_TEST_TOKEN"}},
+		"file":    {BasicAuth: &http.BasicAuth{Username: "user", Password: "file:" + secretFile}},
+		"plain":   {BasicAuth: &http.BasicAuth{Username: "user", Password: "plain:env:x"}},
+		"missing": {TokenAuth: &http.TokenAuth{Token: "env:GIGGLE_TEST_MISSING"}},
+		"locked":  {SSH: &SSHAuth{Agent: true, Passphrase: "store:ssh"}},
+	}}
+	ve := cf.resolveSecrets()
+
+	if cf.Auth["keyring"].BasicAuth.Password != "keyring-pass" ||
+		cf.Auth["env"].TokenAuth.Token != "env-token" ||
+		cf.Auth["file"].BasicAuth.Password != "file-pass" ||
+		cf.Auth["plain"].BasicAuth.Password != "env:x" {
+		t.Fatalf("unexpected resolved secrets: %v", cf.Auth)
+	}
+	if len(ve) != 2 || ve[0].Path != "auth.locked.ssh.passphrase" || ve[1].Path != "auth.missing.token" {
+		t.Fatalf("unexpected problems: %v", ve)
+	}
+}
+
+func TestCredentialStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "credentials.enc")
+	cs, err := OpenCredentialStore(path, "passphrase")
+	if err != nil {
+		t.Fatalf("error creating store :: %v", err)
+	}
+	cs.Set("overleaf", "secret")
+	if err := cs.Save(); err != nil {
+		t.Fatalf("error saving store :: %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("error reading store :: %v", err)
+	}
+	if strings.Contains(string(data), "secret") {
+		t.Fatalf("store is not encrypted: %s", data)
+	}
+
+	if _, err := OpenCredentialStore(path, "wrong"); !errors.Is(err, ErrWrongPassphrase) {
+		t.Fatalf("expected wrong passphrase error, got %v", err)
+	}
+
+	cs, err = OpenCredentialStore(path, "passphrase")
+	if err != nil {
+		t.Fatalf("error opening store :: %v", err)
+	}
+	if secret, ok := cs.Get("overleaf"); !ok || secret != "secret" {
+		t.Fatalf("unexpected secret %v", secret)
+	}
+
+	unlockedStore.set(cs)
+	defer unlockedStore.set(nil)
+	if secret, err := resolveSecret("store:overleaf"); err != nil || secret != "secret" {
+		t.Fatalf("unexpected store secret %v :: %v", secret, err)
+	}
+}
+
+func TestAddRemoveSync(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	data := `{"auth": {"github": {"token": "env:GH_TOKEN"}}, "period": "10m", "sync": []}`
+	if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+		t.Fatalf("error writing config :: %v", err)
+	}
+
+	sc := SyncConfig{
+		Name:   "paper",
+		From:   Repo{Name: "overleaf", URLToRepo: "https://git.overleaf.com/abc"},
+		ToList: []Repo{{Name: "github", URLToRepo: "https://github.com/u/paper", AuthToUse: "github"}},
+	}
+	if err := AddSync(configFile, sc); err != nil {
+		t.Fatalf("error adding sync :: %v", err)
+	}
+	if err := AddSync(configFile, sc); err == nil {
+		t.Fatalf("expected error adding a duplicate sync")
+	}
+	sc.ToList[0].AuthToUse = "missing"
+	sc.Name = "other"
+	if err := AddSync(configFile, sc); err == nil {
+		t.Fatalf("expected error adding a sync with undefined auth")
+	}
+
+	data2, err := os.ReadFile(configFile)
+	if err != nil {
+		t.Fatalf("error reading config :: %v", err)
+	}
+	if !strings.Contains(string(data2), `"env:GH_TOKEN"`) || strings.Contains(string(data2), `"cron"`) {
+		t.Fatalf("unexpected config after adding sync: %s", data2)
+	}
+	var cf Config
+	if err := json.Unmarshal(data2, &cf); err != nil {
+		t.Fatalf("error unmarshalling config :: %v", err)
+	}
+	if len(cf.Sync) != 1 || cf.Sync[0].Name != "paper" || cf.Period.Duration != 10*time.Minute {
+		t.Fatalf("unexpected config after adding sync: %+v", cf)
+	}
+
+	if err := RemoveSync(configFile, "other"); err == nil {
+		t.Fatalf("expected error removing a sync that doesn't exist")
+	}
+	if err := RemoveSync(configFile, "paper"); err != nil {
+		t.Fatalf("error removing sync :: %v", err)
+	}
+	cf = Config{}
+	data2, _ = os.ReadFile(configFile)
+	if err := json.Unmarshal(data2, &cf); err != nil || len(cf.Sync) != 0 {
+		t.Fatalf("unexpected config after removing sync: %s :: %v", data2, err)
+	}
+
+	// the rest of the config is kept as it is formatted
+	sc.ToList[0].AuthToUse = "github"
+	for _, data := range []string{
+		"{\n    \"period\": \"10m\",\n    \"sync\": [],\n    \"auth\": {\"github\": {\"token\": \"t\"}}\n}\n",
+		"{\n  \"period\": \"10m\",\n  \"auth\": {\"github\": {\"token\": \"t\"}}\n}\n",
+	} {
+		if err := os.WriteFile(configFile, []byte(data), 0600); err != nil {
+			t.Fatalf("error writing config :: %v", err)
+		}
+		if err := AddSync(configFile, sc); err != nil {
+			t.Fatalf("error adding sync :: %v", err)
+		}
+		added, _ := os.ReadFile(configFile)
+		if !strings.HasPrefix(string(added), data[:strings.Index(data, "\"period\"")+16]) ||
+			!strings.HasSuffix(string(added), "}\n") || !strings.Contains(string(added), "\"name\": \"other\"") {
+			t.Fatalf("config reformatted when adding sync: %s", added)
+		}
+		if err := json.Unmarshal(added, &cf); err != nil || len(cf.Sync) != 1 {
+			t.Fatalf("unexpected config after adding sync: %s :: %v", added, err)
+		}
+		if err := RemoveSync(configFile, "other"); err != nil {
+			t.Fatalf("error removing sync :: %v", err)
+		}
+		if removed, _ := os.ReadFile(configFile); strings.Contains(data, "sync") && string(removed) != data {
+			t.Fatalf("config reformatted when removing sync: %s", removed)
+		}
+	}
+}
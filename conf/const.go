@@ -3,8 +3,7 @@ package conf
 import (
 	"os"
 	"path/filepath"
-
-	"github.com/kirsle/configdir"
+	"time"
 )
 
 const (
@@ -14,13 +13,36 @@ const (
 	cReposFolder      = "repos"
 	cConfigFile       = "config.json"
 	cPidFile          = "giggle.pid"
+	cSocketFile       = "giggle.sock"
+	cHandoverFile     = "handover.sock"
+	cStatusFile       = "status.json"
+	cHistoryFile      = "history.jsonl"
 	cLogFile          = "giggle.log"
+	cCredentialsFile  = "credentials.enc"
+	cPassphraseEnv    = "GIGGLE_PASSPHRASE"
+	cHomeEnv          = "GIGGLE_HOME"
 	cSecureFilePerm   = 0600
 	cDirPerm          = 0700
 	cLogFileMaxSize   = 50 // MB
 	cLogMaxNumBackups = 5
 	cLogFileMaxAge    = 30 // days
 
+	cDefaultMaxSyncs   = 4
+	cDefaultMaxPerHost = 2
+
+	cDefaultRetryAttempts      = 3
+	cDefaultRetryBackoff       = 2 * time.Second
+	cDefaultRetryMaxBackoff    = time.Minute
+	cDefaultBreakerFailures    = 5
+	cDefaultBreakerCooldown    = 5 * time.Minute
+	cDefaultBreakerMaxCooldown = 6 * time.Hour
+	cDefaultNotifyInterval     = time.Minute
+	cDefaultNotifyDedup        = time.Hour
+	cDefaultSMTPPort           = 587
+	cDefaultSMTPSPort          = 465
+	cDefaultMetricsPath        = "/metrics"
+	cDefaultSourceBranch       = "main"
+
 	cIconFile         = "images/giggle.png"
 	cSettingsIconFile = "images/settings.png"
 	clogIconFile      = "images/log.png"
@@ -37,15 +59,14 @@ func DirPerm() os.FileMode {
 	return cDirPerm
 }
 
-// baseFolder returns the directory where all the data for the app is stored.
-func baseFolder() string {
-	rootFolder := configdir.LocalConfig()
-	return filepath.Join(rootFolder, cAppFolder)
+// SecureFilePerm returns the permissions that should be used for files with sensitive data.
+func SecureFilePerm() os.FileMode {
+	return cSecureFilePerm
 }
 
 // LogFolder returns the directory where logs are stored.
 func LogFolder() string {
-	return filepath.Join(baseFolder(), cLogFolder)
+	return configPath(startup.Paths.Logs, filepath.Join(DataFolder(), cLogFolder))
 }
 
 // LogFilePath returns the path to log file.
@@ -55,37 +76,84 @@ func LogFilePath() string {
 
 // SettingsFilePath returns the path to settings file.
 func SettingsFilePath() string {
-	return filepath.Join(baseFolder(), cConfigFile)
+	if startup.configFile != "" {
+		return startup.configFile
+	}
+
+	return filepath.Join(homeFolder(), cConfigFile)
 }
 
 // PidFilePath returns the path to pid file.
 func PidFilePath() string {
-	return filepath.Join(baseFolder(), cPidFile)
+	return filepath.Join(DataFolder(), cPidFile)
+}
+
+// SocketFilePath returns the path to the Unix socket of the control API.
+func SocketFilePath() string {
+	return filepath.Join(DataFolder(), cSocketFile)
+}
+
+// HandoverSocketPath returns the path to the Unix socket that the
+// passphrase of the credential store is handed over to the daemon through.
+func HandoverSocketPath() string {
+	return filepath.Join(DataFolder(), cHandoverFile)
+}
+
+// StatusFilePath returns the path to the file that persists the status of the syncs.
+func StatusFilePath() string {
+	return filepath.Join(DataFolder(), cStatusFile)
 }
 
-// reposFolder returns the path to directory where all the repos are stored.
-func reposFolder() string {
-	return filepath.Join(baseFolder(), cReposFolder)
+// HistoryFilePath returns the path to the append-only history of the sync runs.
+func HistoryFilePath() string {
+	return filepath.Join(DataFolder(), cHistoryFile)
+}
+
+// CredentialStoreFilePath returns the path to the encrypted credential store.
+func CredentialStoreFilePath() string {
+	return filepath.Join(DataFolder(), cCredentialsFile)
+}
+
+// PassphraseEnv returns the environment variable that carries the passphrase of the credential store.
+func PassphraseEnv() string {
+	return cPassphraseEnv
+}
+
+// ReposFolder returns the path to directory where all the repos are stored.
+func ReposFolder() string {
+	return configPath(startup.Paths.Repos, filepath.Join(DataFolder(), cReposFolder))
 }
 
 // GetSyncTarget returns the path on disk where a given sync is cloned/stored.
 func GetSyncTarget(syncName string) string {
-	return filepath.Join(reposFolder(), syncName)
+	return filepath.Join(ReposFolder(), syncName)
 }
 
 // LogFileMaxSize returns the max allowed size of a log file in MB.
 func LogFileMaxSize() int {
-	return cLogFileMaxSize
+	if startup.Logging.MaxSize <= 0 {
+		return cLogFileMaxSize
+	}
+
+	return startup.Logging.MaxSize
 }
 
 // LogMaxNumBackups returns the number of maximum log files that needs to be kept.
 func LogMaxNumBackups() int {
-	return cLogMaxNumBackups
+	if startup.Logging.MaxBackups <= 0 {
+		return cLogMaxNumBackups
+	}
+
+	return startup.Logging.MaxBackups
 }
 
-// LogFileMaxAge returns the oldest log file that needs to be kept.
+// LogFileMaxAge returns the oldest log file, in days, that needs to be kept.
 func LogFileMaxAge() int {
-	return cLogFileMaxAge
+	if startup.Logging.MaxAge <= 0 {
+		return cLogFileMaxAge
+	}
+
+	return startup.Logging.MaxAge
 }
 
 // IconFile returns the path of the app icon file in bindata.
@@ -1,6 +1,7 @@
 package conf
 
 import (
+	"encoding/json"
 	"fmt"
 	"time"
 )
@@ -10,8 +11,12 @@ type duration struct {
 }
 
 func (d *duration) UnmarshalJSON(b []byte) error {
+	var sd string
+	if err := json.Unmarshal(b, &sd); err != nil {
+		return fmt.Errorf(`invalid duration %s, expected a string like "1m"`, b)
+	}
+
 	var err error
-	sd := string(b[1 : len(b)-1])
 	d.Duration, err = time.ParseDuration(sd)
 	return err
 }
@@ -0,0 +1,187 @@
+package conf
+
+import (
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+const (
+	cSyncKey = "sync"
+	cIndent  = "  "
+)
+
+// AddSync adds the sync to the config file. Everything else in the
+// file, including the secret references, is kept as it is.
+func AddSync(configFile string, sc SyncConfig) error {
+	data, err := json.Marshal(sc)
+	if err != nil {
+		return fmt.Errorf("error marshalling sync :: %w", err)
+	}
+
+	return editSyncs(configFile, func(syncs []json.RawMessage) ([]json.RawMessage, error) {
+		return append(syncs, data), nil
+	})
+}
+
+// RemoveSync removes the sync with the name from the config file.
+func RemoveSync(configFile, name string) error {
+	return editSyncs(configFile, func(syncs []json.RawMessage) ([]json.RawMessage, error) {
+		kept := syncs[:0]
+		for _, raw := range syncs {
+			var sc struct {
+				Name string `json:"name"`
+			}
+			if err := json.Unmarshal(raw, &sc); err != nil {
+				return nil, fmt.Errorf("error unmarshalling sync :: %w", err)
+			}
+			if sc.Name != name {
+				kept = append(kept, raw)
+			}
+		}
+
+		if len(kept) == len(syncs) {
+			return nil, fmt.Errorf("sync [%v] not found", name)
+		}
+		return kept, nil
+	})
+}
+
+// editSyncs applies the edit to the list of syncs in the config file. The edited config
+// is validated before it atomically replaces the file, so that a running giggle never
+// reads a partially written or invalid config. The secrets aren't resolved while
+// editing, that is why the raw JSON is edited instead of a Config read from the file.
+// Only the list of syncs is rewritten, the rest of the file is kept byte for byte.
+func editSyncs(configFile string, edit func([]json.RawMessage) ([]json.RawMessage, error)) error {
+	data, err := os.ReadFile(configFile)
+	if err != nil {
+		return fmt.Errorf("error reading conf file :: %w", err)
+	}
+
+	start, end, found, err := findSyncs(data)
+	if err != nil {
+		return fmt.Errorf("error unmarshalling conf file :: %w", err)
+	}
+
+	var syncs []json.RawMessage
+	if found {
+		if err := json.Unmarshal(data[start:end], &syncs); err != nil {
+			return fmt.Errorf("error unmarshalling syncs :: %w", err)
+		}
+	}
+	kept := make(map[string]bool, len(syncs))
+	for _, raw := range syncs {
+		kept[string(raw)] = true
+	}
+
+	if syncs, err = edit(syncs); err != nil {
+		return err
+	}
+	// the sync key is at the top level, hence its indent is also the unit of indentation
+	indent := lineIndent(data, start)
+	value, err := formatSyncs(syncs, kept, indent, cmp.Or(indent, cIndent))
+	if err != nil {
+		return err
+	}
+	if !found {
+		// the key is added as the last one of the config
+		value = append([]byte(fmt.Sprintf("%q: ", cSyncKey)), value...)
+		if len(bytes.TrimSpace(data[bytes.IndexByte(data, '{')+1:start])) > 0 {
+			value = append([]byte(",\n"+indent), value...)
+		}
+	}
+	data = append(append(append([]byte{}, data[:start]...), value...), data[end:]...)
+
+	var config Config
+	if err := json.Unmarshal(data, &config); err != nil {
+		return fmt.Errorf("error unmarshalling edited conf :: %w", err)
+	}
+	if err := config.Validate(); err != nil {
+		return fmt.Errorf("edited config is invalid :: %w", err)
+	}
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(configFile), filepath.Base(configFile)+".*")
+	if err != nil {
+		return fmt.Errorf("error creating temp conf file :: %w", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(data); err != nil {
+		tmpFile.Close()
+		return fmt.Errorf("error writing temp conf file :: %w", err)
+	}
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("error closing temp conf file :: %w", err)
+	}
+	if err := os.Rename(tmpFile.Name(), configFile); err != nil {
+		return fmt.Errorf("error replacing conf file :: %w", err)
+	}
+
+	return nil
+}
+
+// findSyncs returns the offsets of the value of the sync key in the config document. If
+// there is no sync key, both the offsets are at the end of the last value of the document.
+func findSyncs(data []byte) (int, int, bool, error) {
+	dec := json.NewDecoder(bytes.NewReader(data))
+	if tok, err := dec.Token(); err != nil {
+		return 0, 0, false, err
+	} else if tok != json.Delim('{') {
+		return 0, 0, false, errors.New("config is not a JSON object")
+	}
+
+	last := int(dec.InputOffset())
+	for dec.More() {
+		key, err := dec.Token()
+		if err != nil {
+			return 0, 0, false, err
+		}
+		var value json.RawMessage
+		if err := dec.Decode(&value); err != nil {
+			return 0, 0, false, err
+		}
+
+		last = int(dec.InputOffset())
+		if key == cSyncKey {
+			return last - len(value), last, true, nil
+		}
+	}
+
+	return last, last, false, nil
+}
+
+// lineIndent returns the indentation of the line that the offset is on.
+func lineIndent(data []byte, offset int) string {
+	line := data[bytes.LastIndexByte(data[:offset], '\n')+1 : offset]
+	return string(line[:len(line)-len(bytes.TrimLeft(line, " \t"))])
+}
+
+// formatSyncs formats the list of syncs for the line with the indent. The syncs that are
+// kept from the file keep their formatting, and the added ones are indented to match.
+func formatSyncs(syncs []json.RawMessage, kept map[string]bool, indent, unit string) ([]byte, error) {
+	if len(syncs) == 0 {
+		return []byte("[]"), nil
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[\n")
+	for i, raw := range syncs {
+		buf.WriteString(indent + unit)
+		if kept[string(raw)] {
+			buf.Write(raw)
+		} else if err := json.Indent(&buf, raw, indent+unit, unit); err != nil {
+			return nil, fmt.Errorf("error marshalling sync :: %w", err)
+		}
+		if i < len(syncs)-1 {
+			buf.WriteByte(',')
+		}
+		buf.WriteByte('\n')
+	}
+	buf.WriteString(indent + "]")
+
+	return buf.Bytes(), nil
+}
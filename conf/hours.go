@@ -0,0 +1,95 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	cHoursLayout = "15:04"
+	cHoursSep    = "-"
+	cDay         = 24 * time.Hour
+)
+
+// hoursWindow is a daily window of local time, e.g. "08:00-20:00".
+// The window wraps around midnight if the end is before the start.
+// A zero value window contains every time of the day.
+type hoursWindow struct {
+	start time.Duration
+	end   time.Duration
+	set   bool
+}
+
+func parseHoursWindow(s string) (hoursWindow, error) {
+	parts := strings.Split(s, cHoursSep)
+	if len(parts) != 2 {
+		return hoursWindow{}, fmt.Errorf("invalid hours window [%v], expected HH:MM-HH:MM", s)
+	}
+
+	var offsets [2]time.Duration
+	for i, part := range parts {
+		t, err := time.Parse(cHoursLayout, strings.TrimSpace(part))
+		if err != nil {
+			return hoursWindow{}, fmt.Errorf("invalid time [%v] in hours window :: %w", part, err)
+		}
+		offsets[i] = time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+	}
+
+	if offsets[0] == offsets[1] {
+		return hoursWindow{}, fmt.Errorf("empty hours window [%v]", s)
+	}
+
+	return hoursWindow{start: offsets[0], end: offsets[1], set: true}, nil
+}
+
+// Contains returns whether the given time falls in the window.
+func (w hoursWindow) Contains(t time.Time) bool {
+	if !w.set {
+		return true
+	}
+
+	offset := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute +
+		time.Duration(t.Second())*time.Second
+	if w.start < w.end {
+		return offset >= w.start && offset < w.end
+	}
+
+	return offset >= w.start || offset < w.end
+}
+
+func (w hoursWindow) String() string {
+	if !w.set {
+		return ""
+	}
+
+	format := func(d time.Duration) string {
+		return time.Time{}.Add(d % cDay).Format(cHoursLayout)
+	}
+	return format(w.start) + cHoursSep + format(w.end)
+}
+
+func (w *hoursWindow) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return fmt.Errorf("hours window must be a string :: %w", err)
+	}
+
+	if s == "" {
+		*w = hoursWindow{}
+		return nil
+	}
+
+	parsed, err := parseHoursWindow(s)
+	if err != nil {
+		return err
+	}
+
+	*w = parsed
+	return nil
+}
+
+func (w hoursWindow) MarshalJSON() ([]byte, error) {
+	return json.Marshal(w.String())
+}
@@ -0,0 +1,118 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+)
+
+// Kinds of the hosts of repos, see Repo.Kind.
+const (
+	KindOverleaf  = "overleaf"
+	KindGitHub    = "github"
+	KindGitLab    = "gitlab"
+	KindGitea     = "gitea"
+	KindBitbucket = "bitbucket"
+	KindGeneric   = "generic"
+	KindLocal     = "local"
+)
+
+// Visibilities of the repos created by giggle, see Repo.Create.
+const (
+	VisibilityPrivate  = "private"
+	VisibilityPublic   = "public"
+	VisibilityInternal = "internal"
+)
+
+const (
+	cOverleafGitHost = "git.overleaf.com"
+	cOverleafWebHost = "www.overleaf.com"
+)
+
+// cOverleafIDRegex matches the id of an overleaf project.
+var cOverleafIDRegex = regexp.MustCompile(`^[A-Za-z0-9]+$`)
+
+// Kinds returns all the kinds of hosts of repos.
+func Kinds() []string {
+	return []string{KindOverleaf, KindGitHub, KindGitLab, KindGitea, KindBitbucket, KindGeneric, KindLocal}
+}
+
+// apiKinds are the kinds of hosts whose API is used by giggle, to create
+// repos and to open pull requests.
+var apiKinds = []string{KindGitHub, KindGitLab, KindGitea}
+
+// kindHosts are the kinds of the well known hosts, used when a repo has no kind.
+var kindHosts = map[string]string{
+	cOverleafGitHost: KindOverleaf,
+	cOverleafWebHost: KindOverleaf,
+	"github.com":     KindGitHub,
+	"gitlab.com":     KindGitLab,
+	"codeberg.org":   KindGitea,
+	"bitbucket.org":  KindBitbucket,
+}
+
+// HostKind returns the kind of the host of the repo. It is guessed from the
+// URL when the repo has no kind, which only works for the well known hosts
+// and the local repos, self hosted repos are generic unless the kind is set.
+func (r Repo) HostKind() string {
+	if r.Kind != "" {
+		return r.Kind
+	}
+
+	ep, err := transport.NewEndpoint(r.URLToRepo)
+	if err != nil {
+		return KindGeneric
+	}
+	if ep.Protocol == "file" {
+		return KindLocal
+	}
+	if kind, ok := kindHosts[strings.ToLower(ep.Host)]; ok {
+		return kind
+	}
+
+	return KindGeneric
+}
+
+// OverleafProjectID returns the id of the overleaf project given either its git URL,
+// i.e. https://git.overleaf.com/<id>, or the URL of its web page that is often copied
+// instead, i.e. https://www.overleaf.com/project/<id>.
+func OverleafProjectID(rawURL string) (string, bool) {
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Scheme != "https" {
+		return "", false
+	}
+
+	var id string
+	switch path := strings.Trim(strings.TrimSuffix(u.Path, ".git"), "/"); strings.ToLower(u.Host) {
+	case cOverleafGitHost:
+		id = path
+	case cOverleafWebHost:
+		if project, ok := strings.CutPrefix(path, "project/"); ok {
+			id = project
+		}
+	}
+
+	return id, cOverleafIDRegex.MatchString(id)
+}
+
+// OwnerAndName returns the owner of the repo, i.e. the user, the organization or the
+// group, and the name of the repo, given its URL, e.g. user and paper for both
+// https://github.com/user/paper.git and git@github.com:user/paper.git. The owner
+// has more than one segment for the nested groups of GitLab.
+func (r Repo) OwnerAndName() (string, string, error) {
+	ep, err := transport.NewEndpoint(r.URLToRepo)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid url [%v] :: %w", r.URLToRepo, err)
+	}
+
+	repoPath := strings.Trim(strings.TrimSuffix(strings.Trim(ep.Path, "/"), ".git"), "/")
+	slash := strings.LastIndex(repoPath, "/")
+	if slash <= 0 || slash == len(repoPath)-1 {
+		return "", "", fmt.Errorf("url [%v] has no owner and name of the repo", r.URLToRepo)
+	}
+
+	return repoPath[:slash], repoPath[slash+1:], nil
+}
@@ -0,0 +1,98 @@
+package conf
+
+import (
+	"fmt"
+	"io"
+	"log/slog"
+	"path/filepath"
+)
+
+// Formats of the logs, see LoggingConfig.Format.
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// logLevel is the level of the handlers returned by NewLogHandler, so that it can be updated.
+var logLevel = new(slog.LevelVar)
+
+// LoggingConfig configures the logs. Level is one of debug, info, warn and error,
+// info by default. Format is text or json, text by default. The log file is rotated when
+// it is MaxSize MB, keeping MaxBackups old files for MaxAge days. All but the level are
+// set at startup.
+type LoggingConfig struct {
+	Level      string `json:"level,omitempty"`
+	Format     string `json:"format,omitempty"`
+	MaxSize    int    `json:"max_size_mb,omitempty"`
+	MaxBackups int    `json:"max_backups,omitempty"`
+	MaxAge     int    `json:"max_age_days,omitempty"`
+}
+
+// LogLevel returns the level of the logs.
+func (lc LoggingConfig) LogLevel() (slog.Level, error) {
+	var level slog.Level
+	if lc.Level == "" {
+		return slog.LevelInfo, nil
+	}
+	if err := level.UnmarshalText([]byte(lc.Level)); err != nil {
+		return slog.LevelInfo, fmt.Errorf("invalid log level [%v]", lc.Level)
+	}
+
+	return level, nil
+}
+
+func (lc LoggingConfig) validate(ve *ValidationErrors) {
+	if _, err := lc.LogLevel(); err != nil {
+		ve.add("logging.level", "[%v] must be one of debug, info, warn or error", lc.Level)
+	}
+
+	switch lc.Format {
+	case "", LogFormatText, LogFormatJSON:
+	default:
+		ve.add("logging.format", "[%v] must be one of %q or %q", lc.Format, LogFormatText, LogFormatJSON)
+	}
+
+	if lc.MaxSize < 0 {
+		ve.add("logging.max_size_mb", "must not be negative")
+	}
+	if lc.MaxBackups < 0 {
+		ve.add("logging.max_backups", "must not be negative")
+	}
+	if lc.MaxAge < 0 {
+		ve.add("logging.max_age_days", "must not be negative")
+	}
+}
+
+// NewLogHandler returns the handler that writes the logs to w as per the config.
+// The source of each log is shortened to the file name and the line.
+func NewLogHandler(w io.Writer, lc LoggingConfig) slog.Handler {
+	SetLogLevel(lc)
+
+	opts := &slog.HandlerOptions{
+		AddSource: true,
+		Level:     logLevel,
+		ReplaceAttr: func(_ []string, a slog.Attr) slog.Attr {
+			if src, ok := a.Value.Any().(*slog.Source); ok && a.Key == slog.SourceKey {
+				a.Value = slog.StringValue(fmt.Sprintf("%v:%d", filepath.Base(src.File), src.Line))
+			}
+			return a
+		},
+	}
+	if lc.Format == LogFormatJSON {
+		return slog.NewJSONHandler(w, opts)
+	}
+
+	return slog.NewTextHandler(w, opts)
+}
+
+// SetLogLevel updates the level of the handlers returned by NewLogHandler.
+func SetLogLevel(lc LoggingConfig) {
+	level, _ := lc.LogLevel()
+	logLevel.Set(level)
+}
+
+// StartupLogging returns the logging section of the config file as read by Setup,
+// so that the logs can be set up before the config is read, resolved and validated.
+func StartupLogging() LoggingConfig {
+	return startup.Logging
+}
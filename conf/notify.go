@@ -0,0 +1,192 @@
+package conf
+
+import (
+	"fmt"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// Events that can be notified, see NotifyConfig.
+const (
+	// EventFailed is sent when a sync fails after having succeeded.
+	EventFailed = "failed"
+	// EventRecovered is sent when a sync succeeds after having failed.
+	EventRecovered = "recovered"
+	// EventChanges is sent when new commits of the `from` repo are pushed to the targets.
+	EventChanges = "changes"
+)
+
+// Formats of the body posted to a webhook, see WebhookConfig.Format.
+const (
+	// WebhookJSON posts the events along with the message as JSON.
+	WebhookJSON = "json"
+	// WebhookSlack posts the message to a Slack incoming webhook.
+	WebhookSlack = "slack"
+	// WebhookMatrix posts the message to a Matrix webhook bridge, e.g. hookshot.
+	WebhookMatrix = "matrix"
+)
+
+// NotifyConfig configures the notifications of the sync events. At most one notification
+// is sent every MinInterval, the events that occur in the meantime are sent together.
+// An event that is the same as one sent within DedupWindow is dropped.
+type NotifyConfig struct {
+	Desktop     *DesktopNotifyConfig `json:"desktop,omitempty"`
+	Webhooks    []WebhookConfig      `json:"webhooks,omitempty"`
+	Email       *EmailNotifyConfig   `json:"email,omitempty"`
+	MinInterval duration             `json:"min_interval,omitzero"`
+	DedupWindow duration             `json:"dedup_window,omitzero"`
+}
+
+// NotifierConfig is common to all the notifiers. Events selects the events to notify, all
+// if empty. Template is a text/template executed with each event to render its message.
+type NotifierConfig struct {
+	Events   []string `json:"events,omitempty"`
+	Template string   `json:"template,omitempty"`
+}
+
+// DesktopNotifyConfig enables desktop notifications.
+type DesktopNotifyConfig struct {
+	NotifierConfig
+}
+
+// WebhookConfig posts the events to the URL, in the Format, json by default.
+// The URL and the values of the headers may refer to secrets.
+type WebhookConfig struct {
+	NotifierConfig
+	URL     string            `json:"url"`
+	Format  string            `json:"format,omitempty"`
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// EmailNotifyConfig sends the events by email through the SMTP server at Host:Port. The
+// connection is upgraded with STARTTLS if the server supports it, or uses TLS from the
+// start if TLS is set. The password may refer to a secret.
+type EmailNotifyConfig struct {
+	NotifierConfig
+	Host     string   `json:"host"`
+	Port     int      `json:"port,omitempty"`
+	TLS      bool     `json:"tls,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+}
+
+// Interval returns the minimum interval between two notifications.
+func (nc NotifyConfig) Interval() time.Duration {
+	if nc.MinInterval.Duration <= 0 {
+		return cDefaultNotifyInterval
+	}
+
+	return nc.MinInterval.Duration
+}
+
+// Dedup returns the period within which the same event is notified only once.
+func (nc NotifyConfig) Dedup() time.Duration {
+	if nc.DedupWindow.Duration <= 0 {
+		return cDefaultNotifyDedup
+	}
+
+	return nc.DedupWindow.Duration
+}
+
+// Wants returns whether the event is to be notified, i.e. Events is empty or lists it.
+func (nc NotifierConfig) Wants(event string) bool {
+	if len(nc.Events) == 0 {
+		return true
+	}
+
+	for _, e := range nc.Events {
+		if e == event {
+			return true
+		}
+	}
+
+	return false
+}
+
+// WebhookFormat returns the format of the body posted to the webhook.
+func (wc WebhookConfig) WebhookFormat() string {
+	if wc.Format == "" {
+		return WebhookJSON
+	}
+
+	return wc.Format
+}
+
+// Addr returns the address of the SMTP server.
+func (ec EmailNotifyConfig) Addr() string {
+	port := ec.Port
+	switch {
+	case port != 0:
+	case ec.TLS:
+		port = cDefaultSMTPSPort
+	default:
+		port = cDefaultSMTPPort
+	}
+
+	return fmt.Sprintf("%v:%d", ec.Host, port)
+}
+
+func (nc NotifyConfig) validate(ve *ValidationErrors) {
+	if nc.MinInterval.Duration < 0 {
+		ve.add("notify.min_interval", "must not be negative")
+	}
+	if nc.DedupWindow.Duration < 0 {
+		ve.add("notify.dedup_window", "must not be negative")
+	}
+
+	if nc.Desktop != nil {
+		nc.Desktop.validate(ve, "notify.desktop")
+	}
+
+	for i, wc := range nc.Webhooks {
+		path := fmt.Sprintf("notify.webhooks[%d]", i)
+		wc.NotifierConfig.validate(ve, path)
+		// the config is also validated with unresolved secrets when it is edited
+		if u, err := url.Parse(wc.URL); !isSecretRef(wc.URL) &&
+			(err != nil || (u.Scheme != "http" && u.Scheme != "https")) {
+			ve.add(path+".url", "must be an http or https url")
+		}
+		switch wc.Format {
+		case "", WebhookJSON, WebhookSlack, WebhookMatrix:
+		default:
+			ve.add(path+".format", "[%v] must be one of %q, %q or %q",
+				wc.Format, WebhookJSON, WebhookSlack, WebhookMatrix)
+		}
+	}
+
+	if ec := nc.Email; ec != nil {
+		ec.validate(ve, "notify.email")
+		if ec.Host == "" {
+			ve.add("notify.email.host", "is required")
+		}
+		if ec.Port < 0 {
+			ve.add("notify.email.port", "must not be negative")
+		}
+		if ec.From == "" {
+			ve.add("notify.email.from", "is required")
+		}
+		if len(ec.To) == 0 {
+			ve.add("notify.email.to", "at least one recipient is required")
+		}
+	}
+}
+
+func (nc NotifierConfig) validate(ve *ValidationErrors, path string) {
+	for i, e := range nc.Events {
+		switch e {
+		case EventFailed, EventRecovered, EventChanges:
+		default:
+			ve.add(fmt.Sprintf("%v.events[%d]", path, i), "[%v] must be one of %q, %q or %q",
+				e, EventFailed, EventRecovered, EventChanges)
+		}
+	}
+
+	if nc.Template != "" {
+		if _, err := template.New("").Parse(nc.Template); err != nil {
+			ve.add(path+".template", "invalid template :: %v", err)
+		}
+	}
+}
@@ -0,0 +1,88 @@
+package conf
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/kirsle/configdir"
+)
+
+// startup is the part of the config that is read before anything else by Setup,
+// and that is not updated on reload, i.e. the locations of the files and the logs.
+var startup struct {
+	configFile string
+
+	Paths   PathsConfig   `json:"paths"`
+	Logging LoggingConfig `json:"logging"`
+}
+
+// PathsConfig sets where the data of the app is stored, so that multiple instances with
+// different configs can coexist on one machine. Data is the folder of the pid file, the
+// socket, the status, the history and the credential store, the home folder by default.
+// Repos and Logs are the folders of the clones and the logs, in the data folder by default.
+// Relative paths are relative to the folder of the config file.
+type PathsConfig struct {
+	Data  string `json:"data,omitempty"`
+	Repos string `json:"repos,omitempty"`
+	Logs  string `json:"logs,omitempty"`
+}
+
+// Setup reads the paths and the logging sections of the config file, so that the files of
+// the app are found before the config is read. configFile is the config file of the app,
+// config.json in the home folder if empty. The defaults are used for the sections if the
+// config file can't be read, the problems are then reported when the config is read.
+func Setup(configFile string) error {
+	if configFile != "" {
+		abs, err := filepath.Abs(configFile)
+		if err != nil {
+			return fmt.Errorf("error finding config file [%v] :: %w", configFile, err)
+		}
+		configFile = abs
+	}
+	startup.configFile = configFile
+	startup.Paths, startup.Logging = PathsConfig{}, LoggingConfig{}
+
+	data, err := os.ReadFile(SettingsFilePath())
+	if err != nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &startup); err != nil {
+		startup.Paths, startup.Logging = PathsConfig{}, LoggingConfig{}
+	}
+
+	return nil
+}
+
+// HomeEnv returns the environment variable that overrides the home folder of the app.
+func HomeEnv() string {
+	return cHomeEnv
+}
+
+// homeFolder returns the folder of the config file by default, and of the data of the app.
+func homeFolder() string {
+	if home := os.Getenv(cHomeEnv); home != "" {
+		return home
+	}
+
+	return filepath.Join(configdir.LocalConfig(), cAppFolder)
+}
+
+// configPath returns the path, relative to the folder of the config file if
+// it isn't absolute, or the fallback if the path is empty.
+func configPath(path, fallback string) string {
+	switch {
+	case path == "":
+		return fallback
+	case filepath.IsAbs(path):
+		return path
+	default:
+		return filepath.Join(filepath.Dir(SettingsFilePath()), path)
+	}
+}
+
+// DataFolder returns the directory where the data of the app is stored.
+func DataFolder() string {
+	return configPath(startup.Paths.Data, homeFolder())
+}
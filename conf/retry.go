@@ -0,0 +1,84 @@
+package conf
+
+import (
+	"time"
+)
+
+// RetryConfig configures retries of fetches and pushes that failed with a transient
+// error, e.g. a network error or an HTTP 5xx response. The delay between attempts
+// starts at Backoff and doubles after each attempt up to MaxBackoff. Jitter is the
+// fraction, between 0 and 1, by which each delay is randomly shortened or lengthened.
+type RetryConfig struct {
+	Attempts   int      `json:"attempts"`
+	Backoff    duration `json:"backoff"`
+	MaxBackoff duration `json:"max_backoff"`
+	Jitter     float64  `json:"jitter"`
+}
+
+// BreakerConfig configures the circuit breaker of each remote. After Failures
+// consecutive failures, a remote is not contacted for Cooldown. The cooldown
+// doubles every time the remote fails again right after it, up to MaxCooldown.
+type BreakerConfig struct {
+	Failures    int      `json:"failures"`
+	Cooldown    duration `json:"cooldown"`
+	MaxCooldown duration `json:"max_cooldown"`
+}
+
+// AttemptLimit returns the maximum number of attempts for an operation.
+func (rc RetryConfig) AttemptLimit() int {
+	if rc.Attempts <= 0 {
+		return cDefaultRetryAttempts
+	}
+
+	return rc.Attempts
+}
+
+// InitialBackoff returns the delay before the first retry.
+func (rc RetryConfig) InitialBackoff() time.Duration {
+	if rc.Backoff.Duration <= 0 {
+		return cDefaultRetryBackoff
+	}
+
+	return rc.Backoff.Duration
+}
+
+// BackoffLimit returns the maximum delay between two attempts.
+func (rc RetryConfig) BackoffLimit() time.Duration {
+	if rc.MaxBackoff.Duration <= 0 {
+		return cDefaultRetryMaxBackoff
+	}
+
+	return rc.MaxBackoff.Duration
+}
+
+// JitterFraction returns the jitter fraction limited to [0, 1].
+func (rc RetryConfig) JitterFraction() float64 {
+	return min(max(rc.Jitter, 0), 1)
+}
+
+// FailureLimit returns the number of consecutive failures that open the breaker.
+func (bc BreakerConfig) FailureLimit() int {
+	if bc.Failures <= 0 {
+		return cDefaultBreakerFailures
+	}
+
+	return bc.Failures
+}
+
+// InitialCooldown returns for how long a remote is not contacted after the breaker opens.
+func (bc BreakerConfig) InitialCooldown() time.Duration {
+	if bc.Cooldown.Duration <= 0 {
+		return cDefaultBreakerCooldown
+	}
+
+	return bc.Cooldown.Duration
+}
+
+// CooldownLimit returns the maximum cooldown of a breaker.
+func (bc BreakerConfig) CooldownLimit() time.Duration {
+	if bc.MaxCooldown.Duration <= 0 {
+		return cDefaultBreakerMaxCooldown
+	}
+
+	return bc.MaxCooldown.Duration
+}
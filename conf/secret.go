@@ -0,0 +1,146 @@
+package conf
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/zalando/go-keyring"
+)
+
+// Prefixes of the secret references that can be used in place of a plaintext secret.
+const (
+	// SecretKeyring reads the secret from the OS keyring, stored under the app name.
+	SecretKeyring = "keyring:"
+	// SecretEnv reads the secret from an environment variable.
+	SecretEnv = "env:"
+	// SecretFile reads the secret from a file, ignoring the trailing newline.
+	SecretFile = "file:"
+	// SecretStore reads the secret from the encrypted credential store.
+	SecretStore = "store:"
+	// SecretPlain marks the rest of the value as the secret itself, useful
+	// when a plaintext secret happens to start with one of the prefixes.
+	SecretPlain = "plain:"
+)
+
+// resolveSecret returns the secret that the value refers to,
+// or the value itself if it isn't a secret reference.
+func resolveSecret(value string) (string, error) {
+	prefix, ref, ok := strings.Cut(value, ":")
+	if !ok {
+		return value, nil
+	}
+
+	switch prefix + ":" {
+	case SecretKeyring:
+		secret, err := keyring.Get(cAppName, ref)
+		if err != nil {
+			return "", fmt.Errorf("error reading [%v] from keyring :: %w", ref, err)
+		}
+		return secret, nil
+	case SecretEnv:
+		secret, ok := os.LookupEnv(ref)
+		if !ok {
+			return "", fmt.Errorf("environment variable [%v] is not set", ref)
+		}
+		return secret, nil
+	case SecretFile:
+		data, err := os.ReadFile(ExpandHome(ref))
+		if err != nil {
+			return "", fmt.Errorf("error reading secret file :: %w", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	case SecretStore:
+		return unlockedStore.lookup(ref)
+	case SecretPlain:
+		return ref, nil
+	default:
+		return value, nil
+	}
+}
+
+// isSecretRef returns whether the value refers to a secret instead of being the value itself.
+func isSecretRef(value string) bool {
+	for _, prefix := range []string{SecretKeyring, SecretEnv, SecretFile, SecretStore, SecretPlain} {
+		if strings.HasPrefix(value, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// resolveSecrets replaces the secret references in the auth methods and
+// the notifiers with the secrets, and returns the references that couldn't be resolved.
+func (c *Config) resolveSecrets() ValidationErrors {
+	names := make([]string, 0, len(c.Auth))
+	for name := range c.Auth {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var ve ValidationErrors
+	resolve := func(path string, value *string) {
+		secret, err := resolveSecret(*value)
+		if err != nil {
+			ve.add(path, "%v", err)
+			return
+		}
+		*value = secret
+	}
+
+	for _, name := range names {
+		am := c.Auth[name]
+		if am == nil {
+			continue
+		}
+
+		path := fmt.Sprintf("auth.%v", name)
+		if am.BasicAuth != nil {
+			resolve(path+".username", &am.BasicAuth.Username)
+			resolve(path+".password", &am.BasicAuth.Password)
+		}
+		if am.TokenAuth != nil {
+			resolve(path+".token", &am.TokenAuth.Token)
+		}
+		if am.SSH != nil {
+			resolve(path+".ssh.passphrase", &am.SSH.Passphrase)
+		}
+	}
+
+	for i := range c.Notify.Webhooks {
+		wc := &c.Notify.Webhooks[i]
+		path := fmt.Sprintf("notify.webhooks[%d]", i)
+		resolve(path+".url", &wc.URL)
+		for _, key := range sortedHeaders(wc.Headers) {
+			value := wc.Headers[key]
+			resolve(fmt.Sprintf("%v.headers.%v", path, key), &value)
+			wc.Headers[key] = value
+		}
+	}
+	if ec := c.Notify.Email; ec != nil {
+		resolve("notify.email.password", &ec.Password)
+	}
+
+	return ve
+}
+
+func sortedHeaders(headers map[string]string) []string {
+	keys := make([]string, 0, len(headers))
+	for k := range headers {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+// SetKeyringSecret stores the secret in the OS keyring, to be referred as keyring:<name>.
+func SetKeyringSecret(name, secret string) error {
+	if err := keyring.Set(cAppName, name, secret); err != nil {
+		return fmt.Errorf("error writing [%v] to keyring :: %w", name, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,227 @@
+package conf
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"sync"
+
+	"golang.org/x/crypto/scrypt"
+)
+
+// Parameters of the key derivation for the credential store.
+const (
+	cStoreVersion = 1
+	cStoreSaltLen = 16
+	cStoreKeyLen  = 32
+	cStoreScryptN = 1 << 15
+	cStoreScryptR = 8
+	cStoreScryptP = 1
+)
+
+// ErrWrongPassphrase is returned when the credential store can't be decrypted with the passphrase.
+var ErrWrongPassphrase = errors.New("wrong passphrase for the credential store")
+
+// unlockedStore is the credential store that store:<name> references are resolved from.
+var unlockedStore = &storeHolder{}
+
+// CredentialStore is a set of secrets kept in a file encrypted using
+// AES-GCM with a key derived from a passphrase using scrypt.
+type CredentialStore struct {
+	path    string
+	salt    []byte
+	key     []byte
+	secrets map[string]string
+}
+
+// storeFile is the format of the credential store on disk.
+type storeFile struct {
+	Version int    `json:"version"`
+	Salt    []byte `json:"salt"`
+	Nonce   []byte `json:"nonce"`
+	Data    []byte `json:"data"`
+}
+
+// OpenCredentialStore decrypts the credential store at the path
+// using the passphrase. An empty store is returned if the file doesn't exist.
+func OpenCredentialStore(path, passphrase string) (*CredentialStore, error) {
+	cs := &CredentialStore{path: path, secrets: make(map[string]string)}
+
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		cs.salt = make([]byte, cStoreSaltLen)
+		if _, err := rand.Read(cs.salt); err != nil {
+			return nil, fmt.Errorf("error generating salt :: %w", err)
+		}
+		if cs.key, err = deriveKey(passphrase, cs.salt); err != nil {
+			return nil, err
+		}
+		return cs, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error reading credential store :: %w", err)
+	}
+
+	var sf storeFile
+	if err := json.Unmarshal(data, &sf); err != nil {
+		return nil, fmt.Errorf("error unmarshalling credential store :: %w", err)
+	}
+	if sf.Version != cStoreVersion {
+		return nil, fmt.Errorf("unsupported credential store version [%v]", sf.Version)
+	}
+
+	cs.salt = sf.Salt
+	if cs.key, err = deriveKey(passphrase, cs.salt); err != nil {
+		return nil, err
+	}
+	gcm, err := newGCM(cs.key)
+	if err != nil {
+		return nil, err
+	}
+	plain, err := gcm.Open(nil, sf.Nonce, sf.Data, nil)
+	if err != nil {
+		return nil, ErrWrongPassphrase
+	}
+	if err := json.Unmarshal(plain, &cs.secrets); err != nil {
+		return nil, fmt.Errorf("error unmarshalling secrets :: %w", err)
+	}
+
+	return cs, nil
+}
+
+// Get returns the secret stored with the name.
+func (cs *CredentialStore) Get(name string) (string, bool) {
+	secret, ok := cs.secrets[name]
+	return secret, ok
+}
+
+// Set stores the secret with the name, replacing the existing secret if any.
+func (cs *CredentialStore) Set(name, secret string) {
+	cs.secrets[name] = secret
+}
+
+// Delete removes the secret stored with the name.
+func (cs *CredentialStore) Delete(name string) {
+	delete(cs.secrets, name)
+}
+
+// Names returns the sorted names of all the secrets in the store.
+func (cs *CredentialStore) Names() []string {
+	names := make([]string, 0, len(cs.secrets))
+	for name := range cs.secrets {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Save encrypts the secrets with a fresh nonce and writes them to disk.
+func (cs *CredentialStore) Save() error {
+	plain, err := json.Marshal(cs.secrets)
+	if err != nil {
+		return fmt.Errorf("error marshalling secrets :: %w", err)
+	}
+
+	gcm, err := newGCM(cs.key)
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("error generating nonce :: %w", err)
+	}
+
+	data, err := json.Marshal(storeFile{
+		Version: cStoreVersion,
+		Salt:    cs.salt,
+		Nonce:   nonce,
+		Data:    gcm.Seal(nil, nonce, plain, nil),
+	})
+	if err != nil {
+		return fmt.Errorf("error marshalling credential store :: %w", err)
+	}
+
+	// write to a temporary file first so that a crash doesn't lose the store
+	tmpPath := cs.path + ".tmp"
+	if err := os.WriteFile(tmpPath, data, cSecureFilePerm); err != nil {
+		return fmt.Errorf("error writing credential store :: %w", err)
+	}
+	if err := os.Rename(tmpPath, cs.path); err != nil {
+		return fmt.Errorf("error replacing credential store :: %w", err)
+	}
+
+	return nil
+}
+
+func deriveKey(passphrase string, salt []byte) ([]byte, error) {
+	key, err := scrypt.Key([]byte(passphrase), salt, cStoreScryptN, cStoreScryptR, cStoreScryptP, cStoreKeyLen)
+	if err != nil {
+		return nil, fmt.Errorf("error deriving key :: %w", err)
+	}
+
+	return key, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("error creating cipher :: %w", err)
+	}
+
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, fmt.Errorf("error creating gcm :: %w", err)
+	}
+
+	return gcm, nil
+}
+
+// HasCredentialStore returns whether the credential store exists on disk.
+func HasCredentialStore() bool {
+	_, err := os.Stat(CredentialStoreFilePath())
+	return err == nil
+}
+
+// UnlockCredentialStore decrypts the credential store of the app with the
+// passphrase, so that the store:<name> references in the config can be resolved.
+func UnlockCredentialStore(passphrase string) error {
+	cs, err := OpenCredentialStore(CredentialStoreFilePath(), passphrase)
+	if err != nil {
+		return err
+	}
+
+	unlockedStore.set(cs)
+	return nil
+}
+
+// storeHolder guards the unlocked credential store, that is read on every config reload.
+type storeHolder struct {
+	mu sync.Mutex
+	cs *CredentialStore
+}
+
+func (sh *storeHolder) set(cs *CredentialStore) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+	sh.cs = cs
+}
+
+func (sh *storeHolder) lookup(name string) (string, error) {
+	sh.mu.Lock()
+	defer sh.mu.Unlock()
+
+	if sh.cs == nil {
+		return "", fmt.Errorf("credential store is locked, set %v to unlock it", cPassphraseEnv)
+	}
+	secret, ok := sh.cs.Get(name)
+	if !ok {
+		return "", fmt.Errorf("[%v] is not in the credential store", name)
+	}
+
+	return secret, nil
+}
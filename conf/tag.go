@@ -0,0 +1,32 @@
+package conf
+
+import (
+	"path"
+)
+
+// TagConfig stores configuration for mirroring tags from the `from` repo to the `to` repos.
+type TagConfig struct {
+	// Patterns select the tags to mirror using path.Match, all the tags are mirrored if empty.
+	Patterns []string `json:"patterns"`
+	// Force allows updating a tag on the `to` repos after it was moved on the `from` repo.
+	Force bool `json:"force"`
+}
+
+// Match returns whether the tag needs to be mirrored.
+func (tc *TagConfig) Match(tag string) bool {
+	if tc == nil {
+		return false
+	}
+
+	if len(tc.Patterns) == 0 {
+		return true
+	}
+
+	for _, pattern := range tc.Patterns {
+		if ok, _ := path.Match(pattern, tag); ok {
+			return true
+		}
+	}
+
+	return false
+}
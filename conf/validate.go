@@ -0,0 +1,466 @@
+package conf
+
+import (
+	"encoding"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/robfig/cron/v3"
+)
+
+// cNameRegex matches names that are safe to use as a folder and as a git remote.
+var cNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ValidSyncName returns whether the name is valid for a sync, and hence safe to use as a folder.
+func ValidSyncName(name string) bool {
+	return cNameRegex.MatchString(name)
+}
+
+var (
+	jsonUnmarshalerType = reflect.TypeOf((*json.Unmarshaler)(nil)).Elem()
+	textUnmarshalerType = reflect.TypeOf((*encoding.TextUnmarshaler)(nil)).Elem()
+)
+
+// ValidationError is a problem in the config along with the JSON path to it.
+type ValidationError struct {
+	Path    string
+	Message string
+}
+
+func (e ValidationError) Error() string {
+	if e.Path == "" {
+		return e.Message
+	}
+
+	return e.Path + ": " + e.Message
+}
+
+// ValidationErrors lists all the problems found in the config.
+type ValidationErrors []ValidationError
+
+func (ve ValidationErrors) Error() string {
+	msgs := make([]string, len(ve))
+	for i, e := range ve {
+		msgs[i] = e.Error()
+	}
+
+	return strings.Join(msgs, "\n")
+}
+
+func (ve *ValidationErrors) add(path, format string, args ...interface{}) {
+	*ve = append(*ve, ValidationError{Path: path, Message: fmt.Sprintf(format, args...)})
+}
+
+// Validate checks the config for mistakes that would otherwise only show up while
+// syncing, and returns ValidationErrors listing every problem found in the config.
+func (c *Config) Validate() error {
+	ve := append(ValidationErrors{}, c.problems...)
+
+	if c.Period.Duration < 0 {
+		ve.add("period", "must not be negative")
+	}
+	if c.Concurrency.MaxSyncs < 0 {
+		ve.add("concurrency.max_syncs", "must not be negative")
+	}
+	if c.Concurrency.MaxPerHost < 0 {
+		ve.add("concurrency.max_per_host", "must not be negative")
+	}
+	if c.Retry.Attempts < 0 {
+		ve.add("retry.attempts", "must not be negative")
+	}
+	if c.Retry.Jitter < 0 || c.Retry.Jitter > 1 {
+		ve.add("retry.jitter", "must be between 0 and 1")
+	}
+	if c.Breaker.Failures < 0 {
+		ve.add("breaker.failures", "must not be negative")
+	}
+	c.Notify.validate(&ve)
+	c.Logging.validate(&ve)
+	if c.Metrics.Listen != "" {
+		if _, _, err := net.SplitHostPort(c.Metrics.Listen); err != nil {
+			ve.add("metrics.listen", "[%v] must be a host:port address :: %v", c.Metrics.Listen, err)
+		}
+	}
+	if c.Metrics.Path != "" && !strings.HasPrefix(c.Metrics.Path, "/") {
+		ve.add("metrics.path", "[%v] must start with '/'", c.Metrics.Path)
+	}
+
+	authNames := make([]string, 0, len(c.Auth))
+	for name, am := range c.Auth {
+		authNames = append(authNames, name)
+		if am == nil {
+			ve.add(jsonPath("auth", name), "must not be empty")
+		} else if am.SSH != nil {
+			validateSSH(&ve, jsonPath(jsonPath("auth", name), "ssh"), am.SSH)
+		}
+	}
+	sort.Strings(authNames)
+
+	syncNames := make(map[string]int)
+	for i, sc := range c.Sync {
+		path := fmt.Sprintf("sync[%d]", i)
+		switch {
+		case sc.Name == "":
+			ve.add(path+".name", "is required")
+		case !ValidSyncName(sc.Name):
+			ve.add(path+".name", "[%v] may only contain letters, digits, '.', '_' and '-'", sc.Name)
+		}
+		if j, dup := syncNames[sc.Name]; dup && sc.Name != "" {
+			ve.add(path+".name", "[%v] is already used by sync[%d]", sc.Name, j)
+		} else {
+			syncNames[sc.Name] = i
+		}
+
+		c.validateSync(&ve, path, sc, authNames)
+	}
+
+	if len(ve) == 0 {
+		return nil
+	}
+
+	return ve
+}
+
+func (c *Config) validateSync(ve *ValidationErrors, path string, sc SyncConfig, authNames []string) {
+	switch {
+	case sc.Period.Duration < 0:
+		ve.add(path+".period", "must not be negative")
+	case sc.Cron != "":
+		if _, err := cron.ParseStandard(sc.Cron); err != nil {
+			ve.add(path+".cron", "invalid cron expression [%v] :: %v", sc.Cron, err)
+		}
+	case sc.Period.Duration == 0 && c.Period.Duration <= 0:
+		ve.add(path+".period", "is required as the global period is not set")
+	}
+
+	switch sc.Direction {
+	case "", DirectionOneWay, DirectionBoth:
+	default:
+		ve.add(path+".direction", "[%v] must be one of %q or %q", sc.Direction, DirectionOneWay, DirectionBoth)
+	}
+	switch sc.OnConflict {
+	case "", ConflictStop, ConflictMerge:
+	default:
+		ve.add(path+".conflict", "[%v] must be one of %q or %q", sc.OnConflict, ConflictStop, ConflictMerge)
+	}
+
+	if len(sc.ToList) == 0 {
+		ve.add(path+".to", "at least one target repo is required")
+	}
+
+	remoteNames := make(map[string]string)
+	validateRepo := func(repoPath string, r Repo) {
+		switch {
+		case r.Name == "":
+			ve.add(repoPath+".name", "is required")
+		case !cNameRegex.MatchString(r.Name):
+			ve.add(repoPath+".name", "[%v] may only contain letters, digits, '.', '_' and '-'", r.Name)
+		}
+		if other, dup := remoteNames[r.Name]; dup && r.Name != "" {
+			ve.add(repoPath+".name", "[%v] is already used by %v", r.Name, other)
+		} else {
+			remoteNames[r.Name] = repoPath
+		}
+
+		switch kind := r.HostKind(); {
+		case !slices.Contains(Kinds(), kind):
+			ve.add(repoPath+".kind", "[%v] must be one of %v", r.Kind, Kinds())
+		case r.URLToRepo == "":
+			ve.add(repoPath+".url", "is required")
+		case kind == KindOverleaf:
+			if _, ok := OverleafProjectID(r.URLToRepo); !ok {
+				ve.add(repoPath+".url", "[%v] must look like https://git.overleaf.com/<project id>", r.URLToRepo)
+			}
+		}
+
+		if r.AuthToUse != "" {
+			if i := sort.SearchStrings(authNames, r.AuthToUse); i == len(authNames) ||
+				authNames[i] != r.AuthToUse {
+				ve.add(repoPath+".auth", "[%v] is not defined in auth, defined are %v", r.AuthToUse, authNames)
+			}
+		}
+
+		if am := c.Auth[r.AuthToUse]; am != nil {
+			switch {
+			case IsSSHURL(r.URLToRepo) && am.SSH == nil:
+				ve.add(repoPath+".auth", "[%v] has no ssh settings for the ssh url", r.AuthToUse)
+			case isHTTPURL(r.URLToRepo) && am.BasicAuth == nil && am.TokenAuth == nil:
+				ve.add(repoPath+".auth", "[%v] has no password or token for the http url", r.AuthToUse)
+			}
+		}
+	}
+
+	validateFrom := func(repoPath string, r Repo) {
+		validateRepo(repoPath, r)
+		if r.Create {
+			ve.add(repoPath+".create", "only `to` repos can be created")
+		}
+		if r.PullRequest != nil {
+			ve.add(repoPath+".pull_request", "pull requests can only be opened to `to` repos")
+		}
+		if r.PushPolicy != "" {
+			ve.add(repoPath+".push_policy", "only applies to `to` repos")
+		}
+	}
+	if len(sc.Sources) == 0 {
+		validateFrom(path+".from", sc.From)
+	} else {
+		if sc.From.Name != "" || sc.From.URLToRepo != "" {
+			ve.add(path+".from", "cannot be set along with sources")
+		}
+		if sc.Direction == DirectionBoth {
+			ve.add(path+".sources", "are not supported with direction %q", DirectionBoth)
+		}
+		if len(sc.Branches) > 0 {
+			ve.add(path+".branches", "is not supported with sources, set the branch of the sources instead")
+		}
+
+		// the sources synced to each branch, which must all have non overlapping paths
+		synced := make(map[string][]int)
+		for i, src := range sc.Sources {
+			srcPath := fmt.Sprintf("%v.sources[%d]", path, i)
+			validateFrom(srcPath, src.Repo)
+			if src.Path != "" && !isSubPath(src.Path) {
+				ve.add(srcPath+".path", "[%v] must be a relative path without '.' or '..' elements", src.Path)
+			}
+
+			branch := src.TargetBranch()
+			for _, j := range synced[branch] {
+				other := sc.Sources[j]
+				if src.Path == "" || other.Path == "" {
+					ve.add(srcPath+".branch", "[%v] is already synced from %v.sources[%d]", branch, path, j)
+					break
+				}
+				if overlapPaths(src.Path, other.Path) {
+					ve.add(srcPath+".path", "[%v] overlaps with the path of %v.sources[%d]", src.Path, path, j)
+					break
+				}
+			}
+			synced[branch] = append(synced[branch], i)
+		}
+	}
+	for i, to := range sc.ToList {
+		toPath := fmt.Sprintf("%v.to[%d]", path, i)
+		validateRepo(toPath, to)
+		switch {
+		case to.Create:
+			c.validateAPI(ve, toPath, to, "create")
+		case to.PullRequest != nil:
+			c.validateAPI(ve, toPath, to, "pull_request")
+		}
+		if to.Create {
+			switch to.Visibility {
+			case "", VisibilityPrivate, VisibilityPublic, VisibilityInternal:
+			default:
+				ve.add(toPath+".visibility", "[%v] must be one of %q, %q or %q",
+					to.Visibility, VisibilityPrivate, VisibilityPublic, VisibilityInternal)
+			}
+		}
+		if pc := to.PullRequest; pc != nil {
+			if strings.Count(pc.Branch, "*") > 1 {
+				ve.add(toPath+".pull_request.branch", "[%v] may contain at most one '*'", pc.Branch)
+			}
+			if sc.Direction == DirectionBoth {
+				ve.add(toPath+".pull_request", "is not supported with direction %q", DirectionBoth)
+			}
+		}
+		switch to.PushPolicy {
+		case "", PushFastForwardOnly:
+		case PushForceWithLease, PushForce:
+			if sc.Direction == DirectionBoth {
+				ve.add(toPath+".push_policy", "[%v] is not supported with direction %q", to.PushPolicy, DirectionBoth)
+			}
+		default:
+			ve.add(toPath+".push_policy", "[%v] must be one of %q, %q or %q", to.PushPolicy,
+				PushFastForwardOnly, PushForceWithLease, PushForce)
+		}
+	}
+}
+
+// isSubPath returns whether the path is a clean relative path to a folder within a repo.
+func isSubPath(p string) bool {
+	return !filepath.IsAbs(p) && filepath.ToSlash(filepath.Clean(p)) == p && p != "." &&
+		p != ".." && !strings.HasPrefix(p, "../") && !strings.HasPrefix(p, "/")
+}
+
+// overlapPaths returns whether either of the folders is within the other.
+func overlapPaths(a, b string) bool {
+	return a == b || strings.HasPrefix(a, b+"/") || strings.HasPrefix(b, a+"/")
+}
+
+// validateAPI validates that the feature, which uses the API of the host, can be used with the repo.
+func (c *Config) validateAPI(ve *ValidationErrors, path string, r Repo, feature string) {
+	if kind := r.HostKind(); !slices.Contains(apiKinds, kind) {
+		ve.add(path+"."+feature, "is not supported for %v repos, only for %v", kind, apiKinds)
+	} else if _, _, err := r.OwnerAndName(); err != nil && r.URLToRepo != "" {
+		ve.add(path+".url", "%v", err)
+	}
+
+	if am := c.Auth[r.AuthToUse]; am == nil || (am.TokenAuth == nil && am.BasicAuth == nil) {
+		ve.add(path+".auth", "a token or password is required for %v", feature)
+	}
+}
+
+func validateSSH(ve *ValidationErrors, path string, sa *SSHAuth) {
+	if !sa.Agent {
+		if sa.KeyPath == "" {
+			ve.add(path+".key_path", "is required unless agent is set")
+		} else if _, err := os.Stat(ExpandHome(sa.KeyPath)); err != nil {
+			ve.add(path+".key_path", "[%v] is not readable :: %v", sa.KeyPath, err)
+		}
+	}
+
+	if sa.KnownHosts != "" {
+		if _, err := os.Stat(ExpandHome(sa.KnownHosts)); err != nil {
+			ve.add(path+".known_hosts", "[%v] is not readable :: %v", sa.KnownHosts, err)
+		}
+	}
+}
+
+// checkFields returns the JSON paths of the values in the config that either don't
+// map to any field of the config, or that cannot be parsed into their field.
+// Keys are matched to fields case insensitively, the same as encoding/json.
+func checkFields(data []byte) ValidationErrors {
+	var ve ValidationErrors
+	checkValue(&ve, json.RawMessage(data), reflect.TypeOf(Config{}), "")
+	return ve
+}
+
+func checkValue(ve *ValidationErrors, raw json.RawMessage, t reflect.Type, path string) {
+	if string(raw) == "null" {
+		return
+	}
+
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if reflect.PointerTo(t).Implements(jsonUnmarshalerType) ||
+		reflect.PointerTo(t).Implements(textUnmarshalerType) {
+
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			ve.add(path, "%v", err)
+		}
+		return
+	}
+
+	switch t.Kind() {
+	case reflect.Struct:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			ve.add(path, "expected an object")
+			return
+		}
+
+		fields := jsonFields(t)
+		for _, key := range sortedKeys(obj) {
+			f, ok := lookupField(fields, key)
+			if !ok {
+				ve.add(jsonPath(path, key), "unknown field")
+				continue
+			}
+			checkValue(ve, obj[key], f.Type, jsonPath(path, key))
+		}
+	case reflect.Map:
+		var obj map[string]json.RawMessage
+		if err := json.Unmarshal(raw, &obj); err != nil {
+			ve.add(path, "expected an object")
+			return
+		}
+
+		for _, key := range sortedKeys(obj) {
+			checkValue(ve, obj[key], t.Elem(), jsonPath(path, key))
+		}
+	case reflect.Slice:
+		var arr []json.RawMessage
+		if err := json.Unmarshal(raw, &arr); err != nil {
+			ve.add(path, "expected an array")
+			return
+		}
+
+		for i, elem := range arr {
+			checkValue(ve, elem, t.Elem(), fmt.Sprintf("%v[%d]", path, i))
+		}
+	default:
+		if err := json.Unmarshal(raw, reflect.New(t).Interface()); err != nil {
+			ve.add(path, "expected a value of type %v", t.Kind())
+		}
+	}
+}
+
+// jsonFields returns the fields of a struct keyed by their JSON name,
+// including the fields promoted from embedded structs.
+func jsonFields(t reflect.Type) map[string]reflect.StructField {
+	fields := make(map[string]reflect.StructField)
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, _, _ := strings.Cut(tag, ",")
+
+		ft := f.Type
+		if ft.Kind() == reflect.Ptr {
+			ft = ft.Elem()
+		}
+		if f.Anonymous && name == "" && ft.Kind() == reflect.Struct {
+			for n, ef := range jsonFields(ft) {
+				if _, ok := fields[n]; !ok {
+					fields[n] = ef
+				}
+			}
+			continue
+		}
+
+		if !f.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		fields[name] = f
+	}
+
+	return fields
+}
+
+func lookupField(fields map[string]reflect.StructField, key string) (reflect.StructField, bool) {
+	if f, ok := fields[key]; ok {
+		return f, true
+	}
+
+	for name, f := range fields {
+		if strings.EqualFold(name, key) {
+			return f, true
+		}
+	}
+
+	return reflect.StructField{}, false
+}
+
+func sortedKeys(obj map[string]json.RawMessage) []string {
+	keys := make([]string, 0, len(obj))
+	for k := range obj {
+		keys = append(keys, k)
+	}
+
+	sort.Strings(keys)
+	return keys
+}
+
+func jsonPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+
+	return path + "." + key
+}
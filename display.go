@@ -0,0 +1,18 @@
+package main
+
+import (
+	"os"
+	"runtime"
+)
+
+// hasDisplay returns whether a desktop session is available for the system tray.
+// Only the X11 and Wayland sessions on Linux and BSDs need to be detected, a
+// display is always assumed to be available on Mac and Windows.
+func hasDisplay() bool {
+	switch runtime.GOOS {
+	case "darwin", "windows":
+		return true
+	default:
+		return os.Getenv("DISPLAY") != "" || os.Getenv("WAYLAND_DISPLAY") != ""
+	}
+}
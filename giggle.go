@@ -1,10 +1,15 @@
 package main
 
 import (
+	"errors"
+	"flag"
 	"fmt"
-	"log"
+	"io"
+	"log/slog"
+	"net"
 	"os"
 	"os/signal"
+	"strings"
 	"syscall"
 
 	"github.com/getlantern/systray"
@@ -16,29 +21,126 @@ import (
 	"gopkg.in/natefinch/lumberjack.v2"
 )
 
+// options are the command line flags of giggle.
+type options struct {
+	// headless runs the service without the system tray.
+	headless bool
+	// foreground runs giggle without daemonizing, logging to stdout.
+	foreground bool
+}
+
+var opts options
+
 func dialogAndPanic(message string, err error) {
-	log.Println(message)
-	dialog.Message("%v", message).Error() //nolint:govet
+	slog.Error(message)
+	if !opts.headless {
+		dialog.Message("%v", message).Error() //nolint:govet
+	}
 	panic(err)
 }
 
 func main() {
-	logFolder := conf.LogFolder()
-	if _, err := os.Stat(logFolder); err != nil {
-		if os.IsNotExist(err) {
-			errDir := os.MkdirAll(logFolder, conf.DirPerm())
-			if errDir != nil {
-				message := fmt.Sprintf("[ERROR] unable to create log folder :: %v", errDir)
-				dialogAndPanic(message, errDir)
-			} else {
-				log.Println("[INFO] created log directory")
+	configFile, args, err := parseConfigFlag(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "%v\n\n%v\n", err, usage())
+		os.Exit(2)
+	}
+	if err := conf.Setup(configFile); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	name := cRunCommand
+	if len(args) > 0 && !strings.HasPrefix(args[0], "-") {
+		name, args = args[0], args[1:]
+	}
+
+	cmd, ok := commands[name]
+	if !ok {
+		fmt.Fprintf(os.Stderr, "unknown command [%v]\n\n%v\n", name, usage())
+		os.Exit(2)
+	}
+
+	if err := cmd.run(args); errors.Is(err, flag.ErrHelp) {
+		return
+	} else if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+// parseConfigFlag returns the value of the -config flag given before the command, if
+// any, and the rest of the arguments. The flags of the commands are left to them.
+func parseConfigFlag(args []string) (string, []string, error) {
+	var configFile string
+	for len(args) > 0 {
+		switch arg := args[0]; {
+		case arg == "-config" || arg == "--config":
+			if len(args) < 2 {
+				return "", nil, errors.New("flag needs an argument: -config")
 			}
-		} else {
-			message := fmt.Sprintf("[ERROR] unable to get log folder stats :: %v", err)
+			configFile, args = args[1], args[2:]
+		case strings.HasPrefix(arg, "-config=") || strings.HasPrefix(arg, "--config="):
+			configFile, args = arg[strings.Index(arg, "=")+1:], args[1:]
+		default:
+			return configFile, args, nil
+		}
+	}
+
+	return configFile, args, nil
+}
+
+// run runs giggle as a daemon with the system tray, until it is stopped.
+func run(args []string) error {
+	fs := flag.NewFlagSet(cRunCommand, flag.ContinueOnError)
+	fs.BoolVar(&opts.headless, "headless", false, "run the sync service without the system tray")
+	fs.BoolVar(&opts.foreground, "foreground", false, "run in the foreground and log to stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if !opts.headless && !hasDisplay() {
+		slog.Info("no display available, running headless")
+		opts.headless = true
+	}
+
+	for _, folder := range []string{conf.DataFolder(), conf.LogFolder()} {
+		if err := os.MkdirAll(folder, conf.DirPerm()); err != nil {
+			message := fmt.Sprintf("unable to create folder %v :: %v", folder, err)
 			dialogAndPanic(message, err)
 		}
-	} else {
-		log.Println("[INFO] log directory already exists")
+	}
+
+	// the passphrase is asked for before daemonizing as the daemon has no terminal
+	passphrase, hasStore, err := unlockStore()
+	if err != nil {
+		message := fmt.Sprintf("unable to unlock credential store :: %v", err)
+		dialogAndPanic(message, err)
+	}
+
+	if opts.foreground {
+		pidFile, err := daemon.CreatePidFile(conf.PidFilePath(), 0644)
+		if err != nil {
+			message := fmt.Sprintf("unable to create pid file :: %v", err)
+			dialogAndPanic(message, err)
+		}
+		defer func() {
+			if err := pidFile.Remove(); err != nil {
+				slog.Warn("unable to remove pid file", "err", err)
+			}
+		}()
+		runChild()
+		return nil
+	}
+
+	// the passphrase is handed over to the daemon through a socket, as
+	// it would stay readable in the environment of the daemon otherwise
+	var ln *net.UnixListener
+	if hasStore && !daemon.WasReborn() {
+		if ln, err = listenHandover(); err != nil {
+			message := fmt.Sprintf("unable to hand the passphrase over to the daemon :: %v", err)
+			dialogAndPanic(message, err)
+		}
+		defer closeHandover(ln)
 	}
 
 	dctx := &daemon.Context{
@@ -47,47 +149,88 @@ func main() {
 	}
 	child, err := dctx.Reborn()
 	if err != nil {
-		message := fmt.Sprintf("[ERROR] unable to daemonize :: %v", err)
+		message := fmt.Sprintf("unable to daemonize :: %v", err)
 		dialogAndPanic(message, err)
 	}
 
 	if child != nil {
-		log.Println("running the service as a daemon")
+		if ln != nil {
+			if err := handOver(ln, passphrase); err != nil {
+				return fmt.Errorf("unable to hand the passphrase over to the daemon :: %w", err)
+			}
+		}
+		slog.Info("running the service as a daemon")
 	} else {
 		defer func() {
 			if err := dctx.Release(); err != nil {
-				log.Printf("error releasing daemon context: %v", err)
+				slog.Warn("unable to release daemon context", "err", err)
 			}
 		}()
 		runChild()
 	}
+
+	return nil
 }
 
-func runChild() {
-	log.SetFlags(log.LstdFlags | log.Lshortfile)
+// unlockStore unlocks the credential store if it exists, and returns the passphrase used.
+// The daemon receives the passphrase from the process that started it, see handOver.
+func unlockStore() (string, bool, error) {
+	if !conf.HasCredentialStore() {
+		return "", false, nil
+	}
+
+	var passphrase string
+	var err error
+	if daemon.WasReborn() {
+		passphrase, err = receiveHandover()
+	} else {
+		passphrase, err = storePassphrase()
+	}
+	if err != nil {
+		return "", true, err
+	}
+	if err := conf.UnlockCredentialStore(passphrase); err != nil {
+		return "", true, err
+	}
+	if err := os.Unsetenv(conf.PassphraseEnv()); err != nil {
+		slog.Warn("unable to clear passphrase from environment", "err", err)
+	}
+
+	return passphrase, true, nil
+}
 
-	logFilePath := conf.LogFilePath()
-	log.SetOutput(&lumberjack.Logger{
-		Filename:   logFilePath,
-		MaxSize:    conf.LogFileMaxSize(),
-		MaxBackups: conf.LogMaxNumBackups(),
-		MaxAge:     conf.LogFileMaxAge(),
-		LocalTime:  true,
-	})
+func runChild() {
+	var w io.Writer = os.Stdout
+	if !opts.foreground {
+		w = &lumberjack.Logger{
+			Filename:   conf.LogFilePath(),
+			MaxSize:    conf.LogFileMaxSize(),
+			MaxBackups: conf.LogMaxNumBackups(),
+			MaxAge:     conf.LogFileMaxAge(),
+			LocalTime:  true,
+		}
+	}
+	// the logs of the standard log package, e.g. from dependencies, go to the same handler
+	slog.SetDefault(slog.New(conf.NewLogHandler(w, conf.StartupLogging())))
 
-	log.Println("#################### BEGIN OF LOG ##########################")
+	slog.Info("#################### BEGIN OF LOG ##########################")
 
 	// register ctrl+c
 	sigs := make(chan os.Signal, 1)
 	signal.Notify(sigs, syscall.SIGINT, syscall.SIGTERM)
-	log.Println("[INFO] adding signal handler for SIGTERM")
+	slog.Info("adding signal handler for SIGTERM")
+
+	if opts.headless {
+		runHeadless(sigs)
+		return
+	}
 
 	// giggle system tray
 	quit := make(chan struct{})
 	gt := tray.Start(quit)
 	defer func() {
 		if err := gt.Stop(); err != nil {
-			log.Println("[WARN] unable to stop giggle tray ::", err)
+			slog.Warn("unable to stop giggle tray", "err", err)
 		}
 	}()
 
@@ -95,13 +238,13 @@ func runChild() {
 	gsvc := svc.Start()
 	defer func() {
 		if err := gsvc.Stop(); err != nil {
-			log.Println("[WARN] unable to stop giggle service ::", err)
+			slog.Warn("unable to stop giggle service", "err", err)
 		}
 	}()
 
 	go func() {
 		// wait for ctrl+c
-		log.Println("[INFO] waiting for ctrl+c signal")
+		slog.Info("waiting for ctrl+c signal")
 		select {
 		case <-quit:
 		case <-sigs:
@@ -112,5 +255,18 @@ func runChild() {
 
 	// This has to be called here in the main thread, fails on mac otherwise.
 	systray.Run(gt.OnReady, nil)
-	log.Println("[INFO] exiting giggle")
+	slog.Info("exiting giggle")
+}
+
+// runHeadless runs the giggle service alone until a signal is received.
+func runHeadless(sigs chan os.Signal) {
+	gsvc := svc.Start()
+
+	slog.Info("waiting for ctrl+c signal")
+	<-sigs
+
+	if err := gsvc.Stop(); err != nil {
+		slog.Warn("unable to stop giggle service", "err", err)
+	}
+	slog.Info("exiting giggle")
 }
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+	"golang.org/x/term"
+)
+
+const secretUsage = `usage:
+  giggle secret set <name>       store a secret in the encrypted credential store
+  giggle secret delete <name>    remove a secret from the encrypted credential store
+  giggle secret list             list the names of the secrets in the credential store
+  giggle secret keyring <name>   store a secret in the OS keyring`
+
+// runSecret manages the secrets referred to by the config as store:<name> or keyring:<name>.
+func runSecret(args []string) error {
+	if len(args) == 0 || (args[0] != "list" && len(args) != 2) {
+		return errors.New(secretUsage)
+	}
+
+	if args[0] == "keyring" {
+		secret, err := readSecret(fmt.Sprintf("secret for %v: ", args[1]))
+		if err != nil {
+			return err
+		}
+		return conf.SetKeyringSecret(args[1], secret)
+	}
+
+	passphrase, err := storePassphrase()
+	if err != nil {
+		return err
+	}
+	cs, err := conf.OpenCredentialStore(conf.CredentialStoreFilePath(), passphrase)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "set":
+		secret, err := readSecret(fmt.Sprintf("secret for %v: ", args[1]))
+		if err != nil {
+			return err
+		}
+		cs.Set(args[1], secret)
+	case "delete":
+		cs.Delete(args[1])
+	case "list":
+		for _, name := range cs.Names() {
+			fmt.Println(name)
+		}
+		return nil
+	default:
+		return errors.New(secretUsage)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(conf.CredentialStoreFilePath()), conf.DirPerm()); err != nil {
+		return fmt.Errorf("error creating app folder :: %w", err)
+	}
+	return cs.Save()
+}
+
+// storePassphrase returns the passphrase of the credential store from
+// the environment, or asks for it if giggle is running in a terminal.
+func storePassphrase() (string, error) {
+	if passphrase, ok := os.LookupEnv(conf.PassphraseEnv()); ok {
+		return passphrase, nil
+	}
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		return "", fmt.Errorf("no terminal to ask for the passphrase, set %v", conf.PassphraseEnv())
+	}
+
+	return readSecret("credential store passphrase: ")
+}
+
+// readSecret reads a secret without echoing it if stdin is a terminal, or a line from stdin otherwise.
+func readSecret(prompt string) (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil && line == "" {
+			return "", fmt.Errorf("error reading secret :: %w", err)
+		}
+		return strings.TrimRight(line, "\r\n"), nil
+	}
+
+	fmt.Fprint(os.Stderr, prompt)
+	secret, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("error reading secret :: %w", err)
+	}
+
+	return string(secret), nil
+}
+
+// listenHandover listens on the handover socket, that only the user can connect to.
+func listenHandover() (*net.UnixListener, error) {
+	socketPath := conf.HandoverSocketPath()
+	// the socket is left behind if giggle didn't exit cleanly
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error removing stale socket :: %w", err)
+	}
+
+	ln, err := net.ListenUnix("unix", &net.UnixAddr{Name: socketPath, Net: "unix"})
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket :: %w", err)
+	}
+	if err := os.Chmod(socketPath, conf.SecureFilePerm()); err != nil {
+		closeHandover(ln)
+		return nil, fmt.Errorf("error modifying perm for socket :: %w", err)
+	}
+
+	return ln, nil
+}
+
+// closeHandover stops listening on the handover socket and removes it.
+func closeHandover(ln *net.UnixListener) {
+	if err := ln.Close(); err != nil && !errors.Is(err, net.ErrClosed) {
+		slog.Warn("unable to close handover socket", "err", err)
+	}
+}
+
+// handOver sends the passphrase to the daemon once it connects to the handover socket.
+// The socket is closed right after, so that the passphrase is handed over only once.
+func handOver(ln *net.UnixListener, passphrase string) error {
+	defer closeHandover(ln)
+
+	if err := ln.SetDeadline(time.Now().Add(cHandoverTimeout)); err != nil {
+		return fmt.Errorf("error setting deadline :: %w", err)
+	}
+	c, err := ln.Accept()
+	if err != nil {
+		return fmt.Errorf("error waiting for the daemon :: %w", err)
+	}
+	defer c.Close()
+
+	if _, err := io.WriteString(c, passphrase); err != nil {
+		return fmt.Errorf("error sending passphrase :: %w", err)
+	}
+
+	return nil
+}
+
+// receiveHandover receives the passphrase from the process that started the daemon.
+func receiveHandover() (string, error) {
+	c, err := net.DialTimeout("unix", conf.HandoverSocketPath(), cHandoverTimeout)
+	if err != nil {
+		return "", fmt.Errorf("error connecting to handover socket :: %w", err)
+	}
+	defer c.Close()
+
+	if err := c.SetReadDeadline(time.Now().Add(cHandoverTimeout)); err != nil {
+		return "", fmt.Errorf("error setting deadline :: %w", err)
+	}
+	passphrase, err := io.ReadAll(c)
+	if err != nil {
+		return "", fmt.Errorf("error receiving passphrase :: %w", err)
+	}
+
+	return string(passphrase), nil
+}
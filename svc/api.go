@@ -0,0 +1,257 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+const (
+	cAPIShutdownTimeout = 5 * time.Second
+	cAPIHeaderTimeout   = 10 * time.Second
+)
+
+// Status is the state of the running service returned by the control API.
+type Status struct {
+	Syncs    []SyncStatus   `json:"syncs"`
+	Breakers []BreakerState `json:"breakers,omitempty"`
+}
+
+// SyncResult is the result of a sync triggered through the control API.
+type SyncResult struct {
+	Name  string `json:"name"`
+	Error string `json:"error,omitempty"`
+}
+
+// syncRequest is the body of the requests that act on a set of syncs, all if empty.
+type syncRequest struct {
+	Names []string `json:"names"`
+}
+
+type errorResponse struct {
+	Error string `json:"error"`
+}
+
+// apiServer serves the control API over a Unix socket, so that the
+// CLI and scripts can drive the service once it is daemonized.
+type apiServer struct {
+	sched  *scheduler
+	reload func(ctx context.Context) error
+
+	socketPath string
+	server     *http.Server
+}
+
+// serveAPI starts serving the control API on the Unix socket at the path.
+func serveAPI(socketPath string, sched *scheduler, reload func(ctx context.Context) error) (*apiServer, error) {
+	// the socket is left behind if giggle didn't exit cleanly
+	if err := os.Remove(socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("error removing stale socket :: %w", err)
+	}
+
+	ln, err := net.Listen("unix", socketPath)
+	if err != nil {
+		return nil, fmt.Errorf("error listening on socket :: %w", err)
+	}
+	if err := os.Chmod(socketPath, conf.SecureFilePerm()); err != nil {
+		ln.Close()
+		return nil, fmt.Errorf("error modifying perm for socket :: %w", err)
+	}
+
+	as := &apiServer{sched: sched, reload: reload, socketPath: socketPath}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /status", as.handleStatus)
+	mux.HandleFunc("POST /sync", as.handleSync)
+	mux.HandleFunc("POST /sync/{name}", as.handleSync)
+	mux.HandleFunc("POST /pause", as.handlePause(true))
+	mux.HandleFunc("POST /pause/{name}", as.handlePause(true))
+	mux.HandleFunc("POST /resume", as.handlePause(false))
+	mux.HandleFunc("POST /resume/{name}", as.handlePause(false))
+	mux.HandleFunc("POST /purge/{name}", as.handlePurge)
+	mux.HandleFunc("POST /reload", as.handleReload)
+	mux.HandleFunc("GET /history", as.handleHistory)
+	as.server = &http.Server{Handler: mux, ReadHeaderTimeout: cAPIHeaderTimeout}
+
+	go func() {
+		if err := as.server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("error serving control API", "err", err)
+		}
+	}()
+
+	slog.Info("serving control API", "socket", socketPath)
+	return as, nil
+}
+
+func (as *apiServer) stop() {
+	ctx, cancel := context.WithTimeout(context.Background(), cAPIShutdownTimeout)
+	defer cancel()
+
+	if err := as.server.Shutdown(ctx); err != nil {
+		slog.Warn("error shutting down control API", "err", err)
+	}
+	if err := os.Remove(as.socketPath); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("error removing socket", "err", err)
+	}
+}
+
+func (as *apiServer) handleStatus(w http.ResponseWriter, _ *http.Request) {
+	writeJSON(w, http.StatusOK, Status{Syncs: as.sched.statuses(), Breakers: BreakerStates()})
+}
+
+// handleSync triggers the syncs right away. With ?wait=true, it responds
+// once the syncs are done with their results, and right away otherwise.
+func (as *apiServer) handleSync(w http.ResponseWriter, r *http.Request) {
+	names, err := requestNames(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	results, err := as.sched.trigger(names)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	list := make([]SyncResult, 0, len(results))
+	for name := range results {
+		list = append(list, SyncResult{Name: name})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	if r.URL.Query().Get("wait") != "true" {
+		writeJSON(w, http.StatusAccepted, list)
+		return
+	}
+
+	for i := range list {
+		select {
+		case err := <-results[list[i].Name]:
+			if err != nil {
+				list[i].Error = err.Error()
+			}
+		case <-r.Context().Done():
+			return
+		}
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (as *apiServer) handlePause(paused bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		names, err := requestNames(r)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if err := as.sched.setPaused(names, paused); err != nil {
+			writeError(w, err)
+			return
+		}
+		writeJSON(w, http.StatusOK, as.sched.statuses())
+	}
+}
+
+// handlePurge deletes the local clone of the sync, see scheduler.purge.
+func (as *apiServer) handlePurge(w http.ResponseWriter, r *http.Request) {
+	if err := as.sched.purge(r.Context(), r.PathValue("name")); err != nil {
+		var errInvalid *ErrInvalidSyncName
+		if errors.As(err, &errInvalid) {
+			writeError(w, err)
+		} else {
+			writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		}
+		return
+	}
+
+	writeJSON(w, http.StatusOK, as.sched.statuses())
+}
+
+func (as *apiServer) handleReload(w http.ResponseWriter, r *http.Request) {
+	if err := as.reload(r.Context()); err != nil {
+		writeJSON(w, http.StatusUnprocessableEntity, errorResponse{Error: err.Error()})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, as.sched.statuses())
+}
+
+// handleHistory returns the history of the sync runs filtered by the query parameters
+// sync, since and until (RFC 3339), commit, target and limit.
+func (as *apiServer) handleHistory(w http.ResponseWriter, r *http.Request) {
+	params := r.URL.Query()
+	q := HistoryQuery{Sync: params.Get("sync"), Commit: params.Get("commit"), Target: params.Get("target")}
+
+	var err error
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"since", &q.Since}, {"until", &q.Until}} {
+		if v := params.Get(p.name); v != "" {
+			if *p.t, err = time.Parse(time.RFC3339, v); err != nil {
+				writeError(w, fmt.Errorf("invalid %v [%v] :: %w", p.name, v, err))
+				return
+			}
+		}
+	}
+	if v := params.Get("limit"); v != "" {
+		if q.Limit, err = strconv.Atoi(v); err != nil {
+			writeError(w, fmt.Errorf("invalid limit [%v] :: %w", v, err))
+			return
+		}
+	}
+
+	records, err := ReadHistory(as.sched.store.historyPath, q)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, errorResponse{Error: err.Error()})
+		return
+	}
+	if records == nil {
+		records = []RunRecord{}
+	}
+	writeJSON(w, http.StatusOK, records)
+}
+
+// requestNames returns the names of the syncs from the path or from the body of the request.
+func requestNames(r *http.Request) ([]string, error) {
+	if name := r.PathValue("name"); name != "" {
+		return []string{name}, nil
+	}
+
+	var req syncRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("error decoding request :: %w", err)
+	}
+
+	return req.Names, nil
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	status := http.StatusBadRequest
+	var errUnknown *ErrUnknownSync
+	if errors.As(err, &errUnknown) {
+		status = http.StatusNotFound
+	}
+
+	writeJSON(w, status, errorResponse{Error: err.Error()})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		slog.Warn("error writing response", "err", err)
+	}
+}
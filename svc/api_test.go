@@ -0,0 +1,137 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestControlAPI(t *testing.T) {
+	var mu sync.Mutex
+	synced := make(map[string]int)
+	s := newScheduler(testStatusStore(t))
+	s.syncFn = func(_ context.Context, sc conf.SyncConfig, _ map[string]*conf.AuthMethod) error {
+		mu.Lock()
+		defer mu.Unlock()
+		synced[sc.Name]++
+		if sc.Name == "b" {
+			return errors.New("remote is down")
+		}
+		return nil
+	}
+	defer s.stop()
+
+	cf := &conf.Config{Sync: []conf.SyncConfig{{Name: "a"}, {Name: "b"}}}
+	cf.Period.Duration = time.Hour
+	s.apply(cf)
+
+	reloaded := 0
+	socketPath := filepath.Join(t.TempDir(), "giggle.sock")
+	as, err := serveAPI(socketPath, s, func(context.Context) error {
+		reloaded++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error serving API :: %v", err)
+	}
+	defer as.stop()
+
+	ctx := context.Background()
+	c := NewClient(socketPath)
+
+	results, err := c.SyncNow(ctx, true)
+	if err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	if len(results) != 2 || results[0].Error != "" || results[1].Error != "remote is down" {
+		t.Fatalf("unexpected results: %+v", results)
+	}
+
+	if _, err := c.SyncNow(ctx, true, "missing"); err == nil {
+		t.Fatal("expected error syncing an unknown sync")
+	}
+
+	if err := c.Pause(ctx); err != nil {
+		t.Fatalf("error pausing :: %v", err)
+	}
+	if err := c.Resume(ctx, "b"); err != nil {
+		t.Fatalf("error resuming :: %v", err)
+	}
+
+	status, err := c.Status(ctx)
+	if err != nil {
+		t.Fatalf("error getting status :: %v", err)
+	}
+	if len(status.Syncs) != 2 || !status.Syncs[0].Paused || status.Syncs[1].Paused ||
+		status.Syncs[1].LastError != "remote is down" || status.Syncs[0].LastAttempt.IsZero() {
+		t.Fatalf("unexpected status: %+v", status.Syncs)
+	}
+
+	records, err := c.History(ctx, HistoryQuery{Sync: "b", Since: time.Now().Add(-time.Hour)})
+	if err != nil || len(records) != 1 || records[0].Error != "remote is down" ||
+		records[0].Trigger != TriggerManual {
+		t.Fatalf("unexpected history: %+v :: %v", records, err)
+	}
+
+	if err := c.Reload(ctx); err != nil || reloaded != 1 {
+		t.Fatalf("error reloading :: %v", err)
+	}
+
+	// the clone is deleted once the sync running on it is done
+	t.Setenv(conf.HomeEnv(), t.TempDir())
+	folder := conf.GetSyncTarget("b")
+	createTestDir(t, folder)
+	unlock, err := folderLocks.lock(ctx, folder)
+	if err != nil {
+		t.Fatalf("error locking folder :: %v", err)
+	}
+	purged := make(chan error, 1)
+	go func() { purged <- c.Purge(ctx, "b") }()
+	select {
+	case err := <-purged:
+		t.Fatalf("clone purged while in use :: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	unlock()
+	if err := <-purged; err != nil {
+		t.Fatalf("error purging :: %v", err)
+	}
+	if _, err := os.Stat(folder); !os.IsNotExist(err) {
+		t.Fatalf("clone not deleted :: %v", err)
+	}
+	if status, err = c.Status(ctx); err != nil || !status.Syncs[1].Paused {
+		t.Fatalf("purged sync not paused: %+v :: %v", status, err)
+	}
+
+	// names that lead out of the folder of the repos are rejected
+	for _, name := range []string{"%2E%2E", "..%2F..", "b%2F..%2F.."} {
+		req, err := http.NewRequestWithContext(ctx, http.MethodPost, cAPIBaseURL+"/purge/"+name, nil)
+		if err != nil {
+			t.Fatalf("error creating request :: %v", err)
+		}
+		resp, err := c.hc.Do(req)
+		if err != nil {
+			t.Fatalf("error purging [%v] :: %v", name, err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Fatalf("unexpected status purging [%v]: %v", name, resp.Status)
+		}
+	}
+	if _, err := os.Stat(conf.ReposFolder()); err != nil {
+		t.Fatalf("folder of the repos deleted :: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if synced["a"] != 1 || synced["b"] != 1 {
+		t.Fatalf("unexpected syncs: %v", synced)
+	}
+}
@@ -0,0 +1,300 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+const cConflictsFile = "giggle_conflicts.json"
+
+// Conflict records a branch whose history diverged between the `from`
+// repo and one of the `to` repos and that was not synced because of it.
+type Conflict struct {
+	Remote       string    `json:"remote"`
+	SourceBranch string    `json:"source_branch,omitempty"`
+	TargetBranch string    `json:"target_branch,omitempty"`
+	Tag          string    `json:"tag,omitempty"`
+	SourceHead   string    `json:"source_head"`
+	TargetHead   string    `json:"target_head"`
+	Paths        []string  `json:"paths,omitempty"`
+	DetectedAt   time.Time `json:"detected_at"`
+}
+
+// ErrDiverged is returned when one or more branches couldn't be synced in both directions.
+type ErrDiverged struct {
+	Conflicts []Conflict
+}
+
+func (e *ErrDiverged) Error() string {
+	return fmt.Sprintf("%v branch(es) or tag(s) diverged, see %v", len(e.Conflicts), cConflictsFile)
+}
+
+// syncBoth syncs the selected branches in both the directions. Whichever side is
+// behind is fast forwarded. Diverged histories are either merged or recorded as
+// conflicts depending upon the conflict policy of the sync.
+func syncBoth(ctx context.Context, repo *git.Repository, repoFolder string,
+	sc conf.SyncConfig, authMap map[string]*conf.AuthMethod) error {
+
+	fromAuth := repoAuth(sc.From, authMap[sc.From.AuthToUse])
+	fromRemote, err := repo.Remote(sc.From.Name)
+	if err != nil {
+		return err
+	}
+
+	branches, err := syncedBranches(repo, sc.From.Name, sc.Branches)
+	if err != nil {
+		return err
+	}
+
+	var conflicts []Conflict
+	var errRet error
+	for _, to := range sc.ToList {
+		ctx := withLogAttrs(ctx, "remote", to.Name)
+		toAuth := repoAuth(to, authMap[to.AuthToUse])
+		toRemote, err := createRemote(repo, to.Name, repoURL(to))
+		if err != nil {
+			loggerFrom(ctx).Warn("error creating remote", "err", err)
+			errRet = err
+			continue
+		}
+
+		if err := ensureTarget(ctx, to, toAuth); err != nil {
+			loggerFrom(ctx).Warn("error creating repo", "err", err)
+			errRet = err
+			continue
+		}
+
+		if err := fetch(ctx, toRemote, toAuth); err != nil &&
+			!errors.Is(err, transport.ErrEmptyRemoteRepository) {
+
+			loggerFrom(ctx).Warn("error fetching", "err", err)
+			errRet = err
+			continue
+		}
+
+		sides := &syncSides{
+			repo:       repo,
+			from:       fromRemote,
+			to:         toRemote,
+			fromAuth:   fromAuth,
+			toAuth:     toAuth,
+			onConflict: sc.OnConflict,
+		}
+		for _, bm := range branches {
+			c, err := sides.syncBranch(ctx, bm)
+			if err != nil {
+				loggerFrom(ctx).Warn("error syncing branch", "branch", bm.source, "err", err)
+				errRet = err
+				continue
+			}
+			if c != nil {
+				loggerFrom(ctx).Warn("branch diverged", "branch", bm.source,
+					"source_head", c.SourceHead, "target_head", c.TargetHead)
+				conflicts = append(conflicts, *c)
+			}
+		}
+
+		var errDiverged *ErrDiverged
+		err = pushTags(ctx, repo, toRemote, sc.From.Name, sc.Tags, toAuth)
+		if errors.As(err, &errDiverged) {
+			conflicts = append(conflicts, errDiverged.Conflicts...)
+		} else if err != nil {
+			loggerFrom(ctx).Warn("error pushing tags", "err", err)
+			errRet = err
+		}
+
+		if err := finishTarget(ctx, to, toAuth); err != nil {
+			loggerFrom(ctx).Warn("error setting up created repo", "err", err)
+			errRet = err
+		}
+	}
+
+	if err := writeConflicts(repoFolder, conflicts); err != nil {
+		return err
+	}
+	if errRet == nil && len(conflicts) > 0 {
+		errRet = &ErrDiverged{Conflicts: conflicts}
+	}
+
+	return errRet
+}
+
+// syncSides holds the two remotes between which branches are synced in both directions.
+type syncSides struct {
+	repo       *git.Repository
+	from       *git.Remote
+	to         *git.Remote
+	fromAuth   *conf.AuthMethod
+	toAuth     *conf.AuthMethod
+	onConflict string
+}
+
+// syncBranch syncs one branch between the two sides. It returns a non nil
+// Conflict if the histories diverged and couldn't be merged.
+func (ss *syncSides) syncBranch(ctx context.Context, bm branchMapping) (*Conflict, error) {
+	srcRef := plumbing.NewRemoteReferenceName(ss.from.Config().Name, bm.source)
+	dstRef := plumbing.NewRemoteReferenceName(ss.to.Config().Name, bm.target)
+
+	src, err := refCommit(ss.repo, srcRef)
+	if err != nil {
+		return nil, err
+	}
+	dst, err := refCommit(ss.repo, dstRef)
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, ss.pushTo(ctx, srcRef, dstRef, bm.target, src.Hash)
+	} else if err != nil {
+		return nil, err
+	}
+
+	if src.Hash == dst.Hash {
+		return nil, nil
+	}
+
+	if ok, err := src.IsAncestor(dst); err != nil {
+		return nil, fmt.Errorf("error comparing [%v] and [%v] :: %w", srcRef, dstRef, err)
+	} else if ok {
+		return nil, ss.pushFrom(ctx, dstRef, srcRef, bm.source, dst.Hash)
+	}
+
+	if ok, err := dst.IsAncestor(src); err != nil {
+		return nil, fmt.Errorf("error comparing [%v] and [%v] :: %w", dstRef, srcRef, err)
+	} else if ok {
+		return nil, ss.pushTo(ctx, srcRef, dstRef, bm.target, src.Hash)
+	}
+
+	conflict := &Conflict{
+		Remote:       ss.to.Config().Name,
+		SourceBranch: bm.source,
+		TargetBranch: bm.target,
+		SourceHead:   src.Hash.String(),
+		TargetHead:   dst.Hash.String(),
+		DetectedAt:   time.Now(),
+	}
+	if ss.onConflict != conf.ConflictMerge {
+		return conflict, nil
+	}
+
+	message := fmt.Sprintf("Merge %v/%v into %v/%v\n", ss.to.Config().Name, bm.target,
+		ss.from.Config().Name, bm.source)
+	merged, err := mergeCommits(ss.repo, src, dst, message)
+	var errConflict *ErrMergeConflict
+	if errors.As(err, &errConflict) {
+		conflict.Paths = errConflict.Paths
+		return conflict, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	loggerFrom(ctx).Info("merged diverged branch", "branch", bm.source, "merge", merged)
+	if err := setRef(ss.repo, srcRef, merged); err != nil {
+		return nil, err
+	}
+	if err := ss.pushFrom(ctx, srcRef, srcRef, bm.source, merged); err != nil {
+		return nil, err
+	}
+
+	return nil, ss.pushTo(ctx, srcRef, dstRef, bm.target, merged)
+}
+
+// pushTo pushes the local reference to the branch of the `to` remote
+// and updates the remote tracking reference `tracking` on success.
+func (ss *syncSides) pushTo(ctx context.Context, local, tracking plumbing.ReferenceName,
+	branch string, hash plumbing.Hash) error {
+
+	if err := push(ctx, ss.to, []config.RefSpec{refSpec(local, branch)}, ss.toAuth); err != nil {
+		return err
+	}
+
+	return setRef(ss.repo, tracking, hash)
+}
+
+// pushFrom pushes the local reference to the branch of the `from` remote
+// and updates the remote tracking reference `tracking` on success.
+func (ss *syncSides) pushFrom(ctx context.Context, local, tracking plumbing.ReferenceName,
+	branch string, hash plumbing.Hash) error {
+
+	if err := push(ctx, ss.from, []config.RefSpec{refSpec(local, branch)}, ss.fromAuth); err != nil {
+		return err
+	}
+
+	return setRef(ss.repo, tracking, hash)
+}
+
+func refCommit(repo *git.Repository, name plumbing.ReferenceName) (*object.Commit, error) {
+	ref, err := repo.Reference(name, true)
+	if err != nil {
+		return nil, fmt.Errorf("error finding reference [%v] :: %w", name, err)
+	}
+
+	c, err := repo.CommitObject(ref.Hash())
+	if err != nil {
+		return nil, fmt.Errorf("error finding commit [%v] :: %w", ref.Hash(), err)
+	}
+
+	return c, nil
+}
+
+func setRef(repo *git.Repository, name plumbing.ReferenceName, hash plumbing.Hash) error {
+	if err := repo.Storer.SetReference(plumbing.NewHashReference(name, hash)); err != nil {
+		return fmt.Errorf("error updating reference [%v] :: %w", name, err)
+	}
+
+	return nil
+}
+
+// conflictsFilePath returns the path of the file that records conflicts of a sync.
+// It is kept inside the .git folder, similar to how git stores MERGE_HEAD.
+func conflictsFilePath(repoFolder string) string {
+	return filepath.Join(repoFolder, git.GitDirName, cConflictsFile)
+}
+
+// writeConflicts records the conflicts of the last sync, or removes
+// the record if there were no conflicts.
+func writeConflicts(repoFolder string, conflicts []Conflict) error {
+	filePath := conflictsFilePath(repoFolder)
+	if len(conflicts) == 0 {
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("error removing conflicts file :: %w", err)
+		}
+		return nil
+	}
+
+	data, err := json.MarshalIndent(conflicts, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling conflicts :: %w", err)
+	}
+	if err := os.WriteFile(filePath, data, conf.SecureFilePerm()); err != nil {
+		return fmt.Errorf("error writing conflicts file :: %w", err)
+	}
+
+	return nil
+}
+
+// ReadConflicts returns the conflicts recorded during the last sync of `syncName`.
+func ReadConflicts(syncName string) ([]Conflict, error) {
+	data, err := os.ReadFile(conflictsFilePath(conf.GetSyncTarget(syncName)))
+	if os.IsNotExist(err) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading conflicts file :: %w", err)
+	}
+
+	var conflicts []Conflict
+	if err := json.Unmarshal(data, &conflicts); err != nil {
+		return nil, fmt.Errorf("error unmarshalling conflicts file :: %w", err)
+	}
+
+	return conflicts, nil
+}
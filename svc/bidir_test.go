@@ -0,0 +1,161 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// setupSide creates a repo that can be pushed to. It keeps the `dev`
+// branch checked out so that the `master` branch can be updated by a push.
+func setupSide(t *testing.T) (string, *git.Repository) {
+	dir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(100000)))
+	createTestDir(t, dir)
+	repo, err := setupGitRepo(dir)
+	if err != nil {
+		t.Fatalf("error setting up git repo :: %v", err)
+	}
+	checkout(t, repo, "dev", true)
+	return dir, repo
+}
+
+func checkout(t *testing.T, repo *git.Repository, branch string, create bool) {
+	wt, err := repo.Worktree()
+	if err != nil {
+		t.Fatalf("error in getting the work tree :: %v", err)
+	}
+	if err := wt.Checkout(&git.CheckoutOptions{
+		Branch: plumbing.NewBranchReferenceName(branch),
+		Create: create,
+	}); err != nil {
+		t.Fatalf("error checking out %v :: %v", branch, err)
+	}
+}
+
+// commitOnMaster writes a file and commits it on the master branch of the repo.
+func commitOnMaster(t *testing.T, dir string, repo *git.Repository, file, data string) {
+	checkout(t, repo, "master", false)
+	if err := createFile(filepath.Join(dir, file), data); err != nil {
+		t.Fatalf("error creating %v :: %v", file, err)
+	}
+	if err := commit(repo, "Update "+file); err != nil {
+		t.Fatalf("error committing %v :: %v", file, err)
+	}
+	checkout(t, repo, "dev", false)
+}
+
+func masterHead(t *testing.T, repo *git.Repository) plumbing.Hash {
+	ref, err := repo.Reference(plumbing.NewBranchReferenceName("master"), true)
+	if err != nil {
+		t.Fatalf("error finding master :: %v", err)
+	}
+	return ref.Hash()
+}
+
+func TestSyncBoth(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+
+	// the target starts with the same history as the source.
+	toDir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(100000)))
+	defer deleteTestDir(t, toDir)
+	toRepo, err := git.PlainClone(toDir, false, &git.CloneOptions{
+		URL:           fmt.Sprintf("file://%v", fromDir),
+		ReferenceName: plumbing.NewBranchReferenceName("master"),
+	})
+	if err != nil {
+		t.Fatalf("error cloning target :: %v", err)
+	}
+	checkout(t, toRepo, "dev", true)
+
+	repoDir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(100000)))
+	defer deleteTestDir(t, repoDir)
+	sc := conf.SyncConfig{
+		Name: "both",
+		From: conf.Repo{Name: "overleaf", URLToRepo: fmt.Sprintf("file://%v", fromDir)},
+		ToList: []conf.Repo{
+			{Name: "github", URLToRepo: fmt.Sprintf("file://%v", toDir)},
+		},
+		Branches:  conf.BranchPolicy{{Pattern: "master"}},
+		Direction: conf.DirectionBoth,
+	}
+	syncOnce := func() error {
+		repo, err := openRepo(context.Background(), sc.From, nil, repoDir)
+		if err != nil {
+			t.Fatalf("error opening repo :: %v", err)
+		}
+		remote, err := repo.Remote(sc.From.Name)
+		if err != nil {
+			t.Fatalf("error finding remote :: %v", err)
+		}
+		if err := fetch(context.Background(), remote, nil); err != nil {
+			t.Fatalf("error fetching :: %v", err)
+		}
+		return syncBoth(context.Background(), repo, repoDir, sc, nil)
+	}
+
+	// target is behind
+	commitOnMaster(t, fromDir, fromRepo, "main.tex", "overleaf\n")
+	if err := syncOnce(); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	if masterHead(t, toRepo) != masterHead(t, fromRepo) {
+		t.Fatal("target wasn't fast forwarded")
+	}
+
+	// source is behind
+	commitOnMaster(t, toDir, toRepo, "main.go", "package main\n")
+	if err := syncOnce(); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	if masterHead(t, toRepo) != masterHead(t, fromRepo) {
+		t.Fatal("source wasn't fast forwarded")
+	}
+
+	// diverged, stop on conflict
+	commitOnMaster(t, fromDir, fromRepo, "main.tex", "overleaf\nchapter 1\n")
+	commitOnMaster(t, toDir, toRepo, "main.go", "package main\n\nfunc main() {}\n")
+	fromHead, toHead := masterHead(t, fromRepo), masterHead(t, toRepo)
+	var errDiverged *ErrDiverged
+	if err := syncOnce(); !errors.As(err, &errDiverged) {
+		t.Fatalf("expected diverged error, got :: %v", err)
+	}
+	if len(errDiverged.Conflicts) != 1 || errDiverged.Conflicts[0].SourceHead != fromHead.String() ||
+		errDiverged.Conflicts[0].TargetHead != toHead.String() {
+		t.Fatalf("unexpected conflicts: %+v", errDiverged.Conflicts)
+	}
+	if _, err := os.Stat(conflictsFilePath(repoDir)); err != nil {
+		t.Fatalf("conflicts weren't recorded :: %v", err)
+	}
+	if masterHead(t, fromRepo) != fromHead || masterHead(t, toRepo) != toHead {
+		t.Fatal("diverged branches were pushed")
+	}
+
+	// diverged, merge on conflict
+	sc.OnConflict = conf.ConflictMerge
+	if err := syncOnce(); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	if masterHead(t, toRepo) != masterHead(t, fromRepo) {
+		t.Fatal("merge wasn't pushed to both sides")
+	}
+	merged, err := fromRepo.CommitObject(masterHead(t, fromRepo))
+	if err != nil {
+		t.Fatalf("error finding merge commit :: %v", err)
+	}
+	if merged.NumParents() != 2 {
+		t.Fatalf("expected a merge commit, got %v parents", merged.NumParents())
+	}
+	if _, err := os.Stat(conflictsFilePath(repoDir)); !os.IsNotExist(err) {
+		t.Fatalf("conflicts weren't cleared :: %v", err)
+	}
+}
@@ -0,0 +1,142 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// cAPIBaseURL is the base URL of the control API, the host is ignored as requests go over the socket.
+const cAPIBaseURL = "http://giggle"
+
+// Client talks to the control API of a running giggle over its Unix socket.
+type Client struct {
+	hc *http.Client
+}
+
+// NewClient returns a client for the control API served on the Unix socket at the path.
+func NewClient(socketPath string) *Client {
+	dialer := &net.Dialer{}
+	return &Client{hc: &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return dialer.DialContext(ctx, "unix", socketPath)
+		},
+	}}}
+}
+
+// Status returns the state of the syncs of the running giggle.
+func (c *Client) Status(ctx context.Context) (*Status, error) {
+	var status Status
+	if err := c.do(ctx, http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+
+	return &status, nil
+}
+
+// SyncNow triggers the syncs with the given names, or all the syncs if no name is given.
+// If wait is set, it returns once the syncs are done along with their results.
+func (c *Client) SyncNow(ctx context.Context, wait bool, names ...string) ([]SyncResult, error) {
+	path := "/sync"
+	if wait {
+		path += "?" + url.Values{"wait": {"true"}}.Encode()
+	}
+
+	var results []SyncResult
+	if err := c.do(ctx, http.MethodPost, path, syncRequest{Names: names}, &results); err != nil {
+		return nil, err
+	}
+
+	return results, nil
+}
+
+// Pause pauses the syncs with the given names, or all the syncs if no name is given.
+func (c *Client) Pause(ctx context.Context, names ...string) error {
+	return c.do(ctx, http.MethodPost, "/pause", syncRequest{Names: names}, nil)
+}
+
+// Resume resumes the syncs with the given names, or all the syncs if no name is given.
+func (c *Client) Resume(ctx context.Context, names ...string) error {
+	return c.do(ctx, http.MethodPost, "/resume", syncRequest{Names: names}, nil)
+}
+
+// History returns the records of the sync runs that match the query, oldest first.
+func (c *Client) History(ctx context.Context, q HistoryQuery) ([]RunRecord, error) {
+	params := url.Values{}
+	for name, v := range map[string]string{"sync": q.Sync, "commit": q.Commit, "target": q.Target} {
+		if v != "" {
+			params.Set(name, v)
+		}
+	}
+	if !q.Since.IsZero() {
+		params.Set("since", q.Since.Format(time.RFC3339))
+	}
+	if !q.Until.IsZero() {
+		params.Set("until", q.Until.Format(time.RFC3339))
+	}
+	if q.Limit > 0 {
+		params.Set("limit", strconv.Itoa(q.Limit))
+	}
+
+	var records []RunRecord
+	if err := c.do(ctx, http.MethodGet, "/history?"+params.Encode(), nil, &records); err != nil {
+		return nil, err
+	}
+
+	return records, nil
+}
+
+// Purge deletes the local clone of the sync once the running giggle is done syncing it.
+func (c *Client) Purge(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodPost, "/purge/"+url.PathEscape(name), nil, nil)
+}
+
+// Reload makes the running giggle read the config file again.
+func (c *Client) Reload(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/reload", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method, path string, body, result interface{}) error {
+	var buf bytes.Buffer
+	if body != nil {
+		if err := json.NewEncoder(&buf).Encode(body); err != nil {
+			return fmt.Errorf("error encoding request :: %w", err)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, cAPIBaseURL+path, &buf)
+	if err != nil {
+		return fmt.Errorf("error creating request :: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.hc.Do(req)
+	if err != nil {
+		return fmt.Errorf("error connecting to giggle :: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		var errResp errorResponse
+		if err := json.NewDecoder(resp.Body).Decode(&errResp); err != nil || errResp.Error == "" {
+			return fmt.Errorf("unexpected response [%v] from giggle", resp.Status)
+		}
+		return errors.New(errResp.Error)
+	}
+
+	if result == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(result); err != nil {
+		return fmt.Errorf("error decoding response :: %w", err)
+	}
+
+	return nil
+}
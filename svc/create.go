@@ -0,0 +1,218 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// States of the `to` repos with create set, see targetRepos.
+const (
+	// repoCreated is a repo created by giggle whose default branch is yet to be set.
+	repoCreated = iota + 1
+	// repoReady is a repo that is known to exist and to have its default branch set.
+	repoReady
+)
+
+// targetRepos keeps the state of the `to` repos with create set by their URL, so that the
+// API of their host is only called until the repo is ready. It is shared by the schedulers.
+var targetRepos = newKeyedValues[int]()
+
+// keyedValues is a map that is safe for concurrent use.
+type keyedValues[V any] struct {
+	mu     sync.Mutex
+	values map[string]V
+}
+
+func newKeyedValues[V any]() *keyedValues[V] {
+	return &keyedValues[V]{values: make(map[string]V)}
+}
+
+func (kv *keyedValues[V]) get(key string) V {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.values[key]
+}
+
+func (kv *keyedValues[V]) set(key string, value V) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.values[key] = value
+}
+
+// repoCreator is implemented by the providers of the hosts whose repos can be created.
+type repoCreator interface {
+	apiProvider
+	repoExists(ctx context.Context, api *apiClient, owner, name string) (bool, error)
+	createRepo(ctx context.Context, api *apiClient, r conf.Repo, owner, name string) error
+	setDefaultBranch(ctx context.Context, api *apiClient, owner, name, branch string) error
+}
+
+// ensureTarget creates the `to` repo through the API of its host
+// if it has create set and doesn't exist yet.
+func ensureTarget(ctx context.Context, to conf.Repo, am *conf.AuthMethod) error {
+	if !to.Create || targetRepos.get(repoURL(to)) != 0 {
+		return nil
+	}
+
+	rc, ok := providerFor(to).(repoCreator)
+	if !ok {
+		return fmt.Errorf("creating %v repos is not supported", to.HostKind())
+	}
+	api, err := hostAPI(to, am)
+	if err != nil {
+		return err
+	}
+	owner, name, err := to.OwnerAndName()
+	if err != nil {
+		return err
+	}
+
+	exists, err := rc.repoExists(ctx, api, owner, name)
+	if err != nil {
+		return fmt.Errorf("error finding repo [%v] :: %w", to.Name, err)
+	}
+	if exists {
+		targetRepos.set(repoURL(to), repoReady)
+		return nil
+	}
+
+	loggerFrom(ctx).Info("creating repo", "owner", owner, "name", name)
+	if err := rc.createRepo(ctx, api, to, owner, name); err != nil {
+		return fmt.Errorf("error creating repo [%v] :: %w", to.Name, err)
+	}
+
+	targetRepos.set(repoURL(to), repoCreated)
+	return nil
+}
+
+// finishTarget sets the default branch of a `to` repo created by giggle once it
+// has been pushed to, as a branch can only be made the default once it exists.
+func finishTarget(ctx context.Context, to conf.Repo, am *conf.AuthMethod) error {
+	if targetRepos.get(repoURL(to)) != repoCreated {
+		return nil
+	}
+
+	if to.DefaultBranch != "" {
+		rc, ok := providerFor(to).(repoCreator)
+		if !ok {
+			return fmt.Errorf("creating %v repos is not supported", to.HostKind())
+		}
+		api, err := hostAPI(to, am)
+		if err != nil {
+			return err
+		}
+		owner, name, err := to.OwnerAndName()
+		if err != nil {
+			return err
+		}
+		if err := rc.setDefaultBranch(ctx, api, owner, name, to.DefaultBranch); err != nil {
+			return fmt.Errorf("error setting default branch of [%v] :: %w", to.Name, err)
+		}
+	}
+
+	targetRepos.set(repoURL(to), repoReady)
+	return nil
+}
+
+// visibility returns the visibility of the repo to be created.
+func visibility(r conf.Repo) string {
+	if r.Visibility == "" {
+		return conf.VisibilityPrivate
+	}
+
+	return r.Visibility
+}
+
+func (gp githubProvider) repoExists(ctx context.Context, api *apiClient, owner, name string) (bool, error) {
+	return api.exists(ctx, "/repos/"+url.PathEscape(owner)+"/"+url.PathEscape(name))
+}
+
+func (gp githubProvider) createRepo(ctx context.Context, api *apiClient, r conf.Repo, owner, name string) error {
+	login, err := api.login(ctx, "/user")
+	if err != nil {
+		return err
+	}
+
+	body := map[string]any{
+		"name":        name,
+		"description": r.Description,
+		"private":     visibility(r) != conf.VisibilityPublic,
+	}
+	path := "/user/repos"
+	if !strings.EqualFold(login, owner) {
+		path = "/orgs/" + url.PathEscape(owner) + "/repos"
+		body["visibility"] = visibility(r)
+	}
+
+	_, err = api.call(ctx, http.MethodPost, path, body, nil)
+	return err
+}
+
+func (gp githubProvider) setDefaultBranch(ctx context.Context, api *apiClient, owner, name, branch string) error {
+	path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+	_, err := api.call(ctx, http.MethodPatch, path, map[string]string{"default_branch": branch}, nil)
+	return err
+}
+
+func (gp gitlabProvider) repoExists(ctx context.Context, api *apiClient, owner, name string) (bool, error) {
+	return api.exists(ctx, "/projects/"+url.PathEscape(owner+"/"+name))
+}
+
+func (gp gitlabProvider) createRepo(ctx context.Context, api *apiClient, r conf.Repo, owner, name string) error {
+	var namespace struct {
+		ID int `json:"id"`
+	}
+	if _, err := api.call(ctx, http.MethodGet, "/namespaces/"+url.PathEscape(owner), nil, &namespace); err != nil {
+		return err
+	}
+
+	_, err := api.call(ctx, http.MethodPost, "/projects", map[string]any{
+		"name":         name,
+		"path":         name,
+		"namespace_id": namespace.ID,
+		"visibility":   visibility(r),
+		"description":  r.Description,
+	}, nil)
+	return err
+}
+
+func (gp gitlabProvider) setDefaultBranch(ctx context.Context, api *apiClient, owner, name, branch string) error {
+	path := "/projects/" + url.PathEscape(owner+"/"+name)
+	_, err := api.call(ctx, http.MethodPut, path, map[string]string{"default_branch": branch}, nil)
+	return err
+}
+
+func (gp giteaProvider) repoExists(ctx context.Context, api *apiClient, owner, name string) (bool, error) {
+	return api.exists(ctx, "/repos/"+url.PathEscape(owner)+"/"+url.PathEscape(name))
+}
+
+func (gp giteaProvider) createRepo(ctx context.Context, api *apiClient, r conf.Repo, owner, name string) error {
+	login, err := api.login(ctx, "/user")
+	if err != nil {
+		return err
+	}
+
+	path := "/user/repos"
+	if !strings.EqualFold(login, owner) {
+		path = "/orgs/" + url.PathEscape(owner) + "/repos"
+	}
+	_, err = api.call(ctx, http.MethodPost, path, map[string]any{
+		"name":           name,
+		"description":    r.Description,
+		"private":        visibility(r) != conf.VisibilityPublic,
+		"default_branch": r.DefaultBranch,
+	}, nil)
+	return err
+}
+
+func (gp giteaProvider) setDefaultBranch(ctx context.Context, api *apiClient, owner, name, branch string) error {
+	path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name)
+	_, err := api.call(ctx, http.MethodPatch, path, map[string]string{"default_branch": branch}, nil)
+	return err
+}
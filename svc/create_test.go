@@ -0,0 +1,146 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// fakeAPI is a fake of the REST APIs of the hosts. It responds to the requests in
+// `responses` by method and path, with 404 to the others, and records the requests.
+type fakeAPI struct {
+	mu        sync.Mutex
+	responses map[string]string
+	requests  []string
+	bodies    map[string]map[string]any
+}
+
+func (fa *fakeAPI) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	fa.mu.Lock()
+	defer fa.mu.Unlock()
+
+	key := r.Method + " " + r.URL.EscapedPath()
+	fa.requests = append(fa.requests, key)
+	var body map[string]any
+	if json.NewDecoder(r.Body).Decode(&body) == nil {
+		fa.bodies[key] = body
+	}
+
+	resp, ok := fa.responses[key]
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	_, _ = w.Write([]byte(resp))
+}
+
+func TestCreateTarget(t *testing.T) {
+	for _, tc := range []struct {
+		kind      string
+		responses map[string]string
+		create    string
+		finish    string
+		header    string
+	}{
+		{
+			kind:      conf.KindGitHub,
+			responses: map[string]string{"GET /api/v3/user": `{"login": "ada"}`},
+			create:    "POST /api/v3/orgs/lab/repos",
+			finish:    "PATCH /api/v3/repos/lab/paper",
+			header:    "Bearer s3cret",
+		},
+		{
+			kind:      conf.KindGitLab,
+			responses: map[string]string{"GET /api/v4/namespaces/lab": `{"id": 7}`},
+			create:    "POST /api/v4/projects",
+			finish:    "PUT /api/v4/projects/lab%2Fpaper",
+		},
+		{
+			kind:      conf.KindGitea,
+			responses: map[string]string{"GET /api/v1/user": `{"login": "ada"}`},
+			create:    "POST /api/v1/orgs/lab/repos",
+			finish:    "PATCH /api/v1/repos/lab/paper",
+			header:    "token s3cret",
+		},
+	} {
+		tc.responses[tc.create] = `{}`
+		tc.responses[tc.finish] = `{}`
+		fa := &fakeAPI{responses: tc.responses, bodies: make(map[string]map[string]any)}
+		var gotHeader string
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			gotHeader = r.Header.Get("Authorization")
+			fa.ServeHTTP(w, r)
+		}))
+
+		to := conf.Repo{
+			Name:          "target",
+			Kind:          tc.kind,
+			URLToRepo:     server.URL + "/lab/paper.git",
+			Create:        true,
+			Visibility:    conf.VisibilityPublic,
+			Description:   "a paper",
+			DefaultBranch: "main",
+		}
+		am := &conf.AuthMethod{TokenAuth: &githttp.TokenAuth{Token: "s3cret"}}
+		ctx := context.Background()
+		if err := ensureTarget(ctx, to, am); err != nil {
+			t.Fatalf("error creating %v repo :: %v", tc.kind, err)
+		}
+		if err := finishTarget(ctx, to, am); err != nil {
+			t.Fatalf("error finishing %v repo :: %v", tc.kind, err)
+		}
+		// the repo is ready, hence the API isn't called anymore
+		if err := ensureTarget(ctx, to, am); err != nil {
+			t.Fatalf("error ensuring %v repo :: %v", tc.kind, err)
+		}
+		server.Close()
+
+		if n := len(fa.requests); n != 4 || fa.requests[2] != tc.create || fa.requests[3] != tc.finish {
+			t.Fatalf("unexpected requests to %v: %v", tc.kind, fa.requests)
+		}
+		if body := fa.bodies[tc.create]; body["description"] != "a paper" || body["private"] == true {
+			t.Fatalf("unexpected create request to %v: %v", tc.kind, body)
+		}
+		if body := fa.bodies[tc.finish]; body["default_branch"] != "main" {
+			t.Fatalf("unexpected update request to %v: %v", tc.kind, body)
+		}
+		if tc.header != "" && gotHeader != tc.header {
+			t.Fatalf("unexpected auth header for %v: %v", tc.kind, gotHeader)
+		}
+	}
+}
+
+func TestCreateTargetExisting(t *testing.T) {
+	fa := &fakeAPI{
+		responses: map[string]string{"GET /api/v1/repos/ada/paper": `{}`},
+		bodies:    make(map[string]map[string]any),
+	}
+	server := httptest.NewServer(fa)
+	defer server.Close()
+
+	to := conf.Repo{Name: "target", Kind: conf.KindGitea, URLToRepo: server.URL + "/ada/paper", Create: true}
+	am := &conf.AuthMethod{BasicAuth: &githttp.BasicAuth{Username: "ada", Password: "s3cret"}}
+	if err := ensureTarget(context.Background(), to, am); err != nil {
+		t.Fatalf("error ensuring repo :: %v", err)
+	}
+	if err := finishTarget(context.Background(), to, am); err != nil {
+		t.Fatalf("error finishing repo :: %v", err)
+	}
+	if len(fa.requests) != 1 {
+		t.Fatalf("existing repo not left alone: %v", fa.requests)
+	}
+
+	// the fake API doesn't respond to the request to create the repo
+	fa.responses["GET /api/v1/user"] = `{"login": "ada"}`
+	if err := ensureTarget(context.Background(), conf.Repo{
+		Name: "other", Kind: conf.KindGitea, URLToRepo: server.URL + "/ada/other", Create: true,
+	}, am); err == nil {
+		t.Fatal("expected error when the API fails to create the repo")
+	}
+}
@@ -0,0 +1,57 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/godbus/dbus/v5"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// freedesktop notification service, see https://specifications.freedesktop.org/notification-spec/
+const (
+	cNotifyDest   = "org.freedesktop.Notifications"
+	cNotifyPath   = "/org/freedesktop/Notifications"
+	cNotifyMethod = cNotifyDest + ".Notify"
+
+	// urgency levels of the notification hint "urgency"
+	cUrgencyNormal   byte = 1
+	cUrgencyCritical byte = 2
+)
+
+// desktopNotifier shows the notifications on the desktop through the freedesktop
+// notification D-Bus interface, implemented by the notification daemons on Linux.
+type desktopNotifier struct {
+	messenger
+}
+
+func (dn *desktopNotifier) notify(ctx context.Context, events []Event) error {
+	// the connection to the session bus is shared and must not be closed
+	conn, err := dbus.SessionBus()
+	if err != nil {
+		return fmt.Errorf("error connecting to session bus :: %w", err)
+	}
+
+	icon, urgency := "dialog-information", cUrgencyNormal
+	for _, ev := range events {
+		if ev.Kind == conf.EventFailed {
+			icon, urgency = "dialog-error", cUrgencyCritical
+		}
+	}
+
+	// the title of a single event is not repeated in the body, unless the template does so
+	title, body := summarize(events), ""
+	if len(events) == 1 && dn.cfg.Template == "" {
+		body = events[0].Body()
+	} else if body, err = dn.message(events); err != nil {
+		return err
+	}
+	hints := map[string]dbus.Variant{"urgency": dbus.MakeVariant(urgency)}
+	call := conn.Object(cNotifyDest, cNotifyPath).CallWithContext(ctx, cNotifyMethod, 0,
+		conf.AppName(), uint32(0), icon, title, body, []string{}, hints, int32(-1))
+	if call.Err != nil {
+		return fmt.Errorf("error sending desktop notification :: %w", call.Err)
+	}
+
+	return nil
+}
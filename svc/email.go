@@ -0,0 +1,103 @@
+package svc
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// emailNotifier sends the events by email through an SMTP server.
+type emailNotifier struct {
+	messenger
+	cfg conf.EmailNotifyConfig
+}
+
+func (en *emailNotifier) notify(ctx context.Context, events []Event) error {
+	msg, err := en.message(events)
+	if err != nil {
+		return err
+	}
+
+	headers := []string{
+		"From: " + en.cfg.From,
+		"To: " + strings.Join(en.cfg.To, ", "),
+		"Subject: " + mime.QEncoding.Encode("utf-8", summarize(events)),
+		"Date: " + time.Now().Format(time.RFC1123Z),
+		"MIME-Version: 1.0",
+		"Content-Type: text/plain; charset=utf-8",
+		"Content-Transfer-Encoding: 8bit",
+	}
+	body := strings.ReplaceAll(msg, "\n", "\r\n")
+	data := strings.Join(headers, "\r\n") + "\r\n\r\n" + body + "\r\n"
+
+	if err := en.send(ctx, []byte(data)); err != nil {
+		return fmt.Errorf("error sending email through %v :: %w", en.cfg.Addr(), err)
+	}
+
+	return nil
+}
+
+func (en *emailNotifier) send(ctx context.Context, data []byte) error {
+	addr := en.cfg.Addr()
+	host, _, _ := net.SplitHostPort(addr)
+	tlsConfig := &tls.Config{ServerName: host}
+
+	var d net.Dialer
+	conn, err := d.DialContext(ctx, "tcp", addr)
+	if err != nil {
+		return err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+	if en.cfg.TLS {
+		conn = tls.Client(conn, tlsConfig)
+	}
+
+	c, err := smtp.NewClient(conn, host)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+	defer c.Close()
+
+	if ok, _ := c.Extension("STARTTLS"); ok && !en.cfg.TLS {
+		if err := c.StartTLS(tlsConfig); err != nil {
+			return err
+		}
+	}
+	// the password is only sent over TLS or to localhost, see smtp.PlainAuth
+	if en.cfg.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", en.cfg.Username, en.cfg.Password, host)); err != nil {
+			return err
+		}
+	}
+
+	if err := c.Mail(en.cfg.From); err != nil {
+		return err
+	}
+	for _, to := range en.cfg.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(data); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	return c.Quit()
+}
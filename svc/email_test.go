@@ -0,0 +1,91 @@
+package svc
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestEmailNotifier(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening :: %v", err)
+	}
+	defer ln.Close()
+
+	mails := make(chan string, 1)
+	go serveSMTP(ln, mails)
+
+	addr := ln.Addr().(*net.TCPAddr)
+	nc := conf.NotifyConfig{Email: &conf.EmailNotifyConfig{
+		Host: addr.IP.String(),
+		Port: addr.Port,
+		From: "giggle@example.com",
+		To:   []string{"team@example.com"},
+	}}
+	notifiers, err := newNotifiers(nc)
+	if err != nil {
+		t.Fatalf("error creating notifiers :: %v", err)
+	}
+
+	events := []Event{{
+		Kind:    conf.EventChanges,
+		Sync:    "paper",
+		Commits: []CommitSummary{{Hash: "2222222222", Summary: "Fix typo", Author: "Ada"}},
+	}}
+	if err := notifiers[0].notify(context.Background(), events); err != nil {
+		t.Fatalf("error sending email :: %v", err)
+	}
+
+	mail := <-mails
+	for _, want := range []string{"RCPT TO:<team@example.com>", "Subject: paper: 1 new commit(s) synced",
+		"2222222 Fix typo (Ada)"} {
+		if !strings.Contains(mail, want) {
+			t.Fatalf("mail doesn't contain %q:\n%v", want, mail)
+		}
+	}
+}
+
+// serveSMTP accepts a single mail, without any extension, and sends the conversation to mails.
+func serveSMTP(ln net.Listener, mails chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	var conversation strings.Builder
+	r := bufio.NewReader(conn)
+	reply := func(line string) { fmt.Fprintf(conn, "%v\r\n", line) }
+	reply("220 localhost ESMTP")
+	for inData := false; ; {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		conversation.WriteString(line)
+
+		switch cmd := strings.ToUpper(strings.TrimSpace(line)); {
+		case inData && cmd == ".":
+			inData = false
+			reply("250 OK")
+		case inData:
+		case strings.HasPrefix(cmd, "EHLO"), strings.HasPrefix(cmd, "HELO"):
+			reply("250 localhost")
+		case cmd == "DATA":
+			inData = true
+			reply("354 go ahead")
+		case cmd == "QUIT":
+			reply("221 bye")
+			mails <- conversation.String()
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
@@ -0,0 +1,206 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+const cAPITimeout = 30 * time.Second
+
+// apiProvider is implemented by the providers of the hosts whose REST API is used by giggle.
+type apiProvider interface {
+	// newAPIClient returns the client of the API of the host of the repo.
+	newAPIClient(r conf.Repo, token string) (*apiClient, error)
+}
+
+// hostAPI returns the client of the API of the host of the repo. The token of the API
+// is the token of the auth of the repo, or its password as tokens often stand in for it.
+func hostAPI(r conf.Repo, am *conf.AuthMethod) (*apiClient, error) {
+	ap, ok := providerFor(r).(apiProvider)
+	if !ok {
+		return nil, fmt.Errorf("%v hosts have no supported API", r.HostKind())
+	}
+
+	var token string
+	switch {
+	case am != nil && am.TokenAuth != nil:
+		token = am.TokenAuth.Token
+	case am != nil && am.BasicAuth != nil:
+		token = am.BasicAuth.Password
+	default:
+		return nil, fmt.Errorf("no token for the API of the host of [%v]", r.Name)
+	}
+
+	return ap.newAPIClient(r, token)
+}
+
+// apiBase returns the scheme and the host of the web server of the repo, e.g.
+// https://gitlab.example.com for both its https and its ssh URLs.
+func apiBase(r conf.Repo) (string, error) {
+	rawURL := repoURL(r)
+	if strings.HasPrefix(rawURL, "http://") || strings.HasPrefix(rawURL, "https://") {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid url [%v] :: %w", rawURL, err)
+		}
+		return u.Scheme + "://" + u.Host, nil
+	}
+
+	ep, err := transport.NewEndpoint(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid url [%v] :: %w", rawURL, err)
+	}
+	return "https://" + ep.Host, nil
+}
+
+// apiClient calls the REST API of a host with JSON bodies.
+type apiClient struct {
+	base   string
+	header http.Header
+	hc     *http.Client
+}
+
+func newAPIClient(base string, header http.Header) *apiClient {
+	header.Set("Content-Type", "application/json")
+	header.Set("Accept", "application/json")
+	return &apiClient{base: base, header: header, hc: &http.Client{}}
+}
+
+// errAPIStatus is returned for the responses of the API that aren't successful.
+type errAPIStatus struct {
+	Status int
+	Body   string
+}
+
+func (e *errAPIStatus) Error() string {
+	return fmt.Sprintf("unexpected status %v :: %v", e.Status, e.Body)
+}
+
+// call sends the request with `in` as the JSON body if not nil, and decodes the
+// JSON body of the response into `out` if not nil. It returns the status code
+// of the response, along with an error if the status is not successful.
+func (ac *apiClient) call(ctx context.Context, method, path string, in, out any) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, cAPITimeout)
+	defer cancel()
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return 0, fmt.Errorf("error encoding request :: %w", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, ac.base+path, body)
+	if err != nil {
+		return 0, fmt.Errorf("error creating request :: %w", err)
+	}
+	req.Header = ac.header.Clone()
+
+	resp, err := ac.hc.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("error calling [%v %v] :: %w", method, path, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return resp.StatusCode, fmt.Errorf("error calling [%v %v] :: %w", method, path,
+			&errAPIStatus{Status: resp.StatusCode, Body: strings.TrimSpace(string(data))})
+	}
+	if out != nil {
+		if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+			return resp.StatusCode, fmt.Errorf("error decoding response of [%v %v] :: %w", method, path, err)
+		}
+	}
+
+	return resp.StatusCode, nil
+}
+
+// exists returns whether the resource at the path exists.
+func (ac *apiClient) exists(ctx context.Context, path string) (bool, error) {
+	status, err := ac.call(ctx, http.MethodGet, path, nil, nil)
+	if status == http.StatusNotFound {
+		return false, nil
+	}
+
+	return err == nil, err
+}
+
+// login returns the login of the user of the token, at the path of the API of the host.
+func (ac *apiClient) login(ctx context.Context, path string) (string, error) {
+	var user struct {
+		Login string `json:"login"`
+	}
+	if _, err := ac.call(ctx, http.MethodGet, path, nil, &user); err != nil {
+		return "", err
+	}
+
+	return user.Login, nil
+}
+
+// githubProvider creates repos and opens pull requests through the REST API
+// of GitHub, or of GitHub Enterprise.
+type githubProvider struct {
+	hostProvider
+}
+
+func (gp githubProvider) newAPIClient(r conf.Repo, token string) (*apiClient, error) {
+	base, err := apiBase(r)
+	if err != nil {
+		return nil, err
+	}
+	if base == "https://github.com" {
+		base = "https://api.github.com"
+	} else {
+		base += "/api/v3"
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "Bearer "+token)
+	return newAPIClient(base, header), nil
+}
+
+// gitlabProvider creates projects and opens merge requests through the REST API of GitLab, in the
+// namespace of the owner, which can be the user or a group, including the nested groups.
+type gitlabProvider struct {
+	hostProvider
+}
+
+func (gp gitlabProvider) newAPIClient(r conf.Repo, token string) (*apiClient, error) {
+	base, err := apiBase(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set("PRIVATE-TOKEN", token)
+	return newAPIClient(base+"/api/v4", header), nil
+}
+
+// giteaProvider creates repos and opens pull requests through the REST API of Gitea, and of Forgejo.
+type giteaProvider struct {
+	hostProvider
+}
+
+func (gp giteaProvider) newAPIClient(r conf.Repo, token string) (*apiClient, error) {
+	base, err := apiBase(r)
+	if err != nil {
+		return nil, err
+	}
+
+	header := http.Header{}
+	header.Set("Authorization", "token "+token)
+	return newAPIClient(base+"/api/v1", header), nil
+}
@@ -0,0 +1,117 @@
+package svc
+
+import (
+	"context"
+	"net/url"
+	"strings"
+	"sync"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+type limiterKey struct{}
+
+// folderLocks ensures that a repo folder is only used by one sync at a time.
+// It is shared across all the schedulers as it protects the state on disk.
+var folderLocks = &keyedLock{locks: make(map[string]chan struct{})}
+
+// limiter bounds the number of syncs running concurrently, and the
+// number of fetches and pushes running concurrently against each host.
+type limiter struct {
+	syncs   chan struct{}
+	perHost int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+func newLimiter(cc conf.ConcurrencyConfig) *limiter {
+	return &limiter{
+		syncs:   make(chan struct{}, cc.SyncLimit()),
+		perHost: cc.HostLimit(),
+		hosts:   make(map[string]chan struct{}),
+	}
+}
+
+// acquireSync blocks until a sync is allowed to run. The returned function
+// must be called to release the slot once the sync is done.
+func (l *limiter) acquireSync(ctx context.Context) (func(), error) {
+	return acquire(ctx, l.syncs)
+}
+
+// acquireHost blocks until an operation is allowed to run against the host of the URL.
+func (l *limiter) acquireHost(ctx context.Context, rawURL string) (func(), error) {
+	host := remoteHost(rawURL)
+
+	l.mu.Lock()
+	sem, ok := l.hosts[host]
+	if !ok {
+		sem = make(chan struct{}, l.perHost)
+		l.hosts[host] = sem
+	}
+	l.mu.Unlock()
+
+	return acquire(ctx, sem)
+}
+
+// withLimiter returns a context that carries the limiter to the remote operations.
+func withLimiter(ctx context.Context, l *limiter) context.Context {
+	return context.WithValue(ctx, limiterKey{}, l)
+}
+
+// acquireHost acquires a slot for the host of the URL from the limiter
+// carried by the context. It doesn't block if there is no limiter.
+func acquireHost(ctx context.Context, rawURL string) (func(), error) {
+	l, ok := ctx.Value(limiterKey{}).(*limiter)
+	if !ok {
+		return func() {}, nil
+	}
+
+	return l.acquireHost(ctx, rawURL)
+}
+
+func acquire(ctx context.Context, sem chan struct{}) (func(), error) {
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// keyedLock is a set of mutexes identified by a key that can be
+// acquired with a context, and therefore can be abandoned.
+type keyedLock struct {
+	mu    sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func (kl *keyedLock) lock(ctx context.Context, key string) (func(), error) {
+	kl.mu.Lock()
+	sem, ok := kl.locks[key]
+	if !ok {
+		sem = make(chan struct{}, 1)
+		kl.locks[key] = sem
+	}
+	kl.mu.Unlock()
+
+	return acquire(ctx, sem)
+}
+
+// remoteHost returns the host of a git URL. It supports the scp like
+// syntax of ssh URLs, e.g. git@github.com:user/repo.git as well.
+func remoteHost(rawURL string) string {
+	if u, err := url.Parse(rawURL); err == nil && u.Host != "" {
+		return u.Hostname()
+	}
+
+	host := rawURL
+	if i := strings.Index(host, "@"); i != -1 {
+		host = host[i+1:]
+	}
+	if i := strings.Index(host, ":"); i != -1 {
+		host = host[:i]
+	}
+
+	return host
+}
@@ -0,0 +1,69 @@
+package svc
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestRemoteHost(t *testing.T) {
+	tests := map[string]string{
+		"https://git.overleaf.com/abcdef":    "git.overleaf.com",
+		"https://user@github.com:443/u/repo": "github.com",
+		"ssh://git@github.com/u/repo.git":    "github.com",
+		"git@github.com:u/repo.git":          "github.com",
+	}
+	for rawURL, expected := range tests {
+		if host := remoteHost(rawURL); host != expected {
+			t.Fatalf("unexpected host for %v: %v", rawURL, host)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	l := newLimiter(conf.ConcurrencyConfig{MaxSyncs: 1, MaxPerHost: 1})
+	ctx := withLimiter(context.Background(), l)
+
+	release, err := acquireHost(ctx, "https://github.com/u/a")
+	if err != nil {
+		t.Fatalf("error acquiring host :: %v", err)
+	}
+
+	// a different host is not blocked
+	releaseOther, err := acquireHost(ctx, "https://git.overleaf.com/a")
+	if err != nil {
+		t.Fatalf("error acquiring other host :: %v", err)
+	}
+	releaseOther()
+
+	// the same host is blocked until released
+	timeoutCtx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	if _, err := acquireHost(timeoutCtx, "https://github.com/u/b"); err == nil {
+		t.Fatal("expected host limit to block")
+	}
+	release()
+	release, err = acquireHost(ctx, "https://github.com/u/b")
+	if err != nil {
+		t.Fatalf("error acquiring host after release :: %v", err)
+	}
+	release()
+
+	// without a limiter in the context, nothing is blocked
+	for i := 0; i < 3; i++ {
+		if _, err := acquireHost(context.Background(), "https://github.com/u/a"); err != nil {
+			t.Fatalf("error acquiring host without limiter :: %v", err)
+		}
+	}
+
+	releaseSync, err := l.acquireSync(ctx)
+	if err != nil {
+		t.Fatalf("error acquiring sync :: %v", err)
+	}
+	if _, err := l.acquireSync(timeoutCtx); err == nil {
+		t.Fatal("expected sync limit to block")
+	}
+	releaseSync()
+}
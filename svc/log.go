@@ -0,0 +1,36 @@
+package svc
+
+import (
+	"context"
+	"log/slog"
+	"time"
+)
+
+type loggerKey struct{}
+
+// withLogAttrs returns a context that carries a logger with the attributes in addition to those
+// of the logger carried by ctx, so that every line logged for a sync has the sync, the phase, etc.
+func withLogAttrs(ctx context.Context, args ...any) context.Context {
+	return context.WithValue(ctx, loggerKey{}, loggerFrom(ctx).With(args...))
+}
+
+// loggerFrom returns the logger carried by the context, or the default logger.
+func loggerFrom(ctx context.Context) *slog.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*slog.Logger); ok {
+		return l
+	}
+
+	return slog.Default()
+}
+
+// startPhase returns a context for the phase of the sync and a function to call when
+// the phase is done, which logs the duration of the phase and records it in the metrics.
+func startPhase(ctx context.Context, name, phase string) (context.Context, func()) {
+	ctx = withLogAttrs(ctx, "phase", phase)
+	start := time.Now()
+	return ctx, func() {
+		d := time.Since(start)
+		metrics.phaseDuration.WithLabelValues(name, phase).Observe(d.Seconds())
+		loggerFrom(ctx).Debug("phase done", "duration", d)
+	}
+}
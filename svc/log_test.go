@@ -0,0 +1,47 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"strings"
+	"testing"
+)
+
+func TestLogAttrs(t *testing.T) {
+	var buf bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug})))
+
+	store := testStatusStore(t)
+	err := store.run(context.Background(), "paper", TriggerManual, func(ctx context.Context) error {
+		ctx, done := startPhase(withLogAttrs(ctx, "remote", "github"), "paper", phasePush)
+		done()
+		loggerFrom(ctx).Info("pushed")
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("error running sync :: %v", err)
+	}
+
+	var lines []map[string]any
+	for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+		var attrs map[string]any
+		if err := json.Unmarshal([]byte(line), &attrs); err != nil {
+			t.Fatalf("error parsing log line %q :: %v", line, err)
+		}
+		lines = append(lines, attrs)
+	}
+	if len(lines) != 2 {
+		t.Fatalf("unexpected log lines:\n%v", buf.String())
+	}
+	for _, attrs := range lines {
+		if attrs["sync"] != "paper" || attrs["remote"] != "github" || attrs["phase"] != phasePush {
+			t.Fatalf("log line missing attributes: %v", attrs)
+		}
+	}
+	if _, ok := lines[0]["duration"]; !ok {
+		t.Fatalf("phase done line missing duration: %v", lines[0])
+	}
+}
@@ -0,0 +1,420 @@
+package svc
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/go-git/go-git/v5/utils/diff"
+	"github.com/sergi/go-diff/diffmatchpatch"
+)
+
+const (
+	cMergeAuthorName  = "giggle"
+	cMergeAuthorEmail = "giggle@localhost"
+	cBinarySniffLen   = 8000
+)
+
+// ErrMergeConflict is returned when a three way merge cannot be completed automatically.
+type ErrMergeConflict struct {
+	Paths []string
+}
+
+func (e *ErrMergeConflict) Error() string {
+	return fmt.Sprintf("merge conflict in %v", e.Paths)
+}
+
+// treeFile is a non directory entry of a tree, identified by its full path.
+type treeFile struct {
+	mode filemode.FileMode
+	hash plumbing.Hash
+}
+
+// treeNode is used to build nested trees from a flat list of files.
+type treeNode struct {
+	files map[string]treeFile
+	dirs  map[string]*treeNode
+}
+
+// hunk is a replacement of base lines [start, end) by lines.
+type hunk struct {
+	start int
+	end   int
+	lines []string
+}
+
+// mergeCommits performs a three way merge of commits `ours` and `theirs` in the repo,
+// and returns the hash of the resulting merge commit. Files changed only on one side
+// are taken from that side, text files changed on both sides are merged line by line.
+// An *ErrMergeConflict is returned if the same lines are changed on both the sides.
+func mergeCommits(repo *git.Repository, ours, theirs *object.Commit, message string) (
+	plumbing.Hash, error) {
+
+	bases, err := ours.MergeBase(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error finding merge base :: %w", err)
+	}
+
+	baseFiles := make(map[string]treeFile)
+	if len(bases) > 0 {
+		if baseFiles, err = commitFiles(bases[0]); err != nil {
+			return plumbing.ZeroHash, err
+		}
+	}
+	ourFiles, err := commitFiles(ours)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+	theirFiles, err := commitFiles(theirs)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	paths := make(map[string]struct{})
+	for _, files := range []map[string]treeFile{baseFiles, ourFiles, theirFiles} {
+		for p := range files {
+			paths[p] = struct{}{}
+		}
+	}
+
+	merged := make(map[string]treeFile)
+	var conflicts []string
+	for p := range paths {
+		base, inBase := baseFiles[p]
+		our, inOurs := ourFiles[p]
+		their, inTheirs := theirFiles[p]
+
+		var result treeFile
+		var keep bool
+		switch {
+		case inOurs == inTheirs && our == their:
+			result, keep = our, inOurs
+		case inBase == inTheirs && base == their:
+			result, keep = our, inOurs
+		case inBase == inOurs && base == our:
+			result, keep = their, inTheirs
+		case inOurs && inTheirs && our.mode == their.mode && our.mode != filemode.Submodule:
+			hash, ok, err := mergeBlobs(repo, base.hash, our.hash, their.hash, inBase)
+			if err != nil {
+				return plumbing.ZeroHash, err
+			}
+			if !ok {
+				conflicts = append(conflicts, p)
+				continue
+			}
+			result, keep = treeFile{mode: our.mode, hash: hash}, true
+		default:
+			conflicts = append(conflicts, p)
+			continue
+		}
+
+		if keep {
+			merged[p] = result
+		}
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		return plumbing.ZeroHash, &ErrMergeConflict{Paths: conflicts}
+	}
+
+	treeHash, err := writeTree(repo.Storer, merged)
+	if err != nil {
+		return plumbing.ZeroHash, err
+	}
+
+	return writeCommit(repo.Storer, treeHash, []plumbing.Hash{ours.Hash, theirs.Hash}, message)
+}
+
+// writeCommit stores a commit of the tree by giggle and returns its hash.
+func writeCommit(s storer.EncodedObjectStorer, treeHash plumbing.Hash, parents []plumbing.Hash,
+	message string) (plumbing.Hash, error) {
+
+	sig := object.Signature{Name: cMergeAuthorName, Email: cMergeAuthorEmail, When: time.Now()}
+	c := &object.Commit{
+		Author:       sig,
+		Committer:    sig,
+		Message:      message,
+		TreeHash:     treeHash,
+		ParentHashes: parents,
+	}
+	obj := s.NewEncodedObject()
+	if err := c.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error encoding commit :: %w", err)
+	}
+	hash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error storing commit :: %w", err)
+	}
+
+	return hash, nil
+}
+
+// commitFiles returns all the non directory entries of the tree of the commit.
+func commitFiles(c *object.Commit) (map[string]treeFile, error) {
+	tree, err := c.Tree()
+	if err != nil {
+		return nil, fmt.Errorf("error getting tree of [%v] :: %w", c.Hash, err)
+	}
+
+	files := make(map[string]treeFile)
+	walker := object.NewTreeWalker(tree, true, nil)
+	defer walker.Close()
+	for {
+		name, entry, err := walker.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("error walking tree of [%v] :: %w", c.Hash, err)
+		}
+
+		if entry.Mode == filemode.Dir {
+			continue
+		}
+		files[name] = treeFile{mode: entry.Mode, hash: entry.Hash}
+	}
+
+	return files, nil
+}
+
+// mergeBlobs merges the content of the blobs line by line. The returned bool
+// is false when the blobs cannot be merged automatically.
+func mergeBlobs(repo *git.Repository, base, ours, theirs plumbing.Hash, hasBase bool) (
+	plumbing.Hash, bool, error) {
+
+	var baseData []byte
+	var err error
+	if hasBase {
+		if baseData, err = readBlob(repo, base); err != nil {
+			return plumbing.ZeroHash, false, err
+		}
+	}
+	ourData, err := readBlob(repo, ours)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+	theirData, err := readBlob(repo, theirs)
+	if err != nil {
+		return plumbing.ZeroHash, false, err
+	}
+
+	if isBinary(baseData) || isBinary(ourData) || isBinary(theirData) {
+		return plumbing.ZeroHash, false, nil
+	}
+
+	merged, ok := mergeLines(string(baseData), string(ourData), string(theirData))
+	if !ok {
+		return plumbing.ZeroHash, false, nil
+	}
+
+	obj := repo.Storer.NewEncodedObject()
+	obj.SetType(plumbing.BlobObject)
+	w, err := obj.Writer()
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("error writing merged blob :: %w", err)
+	}
+	if _, err := io.WriteString(w, merged); err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("error writing merged blob :: %w", err)
+	}
+	if err := w.Close(); err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("error writing merged blob :: %w", err)
+	}
+	hash, err := repo.Storer.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, false, fmt.Errorf("error storing merged blob :: %w", err)
+	}
+
+	return hash, true, nil
+}
+
+func readBlob(repo *git.Repository, hash plumbing.Hash) ([]byte, error) {
+	blob, err := repo.BlobObject(hash)
+	if err != nil {
+		return nil, fmt.Errorf("error finding blob [%v] :: %w", hash, err)
+	}
+
+	r, err := blob.Reader()
+	if err != nil {
+		return nil, fmt.Errorf("error reading blob [%v] :: %w", hash, err)
+	}
+	defer r.Close()
+
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("error reading blob [%v] :: %w", hash, err)
+	}
+
+	return data, nil
+}
+
+func isBinary(data []byte) bool {
+	if len(data) > cBinarySniffLen {
+		data = data[:cBinarySniffLen]
+	}
+
+	return bytes.IndexByte(data, 0) != -1
+}
+
+// mergeLines performs a three way merge of text. It returns false if both
+// `ours` and `theirs` change the same or adjacent lines of `base` differently.
+func mergeLines(base, ours, theirs string) (string, bool) {
+	baseLines := splitLines(base)
+	ourHunks := diffHunks(base, ours)
+	theirHunks := diffHunks(base, theirs)
+
+	var sb strings.Builder
+	pos, i, j := 0, 0, 0
+	for i < len(ourHunks) || j < len(theirHunks) {
+		var h hunk
+		switch {
+		case i == len(ourHunks):
+			h, j = theirHunks[j], j+1
+		case j == len(theirHunks):
+			h, i = ourHunks[i], i+1
+		case overlap(ourHunks[i], theirHunks[j]):
+			if !sameHunk(ourHunks[i], theirHunks[j]) {
+				return "", false
+			}
+			h, i, j = ourHunks[i], i+1, j+1
+		case ourHunks[i].start < theirHunks[j].start:
+			h, i = ourHunks[i], i+1
+		default:
+			h, j = theirHunks[j], j+1
+		}
+
+		sb.WriteString(strings.Join(baseLines[pos:h.start], ""))
+		sb.WriteString(strings.Join(h.lines, ""))
+		pos = h.end
+	}
+	sb.WriteString(strings.Join(baseLines[pos:], ""))
+
+	return sb.String(), true
+}
+
+// diffHunks returns the changes required to turn base into other.
+func diffHunks(base, other string) []hunk {
+	var hunks []hunk
+	var cur *hunk
+	pos := 0
+	for _, d := range diff.Do(base, other) {
+		lines := splitLines(d.Text)
+		if d.Type == diffmatchpatch.DiffEqual {
+			if cur != nil {
+				hunks = append(hunks, *cur)
+				cur = nil
+			}
+			pos += len(lines)
+			continue
+		}
+
+		if cur == nil {
+			cur = &hunk{start: pos, end: pos}
+		}
+		if d.Type == diffmatchpatch.DiffDelete {
+			cur.end += len(lines)
+			pos += len(lines)
+		} else {
+			cur.lines = append(cur.lines, lines...)
+		}
+	}
+	if cur != nil {
+		hunks = append(hunks, *cur)
+	}
+
+	return hunks
+}
+
+// overlap returns true if the hunks change the same or adjacent lines.
+func overlap(a, b hunk) bool {
+	return a.start <= b.end && b.start <= a.end
+}
+
+func sameHunk(a, b hunk) bool {
+	return a.start == b.start && a.end == b.end &&
+		strings.Join(a.lines, "") == strings.Join(b.lines, "")
+}
+
+// splitLines splits the text into lines, keeping the line endings.
+func splitLines(text string) []string {
+	if text == "" {
+		return nil
+	}
+
+	lines := strings.SplitAfter(text, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// writeTree stores the nested trees for the given flat list of files
+// and returns the hash of the root tree.
+func writeTree(s storer.EncodedObjectStorer, files map[string]treeFile) (plumbing.Hash, error) {
+	root := &treeNode{}
+	for p, f := range files {
+		node := root
+		parts := strings.Split(p, "/")
+		for _, dir := range parts[:len(parts)-1] {
+			if node.dirs == nil {
+				node.dirs = make(map[string]*treeNode)
+			}
+			if node.dirs[dir] == nil {
+				node.dirs[dir] = &treeNode{}
+			}
+			node = node.dirs[dir]
+		}
+
+		if node.files == nil {
+			node.files = make(map[string]treeFile)
+		}
+		node.files[parts[len(parts)-1]] = f
+	}
+
+	return writeTreeNode(s, root)
+}
+
+func writeTreeNode(s storer.EncodedObjectStorer, node *treeNode) (plumbing.Hash, error) {
+	var entries []object.TreeEntry
+	for name, f := range node.files {
+		entries = append(entries, object.TreeEntry{Name: name, Mode: f.mode, Hash: f.hash})
+	}
+	for name, child := range node.dirs {
+		hash, err := writeTreeNode(s, child)
+		if err != nil {
+			return plumbing.ZeroHash, err
+		}
+		entries = append(entries, object.TreeEntry{Name: name, Mode: filemode.Dir, Hash: hash})
+	}
+
+	// git sorts the tree entries as if directory names have a trailing '/'
+	sortKey := func(e object.TreeEntry) string {
+		if e.Mode == filemode.Dir {
+			return e.Name + "/"
+		}
+		return e.Name
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return sortKey(entries[i]) < sortKey(entries[j])
+	})
+
+	tree := &object.Tree{Entries: entries}
+	obj := s.NewEncodedObject()
+	if err := tree.Encode(obj); err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error encoding tree :: %w", err)
+	}
+	hash, err := s.SetEncodedObject(obj)
+	if err != nil {
+		return plumbing.ZeroHash, fmt.Errorf("error storing tree :: %w", err)
+	}
+
+	return hash, nil
+}
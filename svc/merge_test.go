@@ -0,0 +1,31 @@
+package svc
+
+import (
+	"testing"
+)
+
+func TestMergeLines(t *testing.T) {
+	base := "a\nb\nc\nd\ne\n"
+	tests := []struct {
+		ours     string
+		theirs   string
+		expected string
+		ok       bool
+	}{
+		{base, base, base, true},
+		{"a\nB\nc\nd\ne\n", base, "a\nB\nc\nd\ne\n", true},
+		{base, "a\nb\nc\nd\nE\n", "a\nb\nc\nd\nE\n", true},
+		{"A\nb\nc\nd\ne\n", "a\nb\nc\nd\nE\n", "A\nb\nc\nd\nE\n", true},
+		{"a\nb\nc\nd\ne\nf\n", "z\na\nb\nc\nd\ne\n", "z\na\nb\nc\nd\ne\nf\n", true},
+		{"a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", "a\nB\nc\nd\ne\n", true},
+		{"a\nB\nc\nd\ne\n", "a\nX\nc\nd\ne\n", "", false},
+		{"a\nB\nc\nd\ne\n", "a\nb\nC\nd\ne\n", "", false},
+		{"a\nc\nd\ne\n", "a\nB\nc\nd\ne\n", "", false},
+	}
+	for _, tc := range tests {
+		merged, ok := mergeLines(base, tc.ours, tc.theirs)
+		if ok != tc.ok || merged != tc.expected {
+			t.Fatalf("unexpected merge of %q and %q: %q, %v", tc.ours, tc.theirs, merged, ok)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"net"
+	"net/http"
+	"path/filepath"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// Phases of a sync, see syncMetrics.phaseDuration.
+const (
+	phaseOpen  = "open"
+	phaseFetch = "fetch"
+	phasePush  = "push"
+)
+
+// Classes of the error of a sync run in addition to those of classifyError.
+const (
+	errClassCircuitOpen = "circuit_open"
+	errClassConflict    = "conflict"
+	errClassCanceled    = "canceled"
+)
+
+const (
+	cMetricsNamespace       = "giggle"
+	cMetricsShutdownTimeout = 5 * time.Second
+	cMetricsHeaderTimeout   = 10 * time.Second
+)
+
+// cDurationBuckets are the buckets of the duration histograms, from 100ms to about 14m.
+var cDurationBuckets = prometheus.ExponentialBuckets(0.1, 2, 14)
+
+// metrics are the metrics of the syncs, shared by all the syncs of the process.
+var metrics = newSyncMetrics()
+
+// syncMetrics are the metrics of the syncs exposed for Prometheus, labelled by sync.
+type syncMetrics struct {
+	registry *prometheus.Registry
+
+	runDuration   *prometheus.HistogramVec
+	phaseDuration *prometheus.HistogramVec
+	runs          *prometheus.CounterVec
+	fetchedBytes  *prometheus.CounterVec
+	commitsPushed *prometheus.CounterVec
+	lastSuccess   *prometheus.GaugeVec
+}
+
+func newSyncMetrics() *syncMetrics {
+	sm := &syncMetrics{
+		registry: prometheus.NewRegistry(),
+		runDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cMetricsNamespace,
+			Name:      "sync_duration_seconds",
+			Help:      "Duration of the sync runs.",
+			Buckets:   cDurationBuckets,
+		}, []string{"sync"}),
+		phaseDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: cMetricsNamespace,
+			Name:      "sync_phase_duration_seconds",
+			Help:      "Duration of the phases of the sync runs, i.e. open, fetch and push.",
+			Buckets:   cDurationBuckets,
+		}, []string{"sync", "phase"}),
+		runs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cMetricsNamespace,
+			Name:      "sync_runs_total",
+			Help:      "Sync runs by result, and by class of error for the failed runs.",
+		}, []string{"sync", "result", "error_class"}),
+		fetchedBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cMetricsNamespace,
+			Name:      "fetched_bytes_total",
+			Help:      "Bytes of the packs fetched from the `from` repo, including the clone.",
+		}, []string{"sync"}),
+		commitsPushed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: cMetricsNamespace,
+			Name:      "commits_pushed_total",
+			Help:      "New commits of the `from` repo pushed by the successful sync runs.",
+		}, []string{"sync"}),
+		lastSuccess: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: cMetricsNamespace,
+			Name:      "sync_last_success_timestamp_seconds",
+			Help:      "Unix time of the start of the last successful sync run.",
+		}, []string{"sync"}),
+	}
+
+	sm.registry.MustRegister(
+		sm.runDuration, sm.phaseDuration, sm.runs, sm.fetchedBytes, sm.commitsPushed, sm.lastSuccess,
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return sm
+}
+
+// recordRun records the outcome of a sync run.
+func (sm *syncMetrics) recordRun(rec RunRecord, err error, commits int) {
+	sm.runDuration.WithLabelValues(rec.Sync).Observe(rec.Duration.Seconds())
+	if err != nil {
+		sm.runs.WithLabelValues(rec.Sync, "failure", runErrorClass(err)).Inc()
+		return
+	}
+
+	sm.runs.WithLabelValues(rec.Sync, "success", "").Inc()
+	sm.commitsPushed.WithLabelValues(rec.Sync).Add(float64(commits))
+	sm.setLastSuccess(rec.Sync, rec.StartedAt)
+}
+
+func (sm *syncMetrics) setLastSuccess(name string, t time.Time) {
+	if !t.IsZero() {
+		sm.lastSuccess.WithLabelValues(name).Set(float64(t.UnixNano()) / 1e9)
+	}
+}
+
+// remove drops the metrics of a sync that was removed from the config.
+func (sm *syncMetrics) remove(name string) {
+	labels := prometheus.Labels{"sync": name}
+	sm.runDuration.DeletePartialMatch(labels)
+	sm.phaseDuration.DeletePartialMatch(labels)
+	sm.runs.DeletePartialMatch(labels)
+	sm.fetchedBytes.DeletePartialMatch(labels)
+	sm.commitsPushed.DeletePartialMatch(labels)
+	sm.lastSuccess.DeletePartialMatch(labels)
+}
+
+// runErrorClass returns the class of the error of a sync run.
+func runErrorClass(err error) string {
+	var errCircuit *ErrCircuitOpen
+	var errDiverged *ErrDiverged
+	var errMerge *ErrMergeConflict
+	switch {
+	case errors.As(err, &errCircuit):
+		return errClassCircuitOpen
+	case errors.As(err, &errDiverged), errors.As(err, &errMerge):
+		return errClassConflict
+	case errors.Is(err, context.Canceled), errors.Is(err, context.DeadlineExceeded):
+		return errClassCanceled
+	default:
+		return classifyError(err)
+	}
+}
+
+// packSize returns the size of the pack files of the repo, so that the bytes fetched
+// can be found from its growth regardless of the transport. It is 0 if there is no repo.
+func packSize(repoFolder string) int64 {
+	var size int64
+	_ = filepath.WalkDir(filepath.Join(repoFolder, ".git", "objects", "pack"),
+		func(_ string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return nil
+			}
+			if info, err := d.Info(); err == nil {
+				size += info.Size()
+			}
+			return nil
+		})
+
+	return size
+}
+
+// metricsServer serves the metrics on the address of the config, if any.
+type metricsServer struct {
+	cfg    conf.MetricsConfig
+	server *http.Server
+}
+
+// configure starts, restarts or stops serving the metrics as per the config.
+func (ms *metricsServer) configure(mc conf.MetricsConfig) {
+	if mc == ms.cfg {
+		return
+	}
+
+	ms.stop()
+	ms.cfg = mc
+	if mc.Listen == "" {
+		return
+	}
+
+	ln, err := net.Listen("tcp", mc.Listen)
+	if err != nil {
+		slog.Error("not serving metrics", "listen", mc.Listen, "err", err)
+		ms.cfg = conf.MetricsConfig{}
+		return
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle(mc.MetricsPath(), promhttp.HandlerFor(metrics.registry, promhttp.HandlerOpts{}))
+	ms.server = &http.Server{Handler: mux, ReadHeaderTimeout: cMetricsHeaderTimeout}
+	go func(server *http.Server) {
+		if err := server.Serve(ln); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("error serving metrics", "err", err)
+		}
+	}(ms.server)
+
+	slog.Info("serving metrics", "url", fmt.Sprintf("http://%v%v", ln.Addr(), mc.MetricsPath()))
+}
+
+func (ms *metricsServer) stop() {
+	if ms.server == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cMetricsShutdownTimeout)
+	defer cancel()
+	if err := ms.server.Shutdown(ctx); err != nil {
+		slog.Warn("error shutting down metrics server", "err", err)
+	}
+	ms.server = nil
+}
@@ -0,0 +1,114 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+func TestMetrics(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+	toDir, _ := setupSide(t)
+	defer deleteTestDir(t, toDir)
+
+	sc := conf.SyncConfig{
+		Name:   "metrics",
+		From:   conf.Repo{Name: "overleaf", URLToRepo: fmt.Sprintf("file://%v", fromDir)},
+		ToList: []conf.Repo{{Name: "github", URLToRepo: fmt.Sprintf("file://%v", toDir)}},
+	}
+	repoDir := filepath.Join(t.TempDir(), sc.Name)
+	syncMetricsRepo := func(ctx context.Context) error {
+		return syncFolder(ctx, repoDir, sc, nil)
+	}
+
+	store := testStatusStore(t)
+	ctx := context.Background()
+	if err := store.run(ctx, sc.Name, TriggerSchedule, syncMetricsRepo); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	commitOnMaster(t, fromDir, fromRepo, "a.tex", "a")
+	commitOnMaster(t, fromDir, fromRepo, "b.tex", "b")
+	if err := store.run(ctx, sc.Name, TriggerSchedule, syncMetricsRepo); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	_ = store.run(ctx, sc.Name, TriggerSchedule, func(context.Context) error {
+		return fmt.Errorf("error fetching :: %w", transport.ErrAuthenticationRequired)
+	})
+
+	if v := testutil.ToFloat64(metrics.runs.WithLabelValues(sc.Name, "success", "")); v != 2 {
+		t.Fatalf("unexpected successful runs: %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.runs.WithLabelValues(sc.Name, "failure", errClassAuth)); v != 1 {
+		t.Fatalf("unexpected failed runs: %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.commitsPushed.WithLabelValues(sc.Name)); v != 2 {
+		t.Fatalf("unexpected commits pushed: %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.fetchedBytes.WithLabelValues(sc.Name)); v <= 0 {
+		t.Fatalf("unexpected bytes fetched: %v", v)
+	}
+	if v := testutil.ToFloat64(metrics.lastSuccess.WithLabelValues(sc.Name)); v <= 0 {
+		t.Fatalf("unexpected last success: %v", v)
+	}
+
+	// a free port to serve the metrics on
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("error listening :: %v", err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	ms := &metricsServer{}
+	ms.configure(conf.MetricsConfig{Listen: addr})
+	defer ms.stop()
+
+	resp, err := http.Get(fmt.Sprintf("http://%v/metrics", addr))
+	if err != nil {
+		t.Fatalf("error getting metrics :: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	for _, want := range []string{
+		`giggle_sync_phase_duration_seconds_count{phase="fetch",sync="metrics"} 2`,
+		`giggle_sync_phase_duration_seconds_count{phase="push",sync="metrics"} 2`,
+		`giggle_sync_duration_seconds_count{sync="metrics"} 3`,
+	} {
+		if !strings.Contains(string(body), want) {
+			t.Fatalf("metrics don't contain %q:\n%s", want, body)
+		}
+	}
+
+	store.remove(sc.Name)
+	if metrics.runs.DeleteLabelValues(sc.Name, "success", "") {
+		t.Fatal("metrics of removed sync not dropped")
+	}
+}
+
+func TestRunErrorClass(t *testing.T) {
+	for _, tc := range []struct {
+		err   error
+		class string
+	}{
+		{&ErrCircuitOpen{Remote: "github"}, errClassCircuitOpen},
+		{fmt.Errorf("error syncing :: %w", &ErrDiverged{}), errClassConflict},
+		{context.Canceled, errClassCanceled},
+		{transport.ErrAuthorizationFailed, errClassAuth},
+		{errors.New("no space left"), errClassOther},
+	} {
+		if class := runErrorClass(tc.err); class != tc.class {
+			t.Fatalf("unexpected class of %v: %v", tc.err, class)
+		}
+	}
+}
@@ -0,0 +1,320 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+const cNotifyTimeout = 10 * time.Second
+
+// cDefaultTemplate renders the message of an event when the notifier has no template.
+const cDefaultTemplate = `{{.Title}}
+{{- range .Ranges}}
+{{.Branch}}: {{.}}
+{{- end}}
+{{- with .Body}}
+{{.}}
+{{- end}}`
+
+// Event is something that happened to a sync that may be worth a notification.
+// It is the data of the templates of the notifiers.
+type Event struct {
+	Kind    string          `json:"kind"`
+	Sync    string          `json:"sync"`
+	Time    time.Time       `json:"time"`
+	Error   string          `json:"error,omitempty"`
+	Ranges  []CommitRange   `json:"ranges,omitempty"`
+	Commits []CommitSummary `json:"commits,omitempty"`
+}
+
+// CommitRange is the range of new commits of a branch of the `from` repo.
+type CommitRange struct {
+	Branch string `json:"branch"`
+	From   string `json:"from"`
+	To     string `json:"to"`
+}
+
+func (cr CommitRange) String() string {
+	return fmt.Sprintf("%.7s..%.7s", cr.From, cr.To)
+}
+
+// Title returns a one line description of the event.
+func (ev Event) Title() string {
+	switch ev.Kind {
+	case conf.EventFailed:
+		return fmt.Sprintf("%v is failing", ev.Sync)
+	case conf.EventRecovered:
+		return fmt.Sprintf("%v is syncing again", ev.Sync)
+	default:
+		return fmt.Sprintf("%v: %d new commit(s) synced", ev.Sync, len(ev.Commits))
+	}
+}
+
+// Body returns the details of the event, i.e. the error or the summaries of the new commits.
+func (ev Event) Body() string {
+	if ev.Kind != conf.EventChanges {
+		return ev.Error
+	}
+
+	lines := make([]string, len(ev.Commits))
+	for i, c := range ev.Commits {
+		lines[i] = fmt.Sprintf("%.7s %v (%v)", c.Hash, c.Summary, c.Author)
+	}
+	return strings.Join(lines, "\n")
+}
+
+// key identifies the event for deduplication, the same failure repeated has the same key.
+func (ev Event) key() string {
+	return ev.Kind + "\x00" + ev.Sync + "\x00" + ev.Body()
+}
+
+// runEvents returns the events of a sync run given the number of consecutive
+// failures of the sync and the heads of its `from` repo before the run.
+func runEvents(rec RunRecord, failures int, prevHeads map[string]string) []Event {
+	ev := Event{Sync: rec.Sync, Time: rec.StartedAt.Add(rec.Duration)}
+	switch {
+	case rec.Error != "" && failures == 0:
+		ev.Kind, ev.Error = conf.EventFailed, rec.Error
+		return []Event{ev}
+	case rec.Error != "":
+		return nil
+	}
+
+	var events []Event
+	if failures > 0 {
+		ev.Kind = conf.EventRecovered
+		events = append(events, ev)
+	}
+	if len(rec.NewCommits) > 0 {
+		ev.Kind, ev.Commits = conf.EventChanges, rec.NewCommits
+		for branch, head := range rec.SourceHeads {
+			if prev := prevHeads[branch]; prev != "" && prev != head {
+				ev.Ranges = append(ev.Ranges, CommitRange{Branch: branch, From: prev, To: head})
+			}
+		}
+		sort.Slice(ev.Ranges, func(i, j int) bool { return ev.Ranges[i].Branch < ev.Ranges[j].Branch })
+		events = append(events, ev)
+	}
+
+	return events
+}
+
+// notifier delivers notifications of events to the user.
+type notifier interface {
+	// wants returns whether the kind of event is to be sent through the notifier.
+	wants(kind string) bool
+	notify(ctx context.Context, events []Event) error
+}
+
+// newNotifiers returns the notifiers configured in the config.
+func newNotifiers(nc conf.NotifyConfig) ([]notifier, error) {
+	var notifiers []notifier
+	if nc.Desktop != nil {
+		m, err := newMessenger(nc.Desktop.NotifierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error in desktop notifier :: %w", err)
+		}
+		notifiers = append(notifiers, &desktopNotifier{messenger: m})
+	}
+	for i, wc := range nc.Webhooks {
+		m, err := newMessenger(wc.NotifierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error in webhook notifier %d :: %w", i, err)
+		}
+		notifiers = append(notifiers, newWebhookNotifier(wc, m))
+	}
+	if nc.Email != nil {
+		m, err := newMessenger(nc.Email.NotifierConfig)
+		if err != nil {
+			return nil, fmt.Errorf("error in email notifier :: %w", err)
+		}
+		notifiers = append(notifiers, &emailNotifier{cfg: *nc.Email, messenger: m})
+	}
+
+	return notifiers, nil
+}
+
+// messenger renders the messages of the events and filters them, for the notifiers.
+type messenger struct {
+	cfg  conf.NotifierConfig
+	tmpl *template.Template
+}
+
+func newMessenger(nc conf.NotifierConfig) (messenger, error) {
+	text := nc.Template
+	if text == "" {
+		text = cDefaultTemplate
+	}
+
+	tmpl, err := template.New("message").Parse(text)
+	if err != nil {
+		return messenger{}, fmt.Errorf("error parsing template :: %w", err)
+	}
+
+	return messenger{cfg: nc, tmpl: tmpl}, nil
+}
+
+func (m messenger) wants(kind string) bool {
+	return m.cfg.Wants(kind)
+}
+
+// message renders the message of each event, separated by a blank line.
+func (m messenger) message(events []Event) (string, error) {
+	msgs := make([]string, len(events))
+	for i, ev := range events {
+		var sb strings.Builder
+		if err := m.tmpl.Execute(&sb, ev); err != nil {
+			return "", fmt.Errorf("error executing template :: %w", err)
+		}
+		msgs[i] = strings.TrimSpace(sb.String())
+	}
+
+	return strings.Join(msgs, "\n\n"), nil
+}
+
+// notifyHub sends the events to the configured notifiers. It sends at most one batch of
+// events every interval, and drops the events that are the same as one sent recently.
+type notifyHub struct {
+	mu        sync.Mutex
+	notifiers []notifier
+	interval  time.Duration
+	dedup     time.Duration
+	pending   []Event
+	sent      map[string]time.Time
+
+	wake chan struct{}
+	quit chan struct{}
+	done chan struct{}
+}
+
+func newNotifyHub() *notifyHub {
+	nh := &notifyHub{
+		interval: conf.NotifyConfig{}.Interval(),
+		dedup:    conf.NotifyConfig{}.Dedup(),
+		sent:     make(map[string]time.Time),
+		wake:     make(chan struct{}, 1),
+		quit:     make(chan struct{}),
+		done:     make(chan struct{}),
+	}
+	go nh.loop()
+	return nh
+}
+
+// configure replaces the notifiers as per the config. The notifiers
+// are left as they are if the config has a problem, which is logged.
+func (nh *notifyHub) configure(nc conf.NotifyConfig) {
+	notifiers, err := newNotifiers(nc)
+	if err != nil {
+		slog.Error("not updating notifiers", "err", err)
+		return
+	}
+
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+	nh.notifiers, nh.interval, nh.dedup = notifiers, nc.Interval(), nc.Dedup()
+}
+
+// publish queues the event to be sent, unless the same event was sent or queued recently.
+func (nh *notifyHub) publish(ev Event) {
+	nh.mu.Lock()
+	defer nh.mu.Unlock()
+
+	if len(nh.notifiers) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for key, at := range nh.sent {
+		if now.Sub(at) >= nh.dedup {
+			delete(nh.sent, key)
+		}
+	}
+	if _, dup := nh.sent[ev.key()]; dup {
+		slog.Info("not notifying event as it was notified recently", "sync", ev.Sync, "event", ev.Kind)
+		return
+	}
+	nh.sent[ev.key()] = now
+
+	nh.pending = append(nh.pending, ev)
+	select {
+	case nh.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (nh *notifyHub) stop() {
+	close(nh.quit)
+	<-nh.done
+}
+
+// loop sends the pending events, and then waits for the interval
+// so that the events published in the meantime are sent together.
+func (nh *notifyHub) loop() {
+	defer close(nh.done)
+
+	for {
+		select {
+		case <-nh.quit:
+			return
+		case <-nh.wake:
+		}
+
+		nh.mu.Lock()
+		events, notifiers, interval := nh.pending, nh.notifiers, nh.interval
+		nh.pending = nil
+		nh.mu.Unlock()
+
+		var wg sync.WaitGroup
+		for _, n := range notifiers {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				sendEvents(n, events)
+			}()
+		}
+		wg.Wait()
+
+		timer := time.NewTimer(interval)
+		select {
+		case <-nh.quit:
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
+	}
+}
+
+func sendEvents(n notifier, events []Event) {
+	var wanted []Event
+	for _, ev := range events {
+		if n.wants(ev.Kind) {
+			wanted = append(wanted, ev)
+		}
+	}
+	if len(wanted) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), cNotifyTimeout)
+	defer cancel()
+	if err := n.notify(ctx, wanted); err != nil {
+		slog.Warn("unable to send notification", "err", err)
+	}
+}
+
+// summarize returns a one line summary of the events.
+func summarize(events []Event) string {
+	if len(events) == 1 {
+		return events[0].Title()
+	}
+
+	return fmt.Sprintf("%v: %d sync events", conf.AppName(), len(events))
+}
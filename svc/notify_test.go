@@ -0,0 +1,137 @@
+package svc
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+type fakeNotifier struct {
+	cfg conf.NotifierConfig
+
+	mu      sync.Mutex
+	batches [][]Event
+}
+
+func (fn *fakeNotifier) wants(kind string) bool {
+	return fn.cfg.Wants(kind)
+}
+
+func (fn *fakeNotifier) notify(_ context.Context, events []Event) error {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	fn.batches = append(fn.batches, events)
+	return nil
+}
+
+func (fn *fakeNotifier) sent() [][]Event {
+	fn.mu.Lock()
+	defer fn.mu.Unlock()
+	return append([][]Event{}, fn.batches...)
+}
+
+func TestRunEvents(t *testing.T) {
+	commits := []CommitSummary{{Branch: "master", Hash: "222", Summary: "Update paper.tex"}}
+	heads := map[string]string{"master": "222"}
+	for _, tc := range []struct {
+		name     string
+		rec      RunRecord
+		failures int
+		kinds    []string
+	}{
+		{"first failure", RunRecord{Error: "auth failed"}, 0, []string{conf.EventFailed}},
+		{"still failing", RunRecord{Error: "auth failed"}, 3, nil},
+		{"recovered", RunRecord{}, 2, []string{conf.EventRecovered}},
+		{"nothing new", RunRecord{}, 0, nil},
+		{"changes", RunRecord{SourceHeads: heads, NewCommits: commits}, 0, []string{conf.EventChanges}},
+		{"recovered with changes", RunRecord{SourceHeads: heads, NewCommits: commits}, 1,
+			[]string{conf.EventRecovered, conf.EventChanges}},
+	} {
+		events := runEvents(tc.rec, tc.failures, map[string]string{"master": "111"})
+		if len(events) != len(tc.kinds) {
+			t.Fatalf("%v: unexpected events %+v", tc.name, events)
+		}
+		for i, ev := range events {
+			if ev.Kind != tc.kinds[i] {
+				t.Fatalf("%v: unexpected events %+v", tc.name, events)
+			}
+			if ev.Kind == conf.EventChanges && (len(ev.Ranges) != 1 || ev.Ranges[0].String() != "111..222") {
+				t.Fatalf("%v: unexpected commit range %+v", tc.name, ev.Ranges)
+			}
+		}
+	}
+}
+
+func TestMessenger(t *testing.T) {
+	ev := Event{
+		Kind:    conf.EventChanges,
+		Sync:    "paper",
+		Ranges:  []CommitRange{{Branch: "master", From: "1111111111", To: "2222222222"}},
+		Commits: []CommitSummary{{Hash: "2222222222", Summary: "Fix typo", Author: "Ada"}},
+	}
+
+	m, err := newMessenger(conf.NotifierConfig{})
+	if err != nil {
+		t.Fatalf("error creating messenger :: %v", err)
+	}
+	msg, err := m.message([]Event{ev})
+	want := "paper: 1 new commit(s) synced\nmaster: 1111111..2222222\n2222222 Fix typo (Ada)"
+	if err != nil || msg != want {
+		t.Fatalf("unexpected message %q :: %v", msg, err)
+	}
+
+	m, err = newMessenger(conf.NotifierConfig{Template: "{{.Sync}} {{.Kind}}{{range .Commits}} {{.Summary}}{{end}}"})
+	if err != nil {
+		t.Fatalf("error creating messenger :: %v", err)
+	}
+	msg, err = m.message([]Event{ev, {Kind: conf.EventFailed, Sync: "thesis"}})
+	if err != nil || msg != "paper changes Fix typo\n\nthesis failed" {
+		t.Fatalf("unexpected message %q :: %v", msg, err)
+	}
+}
+
+func TestNotifyHub(t *testing.T) {
+	nh := newNotifyHub()
+	defer nh.stop()
+
+	fn := &fakeNotifier{cfg: conf.NotifierConfig{Events: []string{conf.EventFailed, conf.EventRecovered}}}
+	nh.mu.Lock()
+	nh.notifiers, nh.interval, nh.dedup = []notifier{fn}, 200*time.Millisecond, time.Hour
+	nh.mu.Unlock()
+
+	failed := Event{Kind: conf.EventFailed, Sync: "paper", Error: "authentication required"}
+	nh.publish(failed)
+	waitFor(t, func() bool { return len(fn.sent()) == 1 })
+
+	// the events during the interval are sent together, except the repeated failure
+	nh.publish(failed)
+	nh.publish(Event{Kind: conf.EventFailed, Sync: "thesis", Error: "remote is down"})
+	nh.publish(Event{Kind: conf.EventRecovered, Sync: "paper"})
+	nh.publish(Event{Kind: conf.EventChanges, Sync: "paper", Commits: []CommitSummary{{Hash: "abc"}}})
+	time.Sleep(50 * time.Millisecond)
+	if len(fn.sent()) != 1 {
+		t.Fatalf("events sent before the interval: %+v", fn.sent())
+	}
+
+	waitFor(t, func() bool { return len(fn.sent()) == 2 })
+	batch := fn.sent()[1]
+	if len(batch) != 2 || batch[0].Sync != "thesis" || batch[1].Kind != conf.EventRecovered {
+		t.Fatalf("unexpected batch: %+v", batch)
+	}
+	if title := summarize(batch); title != "giggle: 2 sync events" {
+		t.Fatalf("unexpected title: %v", title)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	deadline := time.Now().Add(5 * time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for condition")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,175 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+const (
+	// cPushedRefPrefix is followed by <remote>/<branch>, the ref holds the commit that
+	// giggle last pushed to the branch of the remote, the lease of conf.PushForceWithLease.
+	cPushedRefPrefix = "refs/giggle/pushed/"
+	// cBackupRefPrefix is followed by <remote>/<branch>/<time>, the ref holds
+	// the commit of the branch of the remote that a forced push overwrote.
+	cBackupRefPrefix  = "refs/giggle/backup/"
+	cBackupTimeLayout = "20060102T150405Z"
+)
+
+// forcedPush is a diverged branch of a `to` remote that the push policy allows to overwrite.
+type forcedPush struct {
+	refSpec config.RefSpec
+	branch  string
+	// overwritten is the commit of the branch on the remote
+	overwritten plumbing.Hash
+}
+
+// pushBranches pushes the refspecs to the `to` remote as per the push policy of the repo. The
+// branches whose history diverged from the remote are overwritten if the policy allows it, once
+// their commits on the remote are saved to backup refs. Otherwise, they are not pushed and an
+// *ErrDiverged is returned with their conflicts, after the other branches are pushed.
+func pushBranches(ctx context.Context, repo *git.Repository, toRemote *git.Remote, to conf.Repo,
+	refSpecs []config.RefSpec, am *conf.AuthMethod) error {
+
+	refs, err := listRemote(ctx, toRemote, am)
+	if err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return err
+	}
+	remoteHeads := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for _, ref := range refs {
+		if ref.Type() == plumbing.HashReference {
+			remoteHeads[ref.Name()] = ref.Hash()
+		}
+	}
+
+	var fastForwards []config.RefSpec
+	var forced []forcedPush
+	var conflicts []Conflict
+	for _, rs := range refSpecs {
+		local, err := repo.Reference(plumbing.ReferenceName(rs.Src()), true)
+		if err != nil {
+			return fmt.Errorf("error finding reference [%v] :: %w", rs.Src(), err)
+		}
+
+		branch := rs.Dst("").Short()
+		remoteHead, ok := remoteHeads[rs.Dst("")]
+		switch {
+		// a commit of the remote that isn't in the repo can't be an ancestor of the local head
+		case !ok || remoteHead == local.Hash() ||
+			isAncestor(repo, remoteHead.String(), local.Hash().String()):
+			fastForwards = append(fastForwards, rs)
+		case mayOverwrite(repo, to, branch, remoteHead):
+			forced = append(forced, forcedPush{refSpec: rs, branch: branch, overwritten: remoteHead})
+		default:
+			loggerFrom(ctx).Warn("branch diverged", "branch", branch,
+				"source_head", local.Hash(), "target_head", remoteHead)
+			conflicts = append(conflicts, Conflict{
+				Remote:       to.Name,
+				SourceBranch: sourceBranch(local.Name()),
+				TargetBranch: branch,
+				SourceHead:   local.Hash().String(),
+				TargetHead:   remoteHead.String(),
+				DetectedAt:   time.Now(),
+			})
+		}
+	}
+
+	var errs []error
+	if len(fastForwards) > 0 {
+		if err := push(ctx, toRemote, fastForwards, am); err != nil {
+			return err
+		}
+		for _, rs := range fastForwards {
+			errs = append(errs, recordPushed(repo, to.Name, rs))
+		}
+	}
+
+	for _, fp := range forced {
+		if err := forcePush(ctx, repo, toRemote, to.Name, fp, am); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if len(conflicts) > 0 {
+		errs = append(errs, &ErrDiverged{Conflicts: conflicts})
+	}
+
+	return errors.Join(errs...)
+}
+
+// mayOverwrite returns whether the push policy of the `to` repo allows
+// to overwrite its branch, given the commit of the branch on the remote.
+func mayOverwrite(repo *git.Repository, to conf.Repo, branch string, remoteHead plumbing.Hash) bool {
+	switch to.PushPolicy {
+	case conf.PushForce:
+		return true
+	case conf.PushForceWithLease:
+		lease, err := repo.Reference(pushedRefName(to.Name, branch), true)
+		return err == nil && lease.Hash() == remoteHead
+	default:
+		return false
+	}
+}
+
+// forcePush saves the commit of the branch on the remote to a backup ref and
+// overwrites the branch, unless the branch has moved on the remote since.
+func forcePush(ctx context.Context, repo *git.Repository, toRemote *git.Remote, remote string,
+	fp forcedPush, am *conf.AuthMethod) error {
+
+	backup := plumbing.ReferenceName(fmt.Sprintf("%v%v/%v/%v", cBackupRefPrefix, remote, fp.branch,
+		time.Now().UTC().Format(cBackupTimeLayout)))
+	if _, err := repo.CommitObject(fp.overwritten); err == nil {
+		if err := setRef(repo, backup, fp.overwritten); err != nil {
+			return err
+		}
+	} else {
+		// the commit was never fetched, e.g. the `to` repo was rewritten by someone else
+		spec := config.RefSpec(fmt.Sprintf("+%v:%v", plumbing.NewBranchReferenceName(fp.branch), backup))
+		if err := fetch(ctx, toRemote, am, spec); err != nil {
+			return fmt.Errorf("error saving [%v] to a backup :: %w", fp.branch, err)
+		}
+	}
+
+	spec := config.RefSpec("+" + fp.refSpec.String())
+	require := config.RefSpec(fmt.Sprintf("%v:%v", fp.overwritten, fp.refSpec.Dst("")))
+	if err := push(ctx, toRemote, []config.RefSpec{spec}, am, require); err != nil {
+		return err
+	}
+
+	loggerFrom(ctx).Warn("overwrote diverged branch", "branch", fp.branch,
+		"overwritten", fp.overwritten, "backup", backup)
+	return recordPushed(repo, remote, fp.refSpec)
+}
+
+// recordPushed records the commit pushed by the refspec as the lease of the branch.
+func recordPushed(repo *git.Repository, remote string, rs config.RefSpec) error {
+	local, err := repo.Reference(plumbing.ReferenceName(rs.Src()), true)
+	if err != nil {
+		return fmt.Errorf("error finding reference [%v] :: %w", rs.Src(), err)
+	}
+
+	return setRef(repo, pushedRefName(remote, rs.Dst("").Short()), local.Hash())
+}
+
+func pushedRefName(remote, branch string) plumbing.ReferenceName {
+	return plumbing.ReferenceName(cPushedRefPrefix + remote + "/" + branch)
+}
+
+// sourceBranch returns the name of the branch of a local reference, which
+// is a remote tracking reference of the `from` remote when syncing.
+func sourceBranch(name plumbing.ReferenceName) string {
+	if name.IsRemote() {
+		_, branch, _ := strings.Cut(name.Short(), "/")
+		return branch
+	}
+
+	return name.Short()
+}
@@ -0,0 +1,116 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// rewriteMaster replaces the last commit on the master branch of the repo with a commit of the file.
+func rewriteMaster(t *testing.T, dir string, repo *git.Repository, file string) {
+	head, err := repo.CommitObject(masterHead(t, repo))
+	if err != nil {
+		t.Fatalf("error finding master commit :: %v", err)
+	}
+	if err := setRef(repo, plumbing.NewBranchReferenceName("master"), head.ParentHashes[0]); err != nil {
+		t.Fatalf("error resetting master :: %v", err)
+	}
+	commitOnMaster(t, dir, repo, file, file)
+}
+
+func TestPushPolicy(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+	toDir, toRepo := setupSide(t)
+	defer deleteTestDir(t, toDir)
+	// the target has a commit of its own, hence its master diverged from the source
+	commitOnMaster(t, fromDir, fromRepo, "source.tex", "source")
+	commitOnMaster(t, toDir, toRepo, "target.tex", "target")
+
+	repoFolder := filepath.Join(t.TempDir(), "policy")
+	sc := conf.SyncConfig{
+		Name:     "policy",
+		From:     conf.Repo{Name: "overleaf", URLToRepo: fmt.Sprintf("file://%v", fromDir)},
+		ToList:   []conf.Repo{{Name: "github", URLToRepo: fmt.Sprintf("file://%v", toDir)}},
+		Branches: conf.BranchPolicy{{Pattern: "master"}},
+	}
+	syncWith := func(policy string) error {
+		sc.ToList[0].PushPolicy = policy
+		ctx := withLimiter(context.Background(), newLimiter(conf.ConcurrencyConfig{}))
+		return syncFolder(ctx, repoFolder, sc, nil)
+	}
+
+	overwritten := masterHead(t, toRepo)
+	for _, policy := range []string{"", conf.PushForceWithLease} {
+		var errDiverged *ErrDiverged
+		if err := syncWith(policy); !errors.As(err, &errDiverged) {
+			t.Fatalf("expected diverged error with policy %q, got %v", policy, err)
+		}
+		c := errDiverged.Conflicts
+		if len(c) != 1 || c[0].Remote != "github" || c[0].SourceBranch != "master" ||
+			c[0].TargetHead != overwritten.String() {
+			t.Fatalf("unexpected conflicts with policy %q: %v", policy, c)
+		}
+		if masterHead(t, toRepo) != overwritten {
+			t.Fatalf("diverged branch overwritten with policy %q", policy)
+		}
+	}
+	if _, err := os.Stat(conflictsFilePath(repoFolder)); err != nil {
+		t.Fatalf("conflicts not recorded :: %v", err)
+	}
+
+	if err := syncWith(conf.PushForce); err != nil {
+		t.Fatalf("error force pushing :: %v", err)
+	}
+	if masterHead(t, toRepo) != masterHead(t, fromRepo) {
+		t.Fatal("diverged branch not overwritten")
+	}
+	if _, err := os.Stat(conflictsFilePath(repoFolder)); !os.IsNotExist(err) {
+		t.Fatalf("conflicts not cleared :: %v", err)
+	}
+	repo, err := git.PlainOpen(repoFolder)
+	if err != nil {
+		t.Fatalf("error opening repo :: %v", err)
+	}
+	refs, err := repo.References()
+	if err != nil {
+		t.Fatalf("error listing references :: %v", err)
+	}
+	var backups []*plumbing.Reference
+	_ = refs.ForEach(func(ref *plumbing.Reference) error {
+		if strings.HasPrefix(ref.Name().String(), cBackupRefPrefix+"github/master/") {
+			backups = append(backups, ref)
+		}
+		return nil
+	})
+	if len(backups) != 1 || backups[0].Hash() != overwritten {
+		t.Fatalf("overwritten commit not backed up: %v", backups)
+	}
+
+	// the source is rewritten, the target is still at the commit pushed last
+	rewriteMaster(t, fromDir, fromRepo, "rewritten.tex")
+	if err := syncWith(conf.PushForceWithLease); err != nil {
+		t.Fatalf("error force pushing with lease :: %v", err)
+	}
+	if masterHead(t, toRepo) != masterHead(t, fromRepo) {
+		t.Fatal("rewritten branch not pushed")
+	}
+
+	// the target moved on since, hence the lease doesn't hold
+	commitOnMaster(t, toDir, toRepo, "later.tex", "later")
+	var errDiverged *ErrDiverged
+	if err := syncWith(conf.PushForceWithLease); !errors.As(err, &errDiverged) {
+		t.Fatalf("expected diverged error, got %v", err)
+	}
+	if masterHead(t, toRepo) == masterHead(t, fromRepo) {
+		t.Fatal("branch that moved on the target overwritten")
+	}
+}
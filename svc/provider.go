@@ -0,0 +1,235 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// cOverleafBranch is the only branch of an overleaf project.
+const cOverleafBranch = "master"
+
+// provider supplies what differs between the hosts of repos, see conf.Repo.Kind.
+type provider interface {
+	// normalizeURL returns the URL to use for git given the URL in the config.
+	normalizeURL(rawURL string) string
+	// tokenUser returns the username that tokens are sent with over basic
+	// auth, as most hosts expect, or "" to send them as bearer tokens.
+	tokenUser() string
+	// webURL returns the URL of the web page of a repo given its git URL.
+	webURL(rawURL string) string
+	// defaultBranch returns the branch that the HEAD of the remote points to.
+	defaultBranch(ctx context.Context, remote *git.Remote, am *conf.AuthMethod) (string, error)
+	// singleBranch returns whether the repo can only have its default branch,
+	// in which case only the branch mapped to it is pushed to the repo.
+	singleBranch() bool
+}
+
+var providers = map[string]provider{
+	conf.KindOverleaf:  overleafProvider{hostProvider{tokenUsername: "git"}},
+	conf.KindGitHub:    githubProvider{hostProvider{tokenUsername: "x-access-token"}},
+	conf.KindGitLab:    gitlabProvider{hostProvider{tokenUsername: "oauth2"}},
+	conf.KindGitea:     giteaProvider{hostProvider{tokenUsername: conf.AppName()}},
+	conf.KindBitbucket: hostProvider{tokenUsername: "x-token-auth"},
+	conf.KindGeneric:   hostProvider{},
+	conf.KindLocal:     localProvider{},
+}
+
+// providerFor returns the provider of the host of the repo.
+func providerFor(r conf.Repo) provider {
+	if p, ok := providers[r.HostKind()]; ok {
+		return p
+	}
+
+	return providers[conf.KindGeneric]
+}
+
+// repoURL returns the URL of the repo to use for git.
+func repoURL(r conf.Repo) string {
+	return providerFor(r).normalizeURL(r.URLToRepo)
+}
+
+// WebURL returns the URL of the web page of the repo.
+func WebURL(r conf.Repo) string {
+	return providerFor(r).webURL(repoURL(r))
+}
+
+// repoAuth returns the auth method for the repo, sending
+// the token the way the host of the repo expects it.
+func repoAuth(r conf.Repo, am *conf.AuthMethod) *conf.AuthMethod {
+	user := providerFor(r).tokenUser()
+	if am == nil || am.TokenAuth == nil || am.BasicAuth != nil || user == "" {
+		return am
+	}
+
+	withBasic := *am
+	withBasic.BasicAuth = &http.BasicAuth{Username: user, Password: am.TokenAuth.Token}
+	withBasic.TokenAuth = nil
+	return &withBasic
+}
+
+// hostProvider is the provider of the hosts that differ only in the username of the tokens.
+type hostProvider struct {
+	tokenUsername string
+}
+
+func (hp hostProvider) normalizeURL(rawURL string) string {
+	return strings.TrimSuffix(strings.TrimSpace(rawURL), "/")
+}
+
+func (hp hostProvider) tokenUser() string {
+	return hp.tokenUsername
+}
+
+// webURL supports the scp like syntax of ssh URLs, e.g. git@github.com:user/repo.git.
+func (hp hostProvider) webURL(rawURL string) string {
+	if !strings.Contains(rawURL, "://") {
+		if at := strings.Index(rawURL, "@"); at != -1 {
+			rawURL = rawURL[at+1:]
+		}
+		rawURL = "https://" + strings.Replace(rawURL, ":", "/", 1)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	u.Scheme, u.User = "https", nil
+	u.Host = u.Hostname()
+	u.Path = strings.TrimSuffix(u.Path, ".git")
+
+	return u.String()
+}
+
+func (hp hostProvider) defaultBranch(ctx context.Context, remote *git.Remote,
+	am *conf.AuthMethod) (string, error) {
+
+	refs, err := listRemote(ctx, remote, am)
+	if err != nil {
+		return "", err
+	}
+
+	branch, ok := headBranch(refs)
+	if !ok {
+		return "", fmt.Errorf("no default branch found for [%v]", remote.Config().Name)
+	}
+
+	return branch, nil
+}
+
+func (hp hostProvider) singleBranch() bool {
+	return false
+}
+
+// listRemote returns the refs advertised by the remote.
+func listRemote(ctx context.Context, remote *git.Remote, am *conf.AuthMethod) ([]*plumbing.Reference, error) {
+	auth, err := am.GetAuth(remote.Config().URLs[0])
+	if err != nil {
+		return nil, fmt.Errorf("error in auth for [%v] :: %w", remote.Config().Name, err)
+	}
+
+	var refs []*plumbing.Reference
+	err = withRemote(ctx, remote.Config().URLs[0], func() error {
+		var err error
+		refs, err = remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing refs of [%v] :: %w", remote.Config().Name, err)
+	}
+
+	return refs, nil
+}
+
+// headBranch returns the branch that HEAD points to among the refs of a remote. HEAD is
+// symbolic if the remote advertises it, else the first branch at the commit of HEAD is used.
+func headBranch(refs []*plumbing.Reference) (string, bool) {
+	var head *plumbing.Reference
+	var branches []*plumbing.Reference
+	for _, ref := range refs {
+		switch {
+		case ref.Name() == plumbing.HEAD:
+			head = ref
+		case ref.Name().IsBranch():
+			branches = append(branches, ref)
+		}
+	}
+	if head == nil {
+		return "", false
+	}
+	if head.Type() == plumbing.SymbolicReference {
+		return head.Target().Short(), true
+	}
+
+	sort.Slice(branches, func(i, j int) bool { return branches[i].Name() < branches[j].Name() })
+	for _, ref := range branches {
+		if ref.Hash() == head.Hash() {
+			return ref.Name().Short(), true
+		}
+	}
+
+	return "", false
+}
+
+// overleafProvider is the provider of overleaf projects, which only have a master branch.
+// The URL of the web page of a project is accepted in place of its git URL.
+type overleafProvider struct {
+	hostProvider
+}
+
+func (op overleafProvider) normalizeURL(rawURL string) string {
+	if id, ok := conf.OverleafProjectID(rawURL); ok {
+		return "https://git.overleaf.com/" + id
+	}
+
+	return rawURL
+}
+
+func (op overleafProvider) webURL(rawURL string) string {
+	if id, ok := conf.OverleafProjectID(rawURL); ok {
+		return "https://www.overleaf.com/project/" + id
+	}
+
+	return op.hostProvider.webURL(rawURL)
+}
+
+func (op overleafProvider) defaultBranch(context.Context, *git.Remote, *conf.AuthMethod) (string, error) {
+	return cOverleafBranch, nil
+}
+
+func (op overleafProvider) singleBranch() bool {
+	return true
+}
+
+// localProvider is the provider of the repos on the local file system.
+type localProvider struct {
+	hostProvider
+}
+
+func (lp localProvider) normalizeURL(rawURL string) string {
+	if strings.HasPrefix(rawURL, "file://") {
+		return rawURL
+	}
+
+	return conf.ExpandHome(rawURL)
+}
+
+func (lp localProvider) webURL(rawURL string) string {
+	if strings.HasPrefix(rawURL, "file://") {
+		return rawURL
+	}
+
+	path, err := filepath.Abs(rawURL)
+	if err != nil {
+		return rawURL
+	}
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(path)}).String()
+}
@@ -0,0 +1,98 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestWebURL(t *testing.T) {
+	for _, tc := range []struct {
+		repo conf.Repo
+		want string
+	}{
+		{conf.Repo{URLToRepo: "https://git.overleaf.com/abcdef"}, "https://www.overleaf.com/project/abcdef"},
+		{conf.Repo{URLToRepo: "https://www.overleaf.com/project/abcdef/"}, "https://www.overleaf.com/project/abcdef"},
+		{conf.Repo{URLToRepo: "git@github.com:user/paper.git"}, "https://github.com/user/paper"},
+		{conf.Repo{URLToRepo: "https://user@gitlab.com/group/paper.git/"}, "https://gitlab.com/group/paper"},
+		{conf.Repo{Kind: conf.KindGitea, URLToRepo: "ssh://git@git.example.com:2222/lab/paper.git"},
+			"https://git.example.com/lab/paper"},
+		{conf.Repo{URLToRepo: "file:///srv/paper"}, "file:///srv/paper"},
+	} {
+		if got := WebURL(tc.repo); got != tc.want {
+			t.Fatalf("unexpected web url of %v: %v", tc.repo.URLToRepo, got)
+		}
+	}
+
+	overleaf := conf.Repo{URLToRepo: "https://www.overleaf.com/project/abcdef"}
+	if got := repoURL(overleaf); got != "https://git.overleaf.com/abcdef" {
+		t.Fatalf("unexpected git url of overleaf project: %v", got)
+	}
+}
+
+func TestRepoAuth(t *testing.T) {
+	am := &conf.AuthMethod{TokenAuth: &http.TokenAuth{Token: "s3cret"}}
+	for kind, user := range map[string]string{
+		conf.KindOverleaf: "git",
+		conf.KindGitHub:   "x-access-token",
+		conf.KindGitLab:   "oauth2",
+	} {
+		auth := repoAuth(conf.Repo{Kind: kind}, am)
+		if auth.TokenAuth != nil || auth.BasicAuth == nil ||
+			auth.Username != user || auth.Password != "s3cret" {
+			t.Fatalf("unexpected auth for %v: %v", kind, auth)
+		}
+	}
+
+	if auth := repoAuth(conf.Repo{Kind: conf.KindGeneric}, am); auth != am {
+		t.Fatalf("unexpected auth for generic host: %v", auth)
+	}
+	if am.BasicAuth != nil {
+		t.Fatal("auth method of the config modified")
+	}
+}
+
+func TestSingleBranchTarget(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+	toDir, toRepo := setupSide(t)
+	defer deleteTestDir(t, toDir)
+
+	// the from repo has `dev` checked out, hence it is its default branch
+	if err := createFile(filepath.Join(fromDir, "dev.tex"), "dev"); err != nil {
+		t.Fatalf("error creating file :: %v", err)
+	}
+	if err := commit(fromRepo, "Update dev.tex"); err != nil {
+		t.Fatalf("error committing :: %v", err)
+	}
+	devHead, err := fromRepo.Reference(plumbing.NewBranchReferenceName("dev"), true)
+	if err != nil {
+		t.Fatalf("error finding dev :: %v", err)
+	}
+
+	sc := conf.SyncConfig{
+		Name:   "single",
+		From:   conf.Repo{Name: "github", URLToRepo: fmt.Sprintf("file://%v", fromDir)},
+		ToList: []conf.Repo{{Name: "overleaf", Kind: conf.KindOverleaf, URLToRepo: fmt.Sprintf("file://%v", toDir)}},
+	}
+	ctx := withLimiter(context.Background(), newLimiter(conf.ConcurrencyConfig{}))
+	if err := syncFolder(ctx, filepath.Join(t.TempDir(), sc.Name), sc, nil); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+
+	if head := masterHead(t, toRepo); head != devHead.Hash() {
+		t.Fatalf("default branch not pushed to master: %v", head)
+	}
+	toDev, err := toRepo.Reference(plumbing.NewBranchReferenceName("dev"), true)
+	if err != nil {
+		t.Fatalf("error finding dev :: %v", err)
+	}
+	if toDev.Hash() == devHead.Hash() {
+		t.Fatal("branch other than the default branch pushed to single branch repo")
+	}
+}
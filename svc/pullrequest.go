@@ -0,0 +1,340 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// pullHeads keeps the commit that each pull request was last opened or updated with, by the URL
+// of the `to` repo and the branch of the pull request, so that the API of the host is only called
+// when the `from` repo has new commits. It is shared by the schedulers.
+var pullHeads = newKeyedValues[plumbing.Hash]()
+
+// pullRequest is an open pull request, a merge request on GitLab.
+type pullRequest struct {
+	Number int
+	URL    string
+}
+
+// pullRequestSpec is what a pull request is opened or updated with.
+type pullRequestSpec struct {
+	head  string
+	base  string
+	title string
+	body  string
+}
+
+// pullRequester is implemented by the providers of the hosts that pull requests can be opened on.
+type pullRequester interface {
+	apiProvider
+	// findPullRequest returns the open pull request from head into base, or nil if there is none.
+	findPullRequest(ctx context.Context, api *apiClient, owner, name, head, base string) (*pullRequest, error)
+	openPullRequest(ctx context.Context, api *apiClient, owner, name string, spec pullRequestSpec) (
+		*pullRequest, error)
+	updatePullRequest(ctx context.Context, api *apiClient, owner, name string, number int,
+		spec pullRequestSpec) error
+}
+
+// pullBranch is a synced branch of a `to` repo with pull requests: the local reference of
+// the `from` repo is pushed to head, and pulled into base through the pull request.
+type pullBranch struct {
+	local plumbing.ReferenceName
+	head  string
+	base  string
+}
+
+// pullBranches returns the branches of the pull requests given the refspecs of the synced branches.
+func pullBranches(pc *conf.PullRequestConfig, from string, refSpecs []config.RefSpec) ([]pullBranch, error) {
+	branch := pc.Branch
+	if branch == "" {
+		branch = from + "-sync"
+	}
+	if !strings.Contains(branch, "*") && len(refSpecs) > 1 {
+		return nil, fmt.Errorf("pull request branch [%v] needs a '*' as %d branches are synced",
+			branch, len(refSpecs))
+	}
+
+	pbs := make([]pullBranch, len(refSpecs))
+	for i, rs := range refSpecs {
+		base := rs.Dst("").Short()
+		pbs[i] = pullBranch{
+			local: plumbing.ReferenceName(rs.Src()),
+			head:  strings.Replace(branch, "*", base, 1),
+			base:  base,
+		}
+	}
+
+	return pbs, nil
+}
+
+// pushPullRequests pushes the synced branches to the branches of the pull requests
+// on the `to` repo, and opens or updates a pull request into each of the branches.
+func pushPullRequests(ctx context.Context, repo *git.Repository, toRemote *git.Remote, from string,
+	to conf.Repo, refSpecs []config.RefSpec, am *conf.AuthMethod) error {
+
+	pbs, err := pullBranches(to.PullRequest, from, refSpecs)
+	if err != nil {
+		return err
+	}
+
+	// the branches of the `to` repo are fetched to find the commits that the pull requests bring
+	if err := fetch(ctx, toRemote, am); err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return err
+	}
+
+	headSpecs := make([]config.RefSpec, len(pbs))
+	for i, pb := range pbs {
+		headSpecs[i] = refSpec(pb.local, pb.head)
+	}
+	if err := pushBranches(ctx, repo, toRemote, to, headSpecs, am); err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, pb := range pbs {
+		if err := syncPullRequest(ctx, repo, from, to, pb, am); err != nil {
+			loggerFrom(ctx).Warn("error syncing pull request", "branch", pb.head, "err", err)
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// syncPullRequest opens the pull request of the branch, or updates the open one, unless the
+// base already has all the commits of the branch or the pull request is already up to date.
+func syncPullRequest(ctx context.Context, repo *git.Repository, from string, to conf.Repo,
+	pb pullBranch, am *conf.AuthMethod) error {
+
+	local, err := repo.Reference(pb.local, true)
+	if err != nil {
+		return fmt.Errorf("error finding [%v] :: %w", pb.local, err)
+	}
+	var baseHash plumbing.Hash
+	if base, err := repo.Reference(plumbing.NewRemoteReferenceName(to.Name, pb.base), true); err == nil {
+		baseHash = base.Hash()
+	}
+
+	// the base has all the commits of the branch once the pull request is merged, be it with a
+	// fast-forward or a merge commit, and the head is remembered only until the daemon restarts
+	key := repoURL(to) + " " + pb.head
+	if isAncestor(repo, local.Hash().String(), baseHash.String()) ||
+		pullHeads.get(key) == local.Hash() {
+		return nil
+	}
+
+	pr, ok := providerFor(to).(pullRequester)
+	if !ok {
+		return fmt.Errorf("pull requests are not supported for %v repos", to.HostKind())
+	}
+	api, err := hostAPI(to, am)
+	if err != nil {
+		return err
+	}
+	owner, name, err := to.OwnerAndName()
+	if err != nil {
+		return err
+	}
+
+	commits, count := newCommits(repo, pb.base, local.Hash(), mergeBase(repo, local.Hash(), baseHash))
+	spec := pullRequestSpec{
+		head:  pb.head,
+		base:  pb.base,
+		title: to.PullRequest.Title,
+		body:  pullRequestBody(from, commits, count),
+	}
+	if spec.title == "" {
+		spec.title = fmt.Sprintf("Sync from %v", from)
+	}
+
+	open, err := pr.findPullRequest(ctx, api, owner, name, pb.head, pb.base)
+	if err != nil {
+		return fmt.Errorf("error finding pull request :: %w", err)
+	}
+	if open == nil {
+		if open, err = pr.openPullRequest(ctx, api, owner, name, spec); err != nil {
+			return fmt.Errorf("error opening pull request :: %w", err)
+		}
+		loggerFrom(ctx).Info("opened pull request", "branch", pb.head, "url", open.URL)
+	} else {
+		if err := pr.updatePullRequest(ctx, api, owner, name, open.Number, spec); err != nil {
+			return fmt.Errorf("error updating pull request :: %w", err)
+		}
+		loggerFrom(ctx).Info("updated pull request", "branch", pb.head, "url", open.URL)
+	}
+
+	pullHeads.set(key, local.Hash())
+	return nil
+}
+
+// mergeBase returns the best common ancestor of the commits, or the zero hash if there is none.
+func mergeBase(repo *git.Repository, a, b plumbing.Hash) plumbing.Hash {
+	ca, err := repo.CommitObject(a)
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+	cb, err := repo.CommitObject(b)
+	if err != nil {
+		return plumbing.ZeroHash
+	}
+
+	bases, err := ca.MergeBase(cb)
+	if err != nil || len(bases) == 0 {
+		return plumbing.ZeroHash
+	}
+
+	return bases[0].Hash
+}
+
+// pullRequestBody summarizes the commits that the pull request brings.
+func pullRequestBody(from string, commits []CommitSummary, count int) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d new commit(s) from %v:\n\n", count, from)
+	for _, c := range commits {
+		fmt.Fprintf(&sb, "- %.7s %v (%v)\n", c.Hash, c.Summary, c.Author)
+	}
+	if more := count - len(commits); more > 0 {
+		fmt.Fprintf(&sb, "- and %d more\n", more)
+	}
+	fmt.Fprintf(&sb, "\nThis pull request is kept up to date by %v.\n", conf.AppName())
+
+	return sb.String()
+}
+
+// githubPull is a pull request as returned by the APIs of GitHub and Gitea.
+type githubPull struct {
+	Number int    `json:"number"`
+	URL    string `json:"html_url"`
+	Head   struct {
+		Ref string `json:"ref"`
+	} `json:"head"`
+	Base struct {
+		Ref string `json:"ref"`
+	} `json:"base"`
+}
+
+func (gp githubProvider) findPullRequest(ctx context.Context, api *apiClient, owner, name, head, base string) (
+	*pullRequest, error) {
+
+	query := url.Values{"state": {"open"}, "head": {owner + ":" + head}, "base": {base}}
+	var pulls []githubPull
+	path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name) + "/pulls?" + query.Encode()
+	if _, err := api.call(ctx, http.MethodGet, path, nil, &pulls); err != nil {
+		return nil, err
+	}
+	if len(pulls) == 0 {
+		return nil, nil
+	}
+
+	return &pullRequest{Number: pulls[0].Number, URL: pulls[0].URL}, nil
+}
+
+func (gp githubProvider) openPullRequest(ctx context.Context, api *apiClient, owner, name string,
+	spec pullRequestSpec) (*pullRequest, error) {
+
+	var pull githubPull
+	path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name) + "/pulls"
+	body := map[string]string{"title": spec.title, "head": spec.head, "base": spec.base, "body": spec.body}
+	if _, err := api.call(ctx, http.MethodPost, path, body, &pull); err != nil {
+		return nil, err
+	}
+
+	return &pullRequest{Number: pull.Number, URL: pull.URL}, nil
+}
+
+func (gp githubProvider) updatePullRequest(ctx context.Context, api *apiClient, owner, name string,
+	number int, spec pullRequestSpec) error {
+
+	path := fmt.Sprintf("/repos/%v/%v/pulls/%d", url.PathEscape(owner), url.PathEscape(name), number)
+	_, err := api.call(ctx, http.MethodPatch, path, map[string]string{"title": spec.title, "body": spec.body}, nil)
+	return err
+}
+
+// gitlabMerge is a merge request as returned by the API of GitLab.
+type gitlabMerge struct {
+	IID int    `json:"iid"`
+	URL string `json:"web_url"`
+}
+
+func (gp gitlabProvider) findPullRequest(ctx context.Context, api *apiClient, owner, name, head, base string) (
+	*pullRequest, error) {
+
+	query := url.Values{"state": {"opened"}, "source_branch": {head}, "target_branch": {base}}
+	var merges []gitlabMerge
+	path := "/projects/" + url.PathEscape(owner+"/"+name) + "/merge_requests?" + query.Encode()
+	if _, err := api.call(ctx, http.MethodGet, path, nil, &merges); err != nil {
+		return nil, err
+	}
+	if len(merges) == 0 {
+		return nil, nil
+	}
+
+	return &pullRequest{Number: merges[0].IID, URL: merges[0].URL}, nil
+}
+
+func (gp gitlabProvider) openPullRequest(ctx context.Context, api *apiClient, owner, name string,
+	spec pullRequestSpec) (*pullRequest, error) {
+
+	var merge gitlabMerge
+	path := "/projects/" + url.PathEscape(owner+"/"+name) + "/merge_requests"
+	body := map[string]string{
+		"source_branch": spec.head,
+		"target_branch": spec.base,
+		"title":         spec.title,
+		"description":   spec.body,
+	}
+	if _, err := api.call(ctx, http.MethodPost, path, body, &merge); err != nil {
+		return nil, err
+	}
+
+	return &pullRequest{Number: merge.IID, URL: merge.URL}, nil
+}
+
+func (gp gitlabProvider) updatePullRequest(ctx context.Context, api *apiClient, owner, name string,
+	number int, spec pullRequestSpec) error {
+
+	path := fmt.Sprintf("/projects/%v/merge_requests/%d", url.PathEscape(owner+"/"+name), number)
+	body := map[string]string{"title": spec.title, "description": spec.body}
+	_, err := api.call(ctx, http.MethodPut, path, body, nil)
+	return err
+}
+
+// findPullRequest lists the open pull requests as the API of Gitea can't filter them by branch.
+func (gp giteaProvider) findPullRequest(ctx context.Context, api *apiClient, owner, name, head, base string) (
+	*pullRequest, error) {
+
+	var pulls []githubPull
+	path := "/repos/" + url.PathEscape(owner) + "/" + url.PathEscape(name) + "/pulls?state=open&limit=50"
+	if _, err := api.call(ctx, http.MethodGet, path, nil, &pulls); err != nil {
+		return nil, err
+	}
+	for _, pull := range pulls {
+		if pull.Head.Ref == head && pull.Base.Ref == base {
+			return &pullRequest{Number: pull.Number, URL: pull.URL}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (gp giteaProvider) openPullRequest(ctx context.Context, api *apiClient, owner, name string,
+	spec pullRequestSpec) (*pullRequest, error) {
+
+	return githubProvider(gp).openPullRequest(ctx, api, owner, name, spec)
+}
+
+func (gp giteaProvider) updatePullRequest(ctx context.Context, api *apiClient, owner, name string,
+	number int, spec pullRequestSpec) error {
+
+	return githubProvider(gp).updatePullRequest(ctx, api, owner, name, number, spec)
+}
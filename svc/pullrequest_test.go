@@ -0,0 +1,135 @@
+package svc
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestPullBranches(t *testing.T) {
+	refSpecs := []config.RefSpec{
+		refSpec(plumbing.NewBranchReferenceName("master"), "main"),
+		refSpec(plumbing.NewBranchReferenceName("dev"), "dev"),
+	}
+	pbs, err := pullBranches(&conf.PullRequestConfig{Branch: "overleaf/*"}, "overleaf", refSpecs)
+	if err != nil {
+		t.Fatalf("error finding pull branches :: %v", err)
+	}
+	if len(pbs) != 2 || pbs[0].head != "overleaf/main" || pbs[0].base != "main" ||
+		pbs[0].local != plumbing.NewBranchReferenceName("master") || pbs[1].head != "overleaf/dev" {
+		t.Fatalf("unexpected pull branches: %v", pbs)
+	}
+
+	if _, err := pullBranches(&conf.PullRequestConfig{}, "overleaf", refSpecs); err == nil {
+		t.Fatal("expected error for a single pull request branch with many synced branches")
+	}
+	pbs, err = pullBranches(&conf.PullRequestConfig{}, "overleaf", refSpecs[:1])
+	if err != nil || len(pbs) != 1 || pbs[0].head != "overleaf-sync" {
+		t.Fatalf("unexpected default pull branch: %v, %v", pbs, err)
+	}
+}
+
+func TestSyncPullRequest(t *testing.T) {
+	dir, repo := setupSide(t)
+	defer deleteTestDir(t, dir)
+
+	fa := &fakeAPI{
+		responses: map[string]string{
+			"GET /api/v3/repos/lab/paper/pulls":  `[]`,
+			"POST /api/v3/repos/lab/paper/pulls": `{"number": 3, "html_url": "https://example.com/pull/3"}`,
+		},
+		bodies: make(map[string]map[string]any),
+	}
+	server := httptest.NewServer(fa)
+	defer server.Close()
+
+	to := conf.Repo{
+		Name:        "github",
+		Kind:        conf.KindGitHub,
+		URLToRepo:   server.URL + "/lab/paper.git",
+		PullRequest: &conf.PullRequestConfig{Title: "Overleaf edits"},
+	}
+	pb := pullBranch{local: plumbing.NewBranchReferenceName("master"), head: "overleaf-sync", base: "master"}
+	am := &conf.AuthMethod{TokenAuth: &githttp.TokenAuth{Token: "s3cret"}}
+	ctx := context.Background()
+	if err := syncPullRequest(ctx, repo, "overleaf", to, pb, am); err != nil {
+		t.Fatalf("error opening pull request :: %v", err)
+	}
+	body := fa.bodies["POST /api/v3/repos/lab/paper/pulls"]
+	if len(fa.requests) != 2 || body["head"] != "overleaf-sync" || body["base"] != "master" ||
+		body["title"] != "Overleaf edits" || !strings.Contains(body["body"].(string), "from overleaf") {
+		t.Fatalf("unexpected requests to open pull request: %v, %v", fa.requests, body)
+	}
+
+	// the pull request is up to date, hence the API isn't called
+	if err := syncPullRequest(ctx, repo, "overleaf", to, pb, am); err != nil {
+		t.Fatalf("error syncing pull request :: %v", err)
+	}
+	if len(fa.requests) != 2 {
+		t.Fatalf("up to date pull request updated: %v", fa.requests)
+	}
+
+	commitOnMaster(t, dir, repo, "intro.tex", "intro")
+	fa.responses["GET /api/v3/repos/lab/paper/pulls"] = `[{"number": 3, "html_url": "https://example.com/pull/3"}]`
+	fa.responses["PATCH /api/v3/repos/lab/paper/pulls/3"] = `{}`
+	if err := syncPullRequest(ctx, repo, "overleaf", to, pb, am); err != nil {
+		t.Fatalf("error updating pull request :: %v", err)
+	}
+	body = fa.bodies["PATCH /api/v3/repos/lab/paper/pulls/3"]
+	if len(fa.requests) != 4 || !strings.Contains(body["body"].(string), "Update intro.tex") {
+		t.Fatalf("unexpected requests to update pull request: %v, %v", fa.requests, body)
+	}
+
+	// the base has all the commits of the branch once the pull request is merged
+	commitOnMaster(t, dir, repo, "outro.tex", "outro")
+	merged := plumbing.NewHashReference(plumbing.NewRemoteReferenceName(to.Name, "master"), masterHead(t, repo))
+	if err := repo.Storer.SetReference(merged); err != nil {
+		t.Fatalf("error setting remote reference :: %v", err)
+	}
+	if err := syncPullRequest(ctx, repo, "overleaf", to, pb, am); err != nil {
+		t.Fatalf("error syncing merged pull request :: %v", err)
+	}
+	if len(fa.requests) != 4 {
+		t.Fatalf("pull request opened for merged branch: %v", fa.requests)
+	}
+
+	// the pull request is merged with a merge commit, and the daemon restarts in the meantime
+	commitOnMaster(t, dir, repo, "appendix.tex", "appendix")
+	head, err := repo.CommitObject(masterHead(t, repo))
+	if err != nil {
+		t.Fatalf("error finding head :: %v", err)
+	}
+	mergeHash, err := writeCommit(repo.Storer, head.TreeHash, []plumbing.Hash{merged.Hash(), head.Hash},
+		"Merge pull request #3")
+	if err != nil {
+		t.Fatalf("error writing merge commit :: %v", err)
+	}
+	if err := setRef(repo, merged.Name(), mergeHash); err != nil {
+		t.Fatalf("error setting remote reference :: %v", err)
+	}
+	pullHeads = newKeyedValues[plumbing.Hash]()
+	if err := syncPullRequest(ctx, repo, "overleaf", to, pb, am); err != nil {
+		t.Fatalf("error syncing merged pull request :: %v", err)
+	}
+	if len(fa.requests) != 4 {
+		t.Fatalf("pull request opened for branch merged with a merge commit: %v", fa.requests)
+	}
+
+	// only the commits since the merge are in the next pull request
+	commitOnMaster(t, dir, repo, "bib.tex", "bib")
+	fa.responses["GET /api/v3/repos/lab/paper/pulls"] = `[]`
+	if err := syncPullRequest(ctx, repo, "overleaf", to, pb, am); err != nil {
+		t.Fatalf("error opening pull request :: %v", err)
+	}
+	body = fa.bodies["POST /api/v3/repos/lab/paper/pulls"]
+	if len(fa.requests) != 6 || !strings.HasPrefix(body["body"].(string), "1 new commit(s)") ||
+		!strings.Contains(body["body"].(string), "bib.tex") {
+		t.Fatalf("unexpected requests to open pull request after merge: %v, %v", fa.requests, body)
+	}
+}
@@ -2,38 +2,65 @@ package svc
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"log"
 	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/mangalaman93/giggle/conf"
 )
 
-func performSync(ctx context.Context, cf *conf.Config) error {
-	log.Println("[INFO] periodic sync begin")
-	defer log.Println("[INFO] periodic sync end")
-
-	for _, sc := range cf.Sync {
-		log.Printf("[INFO] syncing %v\n", sc.Name)
-		if err := syncRepo(ctx, sc, cf.Auth); err != nil {
-			log.Printf("[WARN] error syncing %v :: %v\n", sc.Name, err)
-			continue
-		}
-
-		log.Printf("[INFO] synced %v\n", sc.Name)
+// syncOne syncs the repos of a single sync config and logs the outcome.
+func syncOne(ctx context.Context, sc conf.SyncConfig, authMap map[string]*conf.AuthMethod) error {
+	logger := loggerFrom(ctx)
+	logger.Info("syncing")
+	start := time.Now()
+	if err := syncRepo(ctx, sc, authMap); err != nil {
+		logger.Warn("error syncing", "duration", time.Since(start), "err", err)
+		return err
 	}
 
+	logger.Info("synced", "duration", time.Since(start))
 	return nil
 }
 
 func syncRepo(ctx context.Context, sc conf.SyncConfig,
 	authMap map[string]*conf.AuthMethod) error {
 
-	repoFolder := conf.GetSyncTarget(sc.Name)
-	fromAuth := authMap[sc.From.AuthToUse]
-	fromRepo, err := openRepo(ctx, sc.From, fromAuth, repoFolder)
+	return syncFolder(ctx, conf.GetSyncTarget(sc.Name), sc, authMap)
+}
+
+// syncFolder syncs the repos of the sync config using the local clone in `repoFolder`.
+func syncFolder(ctx context.Context, repoFolder string, sc conf.SyncConfig,
+	authMap map[string]*conf.AuthMethod) error {
+
+	unlock, err := folderLocks.lock(ctx, repoFolder)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// the bytes fetched, including those of the clone, are found from the growth of the packs
+	packsBefore := packSize(repoFolder)
+	defer func() {
+		fetched := max(packSize(repoFolder)-packsBefore, 0)
+		metrics.fetchedBytes.WithLabelValues(sc.Name).Add(float64(fetched))
+	}()
+
+	if len(sc.Sources) > 0 {
+		return syncSources(ctx, repoFolder, sc, authMap)
+	}
+
+	fromAuth := repoAuth(sc.From, authMap[sc.From.AuthToUse])
+	openCtx, donePhase := startPhase(ctx, sc.Name, phaseOpen)
+	fromRepo, err := openRepo(openCtx, sc.From, fromAuth, repoFolder)
+	donePhase()
 	if err != nil {
 		return err
 	}
@@ -43,28 +70,139 @@ func syncRepo(ctx context.Context, sc conf.SyncConfig,
 		return err
 	}
 
-	if err := fetch(ctx, fromRemote, fromAuth); err != nil {
+	fetchCtx, donePhase := startPhase(ctx, sc.Name, phaseFetch)
+	err = fetch(fetchCtx, fromRemote, fromAuth)
+	if err == nil {
+		err = fetchTags(fetchCtx, fromRepo, fromRemote, sc.Tags, fromAuth)
+	}
+	donePhase()
+	if err != nil {
+		return err
+	}
+	ctx, donePhase = startPhase(ctx, sc.Name, phasePush)
+	defer donePhase()
+
+	// the commits are looked up once the sync is done, as a merge may move the branches
+	if report := reportFrom(ctx); report != nil {
+		defer func() {
+			branches, _ := syncedBranches(fromRepo, sc.From.Name, sc.Branches)
+			report.resolve(fromRepo, sc.From.Name, branches)
+		}()
+	}
+
+	if sc.Direction == conf.DirectionBoth {
+		return syncBoth(ctx, fromRepo, repoFolder, sc, authMap)
+	}
+
+	refSpecs, err := branchRefSpecs(fromRepo, sc.From.Name, sc.Branches)
+	if err != nil {
 		return err
 	}
+	if len(refSpecs) == 0 {
+		loggerFrom(ctx).Warn("no branch matches the branch policy", "branches", sc.Branches)
+		return nil
+	}
+
+	return pushTargets(ctx, fromRepo, repoFolder, sc, sc.From.Name, refSpecs, fromAuth, authMap)
+}
+
+// pushTargets pushes the refspecs to the `to` repos of the sync in parallel, and records
+// the branches that diverged from any of them. `from` names the source in pull requests.
+func pushTargets(ctx context.Context, repo *git.Repository, repoFolder string, sc conf.SyncConfig,
+	from string, refSpecs []config.RefSpec, fromAuth *conf.AuthMethod,
+	authMap map[string]*conf.AuthMethod) error {
 
+	// remotes are created upfront as it modifies the config of the repo.
 	var errRet error
+	var toList []conf.Repo
 	for _, to := range sc.ToList {
-		toAuth := authMap[to.AuthToUse]
-		toRemote, err := createRemote(fromRepo, to.Name, to.URLToRepo)
-		if err != nil {
-			log.Printf("[WARN] error creating remote in: %v :: %v\n", sc.From.Name, err)
+		if _, err := createRemote(repo, to.Name, repoURL(to)); err != nil {
+			loggerFrom(ctx).Warn("error creating remote", "remote", to.Name, "err", err)
 			errRet = err
 			continue
 		}
+		toList = append(toList, to)
+	}
 
-		if err := push(ctx, fromRemote, toRemote, toAuth); err != nil {
-			log.Printf("[WARN] error syncing to repo: %v :: %v\n", to.Name, err)
-			errRet = err
-			continue
+	errs := make([]error, len(toList))
+	var wg sync.WaitGroup
+	for i, to := range toList {
+		toAuth, toRefSpecs := repoAuth(to, authMap[to.AuthToUse]), refSpecs
+		if providerFor(to).singleBranch() {
+			var err error
+			toRefSpecs, err = singleBranchRefSpecs(ctx, repo, sc, to, refSpecs, fromAuth, toAuth)
+			if err != nil {
+				errs[i] = err
+				continue
+			}
+		}
+
+		wg.Add(1)
+		go func(i int, to conf.Repo) {
+			defer wg.Done()
+			errs[i] = pushTarget(ctx, repoFolder, from, to, toRefSpecs, sc.Tags, toAuth)
+		}(i, to)
+	}
+	wg.Wait()
+
+	// the branches that diverged from any of the `to` repos are recorded together
+	var conflicts []Conflict
+	for _, err := range errs {
+		var errDiverged *ErrDiverged
+		if errors.As(err, &errDiverged) {
+			conflicts = append(conflicts, errDiverged.Conflicts...)
+		}
+	}
+	if err := writeConflicts(repoFolder, conflicts); err != nil {
+		errRet = errors.Join(errRet, err)
+	}
+
+	return errors.Join(append(errs, errRet)...)
+}
+
+// pushTarget pushes the branches, or opens pull requests for them, and the tags to a `to`
+// repo. It opens its own handle to the repo folder as a go-git repository is not safe for
+// concurrent use.
+func pushTarget(ctx context.Context, repoFolder, from string, to conf.Repo,
+	refSpecs []config.RefSpec, tc *conf.TagConfig, am *conf.AuthMethod) error {
+
+	ctx = withLogAttrs(ctx, "remote", to.Name)
+	repo, err := git.PlainOpen(repoFolder)
+	if err != nil {
+		return fmt.Errorf("error opening the repo [%v] :: %w", repoFolder, err)
+	}
+
+	toRemote, err := repo.Remote(to.Name)
+	if err != nil {
+		return fmt.Errorf("error finding remote [%v] :: %w", to.Name, err)
+	}
+
+	if err := ensureTarget(ctx, to, am); err != nil {
+		loggerFrom(ctx).Warn("error creating repo", "err", err)
+		return err
+	}
+
+	if to.PullRequest != nil {
+		if err := pushPullRequests(ctx, repo, toRemote, from, to, refSpecs, am); err != nil {
+			loggerFrom(ctx).Warn("error pushing pull requests", "err", err)
+			return err
 		}
+	} else if err := pushBranches(ctx, repo, toRemote, to, refSpecs, am); err != nil {
+		loggerFrom(ctx).Warn("error pushing branches", "err", err)
+		return err
+	}
+
+	if err := finishTarget(ctx, to, am); err != nil {
+		loggerFrom(ctx).Warn("error setting up created repo", "err", err)
+		return err
 	}
 
-	return errRet
+	if err := pushTags(ctx, repo, toRemote, from, tc, am); err != nil {
+		loggerFrom(ctx).Warn("error pushing tags", "err", err)
+		return err
+	}
+
+	return nil
 }
 
 // openRepo opens the git repo and returns an instance to it.
@@ -73,10 +211,23 @@ func openRepo(ctx context.Context, cr conf.Repo, auth *conf.AuthMethod, folder s
 	*git.Repository, error) {
 
 	if _, errExist := os.Stat(folder); os.IsNotExist(errExist) {
-		repo, err := git.PlainCloneContext(ctx, folder, false, &git.CloneOptions{
-			URL:        cr.URLToRepo,
-			RemoteName: cr.Name,
-			Auth:       auth.GetAuth(),
+		rawURL := repoURL(cr)
+		am, err := auth.GetAuth(rawURL)
+		if err != nil {
+			return nil, fmt.Errorf("error in auth for the repo [%v] :: %w", cr.Name, err)
+		}
+
+		ctx = withLogAttrs(ctx, "remote", cr.Name)
+		loggerFrom(ctx).Info("cloning")
+		var repo *git.Repository
+		err = withRemote(ctx, rawURL, func() error {
+			var err error
+			repo, err = git.PlainCloneContext(ctx, folder, false, &git.CloneOptions{
+				URL:        rawURL,
+				RemoteName: cr.Name,
+				Auth:       am,
+			})
+			return err
 		})
 		if err != nil {
 			return nil, fmt.Errorf("error cloning the repo [%v] :: %w", cr.Name, err)
@@ -100,11 +251,20 @@ func createRemote(repo *git.Repository, name, url string) (*git.Remote, error) {
 	remote, err := repo.Remote(name)
 	if err == nil {
 		remoteURLs := remote.Config().URLs
-		if len(remoteURLs) != 1 || remoteURLs[0] != url {
-			remote.Config().URLs = []string{url}
+		if len(remoteURLs) == 1 && remoteURLs[0] == url {
+			return remote, nil
+		}
+
+		cfg, err := repo.Config()
+		if err != nil {
+			return nil, fmt.Errorf("error reading repo config :: %w", err)
+		}
+		cfg.Remotes[name].URLs = []string{url}
+		if err := repo.SetConfig(cfg); err != nil {
+			return nil, fmt.Errorf("error updating remote [%v] :: %w", name, err)
 		}
 
-		return remote, nil
+		return repo.Remote(name)
 	} else if err != git.ErrRemoteNotFound {
 		return nil, fmt.Errorf("error finding remote [%v] :: %w", name, err)
 	}
@@ -120,29 +280,172 @@ func createRemote(repo *git.Repository, name, url string) (*git.Remote, error) {
 	return remote, nil
 }
 
-// fetch fetches from provided remote.
-func fetch(ctx context.Context, from *git.Remote, am *conf.AuthMethod) error {
-	err := from.FetchContext(ctx, &git.FetchOptions{Auth: am.GetAuth()})
-	if err != nil && err != git.NoErrAlreadyUpToDate {
+// fetch fetches from provided remote. The `extra` refspecs
+// are fetched in addition to the refspecs of the remote.
+func fetch(ctx context.Context, from *git.Remote, am *conf.AuthMethod,
+	extra ...config.RefSpec) error {
+
+	auth, err := am.GetAuth(from.Config().URLs[0])
+	if err != nil {
+		return fmt.Errorf("error in auth for [%v] :: %w", from.Config().Name, err)
+	}
+
+	ctx = withLogAttrs(ctx, "remote", from.Config().Name)
+	o := &git.FetchOptions{Auth: auth}
+	if len(extra) > 0 {
+		o.RefSpecs = append(append([]config.RefSpec{}, from.Config().Fetch...), extra...)
+	}
+	err = withRemote(ctx, from.Config().URLs[0], func() error {
+		if err := from.FetchContext(ctx, o); err != nil && err != git.NoErrAlreadyUpToDate {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("error fetching from [%v] :: %w", from.Config().Name, err)
 	}
 
 	return nil
 }
 
-// push pushes from the `from` remote to the `to` remote.
-// `auth` is authentication for `to` auth.
-func push(ctx context.Context, from, to *git.Remote, am *conf.AuthMethod) error {
+// branchMapping maps a branch of the `from` repo to a branch of the `to` repos.
+type branchMapping struct {
+	source string
+	target string
+}
+
+// syncedBranches lists the fetched branches of the `from` remote and returns
+// the branches that are selected by the branch policy along with their targets.
+func syncedBranches(repo *git.Repository, from string, bp conf.BranchPolicy) (
+	[]branchMapping, error) {
+
+	refs, err := repo.References()
+	if err != nil {
+		return nil, fmt.Errorf("error listing references :: %w", err)
+	}
+	defer refs.Close()
+
+	prefix := fmt.Sprintf("refs/remotes/%v/", from)
+	targets := make(map[string]string)
+	var branches []branchMapping
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		refName := ref.Name().String()
+		if ref.Type() != plumbing.HashReference || !strings.HasPrefix(refName, prefix) {
+			return nil
+		}
+
+		branch := strings.TrimPrefix(refName, prefix)
+		target, ok := bp.Target(branch)
+		if !ok {
+			return nil
+		}
+		if other, dup := targets[target]; dup {
+			return fmt.Errorf("branches [%v] and [%v] both map to [%v]", other, branch, target)
+		}
+
+		targets[target] = branch
+		branches = append(branches, branchMapping{source: branch, target: target})
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error expanding branch policy :: %w", err)
+	}
+
+	sort.Slice(branches, func(i, j int) bool { return branches[i].source < branches[j].source })
+	return branches, nil
+}
+
+// branchRefSpecs returns the push refspecs for the branches of the `from`
+// remote that are selected by the branch policy.
+func branchRefSpecs(repo *git.Repository, from string, bp conf.BranchPolicy) (
+	[]config.RefSpec, error) {
+
+	branches, err := syncedBranches(repo, from, bp)
+	if err != nil {
+		return nil, err
+	}
+
+	refSpecs := make([]config.RefSpec, len(branches))
+	for i, bm := range branches {
+		refSpecs[i] = refSpec(plumbing.NewRemoteReferenceName(from, bm.source), bm.target)
+	}
+
+	return refSpecs, nil
+}
+
+// singleBranchRefSpecs returns the refspecs to push to a repo that can only have its default
+// branch: the refspecs of the branch policy or of the sources that push to it, or, if the sync
+// has neither, the refspec that pushes the default branch of the `from` repo to it.
+func singleBranchRefSpecs(ctx context.Context, repo *git.Repository, sc conf.SyncConfig, to conf.Repo,
+	refSpecs []config.RefSpec, fromAuth, toAuth *conf.AuthMethod) ([]config.RefSpec, error) {
+
+	toRemote, err := repo.Remote(to.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error finding remote [%v] :: %w", to.Name, err)
+	}
+	target, err := providerFor(to).defaultBranch(ctx, toRemote, toAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sc.Branches) > 0 || len(sc.Sources) > 0 {
+		var specs []config.RefSpec
+		for _, rs := range refSpecs {
+			if rs.Dst("") == plumbing.NewBranchReferenceName(target) {
+				specs = append(specs, rs)
+			}
+		}
+		if len(specs) < len(refSpecs) {
+			loggerFrom(ctx).Info("only pushing the default branch", "remote", to.Name, "branch", target)
+		}
+		return specs, nil
+	}
+
+	fromRemote, err := repo.Remote(sc.From.Name)
+	if err != nil {
+		return nil, fmt.Errorf("error finding remote [%v] :: %w", sc.From.Name, err)
+	}
+	source, err := providerFor(sc.From).defaultBranch(ctx, fromRemote, fromAuth)
+	if err != nil {
+		return nil, err
+	}
+
+	loggerFrom(ctx).Info("pushing the default branch", "remote", to.Name, "branch", source, "target", target)
+	return []config.RefSpec{refSpec(plumbing.NewRemoteReferenceName(sc.From.Name, source), target)}, nil
+}
+
+// refSpec returns the refspec to push a local reference to a branch of a remote.
+func refSpec(local plumbing.ReferenceName, branch string) config.RefSpec {
+	return config.RefSpec(fmt.Sprintf("%v:%v", local, plumbing.NewBranchReferenceName(branch)))
+}
+
+// push pushes the given refspecs to the `to` remote. `auth` is authentication for `to`
+// auth. The push is rejected unless the remote refs match the `require` refspecs.
+func push(ctx context.Context, to *git.Remote, refSpecs []config.RefSpec,
+	am *conf.AuthMethod, require ...config.RefSpec) error {
+
+	auth, err := am.GetAuth(to.Config().URLs[0])
+	if err != nil {
+		return fmt.Errorf("error in auth for [%v] :: %w", to.Config().Name, err)
+	}
+
+	ctx = withLogAttrs(ctx, "remote", to.Config().Name)
 	o := &git.PushOptions{
-		RemoteName: to.Config().Name,
-		Auth:       am.GetAuth(),
-		RefSpecs: []config.RefSpec{
-			config.RefSpec(fmt.Sprintf("refs/remotes/%v/master:refs/heads/master", from.Config().Name)),
-		},
+		RemoteName:        to.Config().Name,
+		Auth:              auth,
+		RefSpecs:          refSpecs,
+		RequireRemoteRefs: require,
 	}
-	if err := to.PushContext(ctx, o); err != nil && err != git.NoErrAlreadyUpToDate {
+	err = withRemote(ctx, to.Config().URLs[0], func() error {
+		if err := to.PushContext(ctx, o); err != nil && err != git.NoErrAlreadyUpToDate {
+			return err
+		}
+		return nil
+	})
+	if err != nil {
 		return fmt.Errorf("error pushing [%v] :: %w", o.RefSpecs, err)
 	}
 
+	reportFrom(ctx).recordPush(to.Config().Name, refSpecs)
 	return nil
 }
@@ -12,6 +12,7 @@ import (
 
 	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/object"
 	"github.com/mangalaman93/giggle/conf"
 )
@@ -326,7 +327,11 @@ func TestPush(t *testing.T) {
 	}
 
 	// push changes from repo2 to repo3 (that are on repo1 remote)
-	if err := push(context.Background(), remote1, remote3, nil); err != nil {
+	refSpecs, err := branchRefSpecs(repo2, "repo1", nil)
+	if err != nil {
+		t.Fatalf("error expanding branch policy :: %v", err)
+	}
+	if err := push(context.Background(), remote3, refSpecs, nil); err != nil {
 		t.Fatalf("error pushing from repo1 to repo3 :: %v", err)
 	}
 
@@ -347,3 +352,115 @@ func TestPush(t *testing.T) {
 		t.Fatalf("fetch didn't work as expected")
 	}
 }
+
+func TestBranchRefSpecs(t *testing.T) {
+	repo1Dir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(1000)))
+	createTestDir(t, repo1Dir)
+	defer deleteTestDir(t, repo1Dir)
+	repo1, err := setupGitRepo(repo1Dir)
+	if err != nil {
+		t.Fatalf("error setting up git repo :: %v", err)
+	}
+	wt1, err := repo1.Worktree()
+	if err != nil {
+		t.Fatalf("error in getting the work tree :: %v", err)
+	}
+	for _, branch := range []string{"dev", "feature/one"} {
+		if err := wt1.Checkout(&git.CheckoutOptions{
+			Branch: plumbing.NewBranchReferenceName(branch),
+			Create: true,
+		}); err != nil {
+			t.Fatalf("error in creating a branch :: %v", err)
+		}
+	}
+
+	repo2Dir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(1000)))
+	defer deleteTestDir(t, repo2Dir)
+	cr := conf.Repo{
+		Name:      "repo1",
+		Kind:      "repo1",
+		URLToRepo: fmt.Sprintf("file://%v", repo1Dir),
+	}
+	repo2, err := openRepo(context.Background(), cr, nil, repo2Dir)
+	if err != nil {
+		t.Fatalf("error opening repo :: %v", err)
+	}
+
+	tests := []struct {
+		rules    []string
+		expected []config.RefSpec
+	}{
+		{nil, []config.RefSpec{
+			"refs/remotes/repo1/dev:refs/heads/dev",
+			"refs/remotes/repo1/feature/one:refs/heads/feature/one",
+			"refs/remotes/repo1/master:refs/heads/master",
+		}},
+		{[]string{"master->main"}, []config.RefSpec{
+			"refs/remotes/repo1/master:refs/heads/main",
+		}},
+		{[]string{"feature/*->overleaf/*", "dev"}, []config.RefSpec{
+			"refs/remotes/repo1/dev:refs/heads/dev",
+			"refs/remotes/repo1/feature/one:refs/heads/overleaf/one",
+		}},
+		{[]string{"main"}, nil},
+	}
+	for _, tc := range tests {
+		var bp conf.BranchPolicy
+		for _, rule := range tc.rules {
+			br, err := conf.ParseBranchRule(rule)
+			if err != nil {
+				t.Fatalf("error parsing branch rule :: %v", err)
+			}
+			bp = append(bp, br)
+		}
+
+		refSpecs, err := branchRefSpecs(repo2, "repo1", bp)
+		if err != nil {
+			t.Fatalf("error expanding branch policy %v :: %v", tc.rules, err)
+		}
+		if fmt.Sprint(refSpecs) != fmt.Sprint(tc.expected) {
+			t.Fatalf("unexpected refspecs for %v: %v", tc.rules, refSpecs)
+		}
+	}
+
+	// two branches mapping onto the same target is an error.
+	bp := conf.BranchPolicy{{Pattern: "*", Rename: "main"}}
+	if _, err := branchRefSpecs(repo2, "repo1", bp); err == nil {
+		t.Fatal("expected error for conflicting branch renames")
+	}
+}
+
+func TestSyncFolder(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+	to1Dir, to1Repo := setupSide(t)
+	defer deleteTestDir(t, to1Dir)
+	to2Dir, to2Repo := setupSide(t)
+	defer deleteTestDir(t, to2Dir)
+
+	sc := conf.SyncConfig{
+		Name: "project",
+		From: conf.Repo{Name: "overleaf", URLToRepo: fmt.Sprintf("file://%v", fromDir)},
+		ToList: []conf.Repo{
+			{Name: "github", URLToRepo: fmt.Sprintf("file://%v", to1Dir)},
+			{Name: "gitlab", URLToRepo: fmt.Sprintf("file://%v", to2Dir)},
+		},
+		Branches: conf.BranchPolicy{{Pattern: "master", Rename: "main"}},
+	}
+	repoDir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(100000)))
+	defer deleteTestDir(t, repoDir)
+	ctx := withLimiter(context.Background(), newLimiter(conf.ConcurrencyConfig{}))
+	if err := syncFolder(ctx, repoDir, sc, nil); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+
+	for _, toRepo := range []*git.Repository{to1Repo, to2Repo} {
+		ref, err := toRepo.Reference(plumbing.NewBranchReferenceName("main"), true)
+		if err != nil {
+			t.Fatalf("main branch not found :: %v", err)
+		}
+		if ref.Hash() != masterHead(t, fromRepo) {
+			t.Fatalf("unexpected head of main: %v", ref.Hash())
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	githttp "github.com/go-git/go-git/v5/plumbing/transport/http"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// Error classes of the failures of remote operations.
+const (
+	errClassTransient = "transient"
+	errClassAuth      = "auth"
+	errClassOther     = "other"
+)
+
+type retryKey struct{}
+
+// breakers keeps the circuit breaker of every remote. It is shared
+// across the schedulers so that the state survives config reloads.
+var breakers = &breakerSet{breakers: make(map[string]*breaker)}
+
+// ErrCircuitOpen is returned when a remote is not contacted because of repeated failures.
+type ErrCircuitOpen struct {
+	Remote    string
+	OpenUntil time.Time
+}
+
+func (e *ErrCircuitOpen) Error() string {
+	return fmt.Sprintf("circuit open for [%v] until %v", e.Remote, e.OpenUntil.Format(time.RFC3339))
+}
+
+// BreakerState is the state of the circuit breaker of a remote.
+type BreakerState struct {
+	Remote    string    `json:"remote"`
+	Failures  int       `json:"failures"`
+	LastError string    `json:"last_error,omitempty"`
+	OpenUntil time.Time `json:"open_until,omitempty"`
+}
+
+// BreakerStates returns the state of the breakers of all the remotes that failed recently.
+func BreakerStates() []BreakerState {
+	return breakers.states()
+}
+
+// withRetry returns a context that carries the retry config to the remote operations.
+func withRetry(ctx context.Context, rc conf.RetryConfig) context.Context {
+	return context.WithValue(ctx, retryKey{}, rc)
+}
+
+// withRemote runs `op` against the remote URL, bounded by the limiter carried by the
+// context. Transient failures are retried as per the retry config carried by the
+// context, and the remote isn't contacted at all while its circuit breaker is open.
+func withRemote(ctx context.Context, rawURL string, op func() error) error {
+	b := breakers.get(rawURL)
+	if err := b.allow(); err != nil {
+		return err
+	}
+
+	rc, _ := ctx.Value(retryKey{}).(conf.RetryConfig)
+	var err error
+	for attempt := 1; ; attempt++ {
+		release, errAcquire := acquireHost(ctx, rawURL)
+		if errAcquire != nil {
+			return errAcquire
+		}
+		err = op()
+		release()
+
+		if err == nil || classifyError(err) != errClassTransient || attempt >= rc.AttemptLimit() {
+			break
+		}
+
+		delay := retryDelay(rc, attempt)
+		loggerFrom(ctx).Warn("remote operation failed, retrying", "attempt", attempt,
+			"delay", delay, "err", err)
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+
+	b.record(err)
+	return err
+}
+
+// retryDelay returns the delay before the next attempt with exponential backoff and jitter.
+func retryDelay(rc conf.RetryConfig, attempt int) time.Duration {
+	delay := rc.InitialBackoff()
+	for i := 1; i < attempt && delay < rc.BackoffLimit(); i++ {
+		delay *= 2
+	}
+	delay = min(delay, rc.BackoffLimit())
+
+	if jitter := rc.JitterFraction(); jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 - jitter + 2*jitter*rand.Float64()))
+	}
+
+	return delay
+}
+
+// classifyError returns the class of the error of a remote operation.
+func classifyError(err error) string {
+	if errors.Is(err, transport.ErrAuthenticationRequired) ||
+		errors.Is(err, transport.ErrAuthorizationFailed) ||
+		errors.Is(err, transport.ErrInvalidAuthMethod) {
+		return errClassAuth
+	}
+
+	if status, ok := httpStatus(err); ok {
+		if status >= http.StatusInternalServerError || status == http.StatusTooManyRequests ||
+			status == http.StatusRequestTimeout {
+			return errClassTransient
+		}
+		return errClassOther
+	}
+
+	var errNet net.Error
+	if errors.As(err, &errNet) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, syscall.ETIMEDOUT) {
+		return errClassTransient
+	}
+
+	return errClassOther
+}
+
+// httpStatus returns the status code of the response of a failed smart HTTP request. go-git
+// returns such failures as a *plumbing.UnexpectedError, which doesn't unwrap to the cause.
+func httpStatus(err error) (int, bool) {
+	var errUnexpected *plumbing.UnexpectedError
+	if errors.As(err, &errUnexpected) {
+		err = errUnexpected.Err
+	}
+
+	var errHTTP *githttp.Err
+	if errors.As(err, &errHTTP) && errHTTP.Response != nil {
+		return errHTTP.Response.StatusCode, true
+	}
+
+	return 0, false
+}
+
+// breakerSet is the set of circuit breakers keyed by remote URL.
+type breakerSet struct {
+	mu       sync.Mutex
+	cfg      conf.BreakerConfig
+	breakers map[string]*breaker
+}
+
+func (bs *breakerSet) configure(bc conf.BreakerConfig) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	bs.cfg = bc
+}
+
+func (bs *breakerSet) get(remote string) *breaker {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	b, ok := bs.breakers[remote]
+	if !ok {
+		b = &breaker{set: bs, remote: remote}
+		bs.breakers[remote] = b
+	}
+
+	return b
+}
+
+func (bs *breakerSet) config() conf.BreakerConfig {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+	return bs.cfg
+}
+
+func (bs *breakerSet) states() []BreakerState {
+	bs.mu.Lock()
+	list := make([]*breaker, 0, len(bs.breakers))
+	for _, b := range bs.breakers {
+		list = append(list, b)
+	}
+	bs.mu.Unlock()
+
+	var states []BreakerState
+	for _, b := range list {
+		if s := b.state(); s.Failures > 0 {
+			states = append(states, s)
+		}
+	}
+
+	sort.Slice(states, func(i, j int) bool { return states[i].Remote < states[j].Remote })
+	return states
+}
+
+// breaker is the circuit breaker of a remote. Only failures that indicate
+// a problem with the remote itself, i.e. transient and auth errors, count.
+type breaker struct {
+	set    *breakerSet
+	remote string
+
+	mu        sync.Mutex
+	failures  int
+	opened    int
+	lastError error
+	openUntil time.Time
+}
+
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if time.Now().Before(b.openUntil) {
+		return &ErrCircuitOpen{Remote: b.remote, OpenUntil: b.openUntil}
+	}
+
+	return nil
+}
+
+func (b *breaker) record(err error) {
+	bc := b.set.config()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if err == nil {
+		if b.opened > 0 {
+			slog.Info("circuit closed", "url", b.remote)
+		}
+		b.failures, b.opened, b.lastError, b.openUntil = 0, 0, nil, time.Time{}
+		return
+	}
+
+	if class := classifyError(err); class != errClassTransient && class != errClassAuth {
+		return
+	}
+
+	b.failures++
+	b.lastError = err
+	if b.failures < bc.FailureLimit() {
+		return
+	}
+
+	cooldown := bc.InitialCooldown()
+	for i := 0; i < b.opened && cooldown < bc.CooldownLimit(); i++ {
+		cooldown *= 2
+	}
+	cooldown = min(cooldown, bc.CooldownLimit())
+
+	b.opened++
+	b.openUntil = time.Now().Add(cooldown)
+	slog.Warn("circuit opened", "url", b.remote, "failures", b.failures, "cooldown", cooldown, "err", err)
+}
+
+func (b *breaker) state() BreakerState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := BreakerState{Remote: b.remote, Failures: b.failures, OpenUntil: b.openUntil}
+	if b.lastError != nil {
+		s.LastError = b.lastError.Error()
+	}
+
+	return s
+}
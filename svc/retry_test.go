@@ -0,0 +1,158 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/go-git/go-git/v5/storage/memory"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// statusServer returns a server that answers the requests with the statuses in turn, the
+// last one repeated, and the number of requests it has answered so far.
+func statusServer(t *testing.T, statuses ...int) (*httptest.Server, *atomic.Int32) {
+	requests := new(atomic.Int32)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(requests.Add(1)) - 1
+		w.WriteHeader(statuses[min(i, len(statuses)-1)])
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+// fetchServer fetches the repo at the URL of the server into an empty repo.
+func fetchServer(ctx context.Context, t *testing.T, server *httptest.Server) error {
+	repo, err := git.Init(memory.NewStorage(), nil)
+	if err != nil {
+		t.Fatalf("error creating repo :: %v", err)
+	}
+	remote, err := createRemote(repo, "origin", server.URL+"/paper.git")
+	if err != nil {
+		t.Fatalf("error creating remote :: %v", err)
+	}
+	return fetch(ctx, remote, nil)
+}
+
+// httpErr returns the error of fetching from a server that answers with the status.
+func httpErr(t *testing.T, status int) error {
+	server, _ := statusServer(t, status)
+	return fetchServer(withRetry(context.Background(), conf.RetryConfig{Attempts: 1}), t, server)
+}
+
+func TestClassifyError(t *testing.T) {
+	tests := []struct {
+		err   error
+		class string
+	}{
+		{httpErr(t, http.StatusBadGateway), errClassTransient},
+		{httpErr(t, http.StatusTooManyRequests), errClassTransient},
+		{httpErr(t, http.StatusBadRequest), errClassOther},
+		{httpErr(t, http.StatusUnauthorized), errClassAuth},
+		{fmt.Errorf("push :: %w", transport.ErrAuthorizationFailed), errClassAuth},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, errClassTransient},
+		{errors.New("non-fast-forward update"), errClassOther},
+	}
+	for _, tc := range tests {
+		if class := classifyError(tc.err); class != tc.class {
+			t.Fatalf("unexpected class for %v: %v", tc.err, class)
+		}
+	}
+}
+
+func TestRetryDelay(t *testing.T) {
+	var rc conf.RetryConfig
+	rc.Backoff.Duration = time.Second
+	rc.MaxBackoff.Duration = 5 * time.Second
+	for attempt, expected := range []time.Duration{time.Second, 2 * time.Second, 4 * time.Second, 5 * time.Second} {
+		if delay := retryDelay(rc, attempt+1); delay != expected {
+			t.Fatalf("unexpected delay for attempt %v: %v", attempt+1, delay)
+		}
+	}
+
+	rc.Jitter = 0.5
+	for i := 0; i < 100; i++ {
+		if delay := retryDelay(rc, 1); delay < 500*time.Millisecond || delay > 1500*time.Millisecond {
+			t.Fatalf("delay out of jitter range: %v", delay)
+		}
+	}
+}
+
+func TestWithRemote(t *testing.T) {
+	var rc conf.RetryConfig
+	rc.Attempts = 3
+	rc.Backoff.Duration = time.Millisecond
+	ctx := withRetry(context.Background(), rc)
+
+	// transient errors are retried, and count towards the breaker once the attempts run out
+	var bc conf.BreakerConfig
+	bc.Failures = 2
+	bc.Cooldown.Duration = time.Hour
+	breakers.configure(bc)
+	defer breakers.configure(conf.BreakerConfig{})
+	server, requests := statusServer(t, http.StatusServiceUnavailable, http.StatusTooManyRequests)
+	for i := 1; i <= 2; i++ {
+		err := fetchServer(ctx, t, server)
+		if classifyError(err) != errClassTransient || requests.Load() != int32(3*i) {
+			t.Fatalf("expected %v attempts, got %v :: %v", 3*i, requests.Load(), err)
+		}
+	}
+	var errOpen *ErrCircuitOpen
+	if err := fetchServer(ctx, t, server); !errors.As(err, &errOpen) || requests.Load() != 6 {
+		t.Fatalf("expected open circuit after 6 requests, got %v :: %v", requests.Load(), err)
+	}
+
+	// the operation succeeds once the remote recovers
+	calls := 0
+	err := withRemote(ctx, "https://retry.example.com/a", func() error {
+		calls++
+		if calls < 3 {
+			return httpErr(t, http.StatusServiceUnavailable)
+		}
+		return nil
+	})
+	if err != nil || calls != 3 {
+		t.Fatalf("expected success after 3 calls, got %v calls :: %v", calls, err)
+	}
+
+	// other errors are not retried
+	calls = 0
+	err = withRemote(ctx, "https://retry.example.com/b", func() error {
+		calls++
+		return errors.New("non-fast-forward update")
+	})
+	if err == nil || calls != 1 {
+		t.Fatalf("expected a single failed call, got %v calls :: %v", calls, err)
+	}
+
+	// repeated auth failures open the circuit
+	remote := "https://breaker.example.com/a"
+	calls = 0
+	for i := 0; i < 3; i++ {
+		err = withRemote(ctx, remote, func() error {
+			calls++
+			return transport.ErrAuthenticationRequired
+		})
+	}
+	if !errors.As(err, &errOpen) || calls != 2 {
+		t.Fatalf("expected open circuit after 2 calls, got %v calls :: %v", calls, err)
+	}
+
+	found := false
+	for _, s := range BreakerStates() {
+		if s.Remote == remote {
+			found = s.Failures == 2 && s.OpenUntil.After(time.Now())
+		}
+	}
+	if !found {
+		t.Fatalf("open breaker not reported: %+v", BreakerStates())
+	}
+}
@@ -0,0 +1,392 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+	"github.com/robfig/cron/v3"
+)
+
+// schedule returns the next time a sync needs to run after the given time.
+type schedule interface {
+	Next(time.Time) time.Time
+}
+
+// every is a schedule that runs at a fixed period.
+type every time.Duration
+
+func (e every) Next(t time.Time) time.Time {
+	return t.Add(time.Duration(e))
+}
+
+// syncSchedule returns the schedule of the sync. The cron expression of the sync
+// takes precedence over its period, and the global period is used if neither is set.
+func syncSchedule(sc conf.SyncConfig, globalPeriod time.Duration) (schedule, error) {
+	if sc.Cron != "" {
+		s, err := cron.ParseStandard(sc.Cron)
+		if err != nil {
+			return nil, fmt.Errorf("error parsing cron [%v] :: %w", sc.Cron, err)
+		}
+		return s, nil
+	}
+
+	period := sc.Period.Duration
+	if period == 0 {
+		period = globalPeriod
+	}
+	if period <= 0 {
+		return nil, fmt.Errorf("invalid period [%v]", period)
+	}
+
+	return every(period), nil
+}
+
+// cMaxQueuedTriggers is the number of "sync now" requests that can wait for a sync.
+const cMaxQueuedTriggers = 4
+
+// ErrUnknownSync is returned when a sync that isn't in the config is referred to.
+type ErrUnknownSync struct {
+	Name string
+}
+
+func (e *ErrUnknownSync) Error() string {
+	return fmt.Sprintf("sync [%v] not found", e.Name)
+}
+
+// ErrInvalidSyncName is returned when a sync is referred to by a name that no sync may have.
+type ErrInvalidSyncName struct {
+	Name string
+}
+
+func (e *ErrInvalidSyncName) Error() string {
+	return fmt.Sprintf("invalid sync name [%v]", e.Name)
+}
+
+// scheduler runs each sync of a config on its own timer,
+// bounded by the concurrency limits of the config.
+type scheduler struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	cf      *conf.Config
+	runCtx  context.Context
+	limiter *limiter
+	syncFn  func(context.Context, conf.SyncConfig, map[string]*conf.AuthMethod) error
+	store   *statusStore
+	notify  *notifyHub
+
+	// mu guards the fields below, that are also accessed by the control API.
+	mu        sync.Mutex
+	syncs     map[string]*syncTimer
+	paused    map[string]bool
+	pausedAll bool
+}
+
+// syncTimer is the timer of one sync, along with the config it was started with.
+type syncTimer struct {
+	sc      conf.SyncConfig
+	auth    map[string]*conf.AuthMethod
+	quit    chan struct{}
+	trigger chan chan error
+}
+
+func newScheduler(store *statusStore) *scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	nh := newNotifyHub()
+	store.onEvent = nh.publish
+	return &scheduler{
+		ctx:    ctx,
+		cancel: cancel,
+		syncFn: syncOne,
+		store:  store,
+		notify: nh,
+		syncs:  make(map[string]*syncTimer),
+		paused: make(map[string]bool),
+	}
+}
+
+// apply updates the running timers to match the config. Timers of removed or
+// changed syncs are stopped and timers of new or changed syncs are started.
+// A sync that is in-flight completes with the config it was started with.
+// All the timers are restarted if the global settings have changed.
+func (s *scheduler) apply(cf *conf.Config) {
+	restartAll := s.cf == nil || s.cf.Period != cf.Period ||
+		s.cf.Concurrency != cf.Concurrency || s.cf.Retry != cf.Retry || s.cf.Breaker != cf.Breaker
+	if restartAll {
+		breakers.configure(cf.Breaker)
+		s.limiter = newLimiter(cf.Concurrency)
+		s.runCtx = withRetry(withLimiter(s.ctx, s.limiter), cf.Retry)
+	}
+	s.notify.configure(cf.Notify)
+
+	wanted := make(map[string]conf.SyncConfig)
+	for _, sc := range cf.Sync {
+		wanted[sc.Name] = sc
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for name, st := range s.syncs {
+		sc, ok := wanted[name]
+		switch {
+		case !ok:
+			slog.Info("sync removed, stopping its timer", "sync", name)
+			s.store.remove(name)
+			delete(s.paused, name)
+		case restartAll:
+		case !reflect.DeepEqual(st.sc, sc) || !reflect.DeepEqual(st.auth, syncAuth(sc, cf.Auth)):
+			slog.Info("sync changed, rescheduling it", "sync", name)
+		default:
+			continue
+		}
+
+		close(st.quit)
+		delete(s.syncs, name)
+	}
+
+	for _, sc := range cf.Sync {
+		if _, ok := s.syncs[sc.Name]; ok {
+			continue
+		}
+
+		sched, err := syncSchedule(sc, cf.Period.Duration)
+		if err != nil {
+			slog.Error("not scheduling sync", "sync", sc.Name, "err", err)
+			continue
+		}
+
+		st := &syncTimer{
+			sc:      sc,
+			auth:    syncAuth(sc, cf.Auth),
+			quit:    make(chan struct{}),
+			trigger: make(chan chan error, cMaxQueuedTriggers),
+		}
+		s.syncs[sc.Name] = st
+		s.store.add(sc.Name)
+		s.wg.Add(1)
+		go s.runSync(s.runCtx, s.limiter, st, sched)
+	}
+
+	s.cf = cf
+}
+
+// stop stops all the timers and waits for in-flight syncs to abort.
+func (s *scheduler) stop() {
+	s.cancel()
+	s.wg.Wait()
+	s.notify.stop()
+}
+
+func (s *scheduler) runSync(ctx context.Context, l *limiter, st *syncTimer, sched schedule) {
+	defer s.wg.Done()
+	defer drainTriggers(st)
+
+	for {
+		next := sched.Next(time.Now())
+		s.store.update(st.sc.Name, func(ss *SyncStatus) { ss.NextRun = next })
+
+		// done is set when the sync is triggered through the control API
+		var done chan error
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-st.quit:
+			timer.Stop()
+			return
+		case done = <-st.trigger:
+			timer.Stop()
+		case <-timer.C:
+		}
+
+		if done == nil {
+			if s.isPaused(st.sc.Name) {
+				slog.Info("skipping sync as it is paused", "sync", st.sc.Name)
+				continue
+			}
+			if !st.sc.ActiveHours.Contains(time.Now()) {
+				slog.Info("skipping sync outside of active hours", "sync", st.sc.Name,
+					"active_hours", st.sc.ActiveHours)
+				continue
+			}
+		}
+
+		release, err := l.acquireSync(ctx)
+		if err != nil {
+			reply(done, err)
+			return
+		}
+
+		trigger := TriggerSchedule
+		if done != nil {
+			trigger = TriggerManual
+		}
+		err = s.store.run(ctx, st.sc.Name, trigger, func(ctx context.Context) error {
+			return s.syncFn(ctx, st.sc, st.auth)
+		})
+		release()
+		reply(done, err)
+	}
+}
+
+// trigger requests the syncs with the given names, or all the syncs if no name is
+// given, to run right away. The returned channels receive the result of each sync.
+func (s *scheduler) trigger(names []string) (map[string]chan error, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkNames(names); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		for name := range s.syncs {
+			names = append(names, name)
+		}
+	}
+
+	results := make(map[string]chan error, len(names))
+	for _, name := range names {
+		done := make(chan error, 1)
+		select {
+		case s.syncs[name].trigger <- done:
+		default:
+			done <- fmt.Errorf("too many sync requests queued for %v", name)
+		}
+		results[name] = done
+	}
+
+	return results, nil
+}
+
+// setPaused pauses or resumes the syncs with the given names, or all the syncs if no
+// name is given. A paused sync is skipped by its timer but can still be triggered.
+func (s *scheduler) setPaused(names []string, paused bool) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkNames(names); err != nil {
+		return err
+	}
+
+	if len(names) == 0 {
+		s.pausedAll = paused
+		s.paused = make(map[string]bool)
+		return nil
+	}
+	for _, name := range names {
+		s.paused[name] = paused
+	}
+
+	return nil
+}
+
+// purge deletes the local clone of the sync, once the sync that may be running is done with it.
+// The sync is paused first so that it doesn't clone again before it is removed from the config.
+func (s *scheduler) purge(ctx context.Context, name string) error {
+	// the name comes from the control API, it must not lead out of the folder of the repos
+	folder := conf.GetSyncTarget(name)
+	if !conf.ValidSyncName(name) || filepath.Dir(folder) != filepath.Clean(conf.ReposFolder()) {
+		return &ErrInvalidSyncName{Name: name}
+	}
+
+	s.mu.Lock()
+	if _, ok := s.syncs[name]; ok {
+		s.paused[name] = true
+	}
+	s.mu.Unlock()
+
+	unlock, err := folderLocks.lock(ctx, folder)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	if err := os.RemoveAll(folder); err != nil {
+		return fmt.Errorf("error deleting local clone :: %w", err)
+	}
+
+	slog.Info("deleted local clone", "sync", name, "folder", folder)
+	return nil
+}
+
+// statuses returns the status of all the syncs sorted by name.
+func (s *scheduler) statuses() []SyncStatus {
+	list := s.store.list()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for i := range list {
+		list[i].Paused = s.isPausedLocked(list[i].Name)
+		if st, ok := s.syncs[list[i].Name]; ok {
+			list[i].From, list[i].Sources, list[i].To = st.sc.From, st.sc.Sources, st.sc.ToList
+		}
+	}
+
+	return list
+}
+
+func (s *scheduler) checkNames(names []string) error {
+	for _, name := range names {
+		if _, ok := s.syncs[name]; !ok {
+			return &ErrUnknownSync{Name: name}
+		}
+	}
+
+	return nil
+}
+
+func (s *scheduler) isPaused(name string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.isPausedLocked(name)
+}
+
+// isPausedLocked returns whether the sync is paused, the
+// pause of a single sync takes precedence over pausing all.
+func (s *scheduler) isPausedLocked(name string) bool {
+	if paused, ok := s.paused[name]; ok {
+		return paused
+	}
+
+	return s.pausedAll
+}
+
+// drainTriggers fails the "sync now" requests that are still queued for a stopped timer.
+func drainTriggers(st *syncTimer) {
+	for {
+		select {
+		case done := <-st.trigger:
+			reply(done, fmt.Errorf("sync %v was stopped", st.sc.Name))
+		default:
+			return
+		}
+	}
+}
+
+func reply(done chan error, err error) {
+	if done != nil {
+		done <- err
+	}
+}
+
+// syncAuth returns the auth methods that are used by the sync.
+func syncAuth(sc conf.SyncConfig, authMap map[string]*conf.AuthMethod) map[string]*conf.AuthMethod {
+	auth := make(map[string]*conf.AuthMethod)
+	for _, r := range append(sc.FromRepos(), sc.ToList...) {
+		if am, ok := authMap[r.AuthToUse]; ok {
+			auth[r.AuthToUse] = am
+		}
+	}
+
+	return auth
+}
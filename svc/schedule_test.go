@@ -0,0 +1,93 @@
+package svc
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestSyncSchedule(t *testing.T) {
+	now := time.Date(2020, 5, 1, 10, 30, 0, 0, time.Local)
+
+	var sc conf.SyncConfig
+	s, err := syncSchedule(sc, time.Hour)
+	if err != nil {
+		t.Fatalf("error creating schedule :: %v", err)
+	}
+	if next := s.Next(now); next != now.Add(time.Hour) {
+		t.Fatalf("global period wasn't used: %v", next)
+	}
+
+	sc.Period.Duration = time.Minute
+	if s, err = syncSchedule(sc, time.Hour); err != nil {
+		t.Fatalf("error creating schedule :: %v", err)
+	}
+	if next := s.Next(now); next != now.Add(time.Minute) {
+		t.Fatalf("sync period wasn't used: %v", next)
+	}
+
+	sc.Cron = "0 2 * * *"
+	if s, err = syncSchedule(sc, time.Hour); err != nil {
+		t.Fatalf("error creating schedule :: %v", err)
+	}
+	if next := s.Next(now); next != time.Date(2020, 5, 2, 2, 0, 0, 0, time.Local) {
+		t.Fatalf("cron wasn't used: %v", next)
+	}
+
+	if _, err := syncSchedule(conf.SyncConfig{Cron: "every day"}, time.Hour); err == nil {
+		t.Fatal("expected error for invalid cron")
+	}
+	if _, err := syncSchedule(conf.SyncConfig{}, 0); err == nil {
+		t.Fatal("expected error for zero period")
+	}
+}
+
+func TestSchedulerApply(t *testing.T) {
+	s := newScheduler(testStatusStore(t))
+	defer s.stop()
+
+	newConfig := func(syncs ...conf.SyncConfig) *conf.Config {
+		cf := &conf.Config{Sync: syncs}
+		cf.Period.Duration = time.Hour
+		return cf
+	}
+	a := conf.SyncConfig{Name: "a", From: conf.Repo{URLToRepo: "file:///a"}}
+	b := conf.SyncConfig{Name: "b", From: conf.Repo{URLToRepo: "file:///b"}}
+	c := conf.SyncConfig{Name: "c", From: conf.Repo{URLToRepo: "file:///c"}}
+
+	s.apply(newConfig(a, b))
+	timerA, timerB := s.syncs["a"], s.syncs["b"]
+	if len(s.syncs) != 2 || timerA == nil || timerB == nil {
+		t.Fatalf("unexpected timers: %v", s.syncs)
+	}
+
+	// unchanged syncs keep their timers
+	s.apply(newConfig(a, b))
+	if s.syncs["a"] != timerA || s.syncs["b"] != timerB {
+		t.Fatal("unchanged syncs were rescheduled")
+	}
+
+	// a is changed, b is removed and c is added
+	a.Period.Duration = time.Minute
+	s.apply(newConfig(a, c))
+	if len(s.syncs) != 2 || s.syncs["a"] == timerA || s.syncs["c"] == nil {
+		t.Fatalf("unexpected timers: %v", s.syncs)
+	}
+	for _, st := range []*syncTimer{timerA, timerB} {
+		select {
+		case <-st.quit:
+		default:
+			t.Fatalf("timer of %v wasn't stopped", st.sc.Name)
+		}
+	}
+
+	// changing global settings restarts every timer
+	timerC := s.syncs["c"]
+	cf := newConfig(a, c)
+	cf.Concurrency.MaxSyncs = 1
+	s.apply(cf)
+	if s.syncs["c"] == timerC {
+		t.Fatal("timers weren't restarted on global change")
+	}
+}
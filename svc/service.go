@@ -2,8 +2,10 @@ package svc
 
 import (
 	"context"
-	"log"
-	"time"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sync"
 
 	"github.com/mangalaman93/giggle/conf"
 )
@@ -16,7 +18,7 @@ type Service struct {
 
 // Start starts the service.
 func Start() *Service {
-	log.Println("[INFO] starting giggle service")
+	slog.Info("starting giggle service")
 
 	gs := &Service{
 		quit: make(chan struct{}),
@@ -28,33 +30,136 @@ func Start() *Service {
 
 // Stop stops the service.
 func (gs *Service) Stop() error {
-	log.Println("[INFO] stopping giggle service")
+	slog.Info("stopping giggle service")
 	close(gs.quit)
 	<-gs.done
-	log.Println("[INFO] stopped giggle service")
+	slog.Info("stopped giggle service")
 	return nil
 }
 
 func (gs *Service) run() {
 	defer close(gs.done)
 
-	for {
-		cf, err := conf.ReadConfig(conf.SettingsFilePath())
-		if err != nil {
-			log.Printf("[ERROR] error in reading config file :: %v\n", err)
-			log.Println("sleeping for a minute...")
-			time.Sleep(time.Minute)
-			continue
+	sched := newScheduler(openStatusStore(conf.StatusFilePath(), conf.HistoryFilePath()))
+	defer sched.stop()
+
+	cw := watchConfig(conf.SettingsFilePath())
+	defer cw.stop()
+
+	// reloads requested through the control API are done by this loop, as is applying the config
+	reloads := make(chan chan error)
+	requestReload := func(ctx context.Context) error {
+		errc := make(chan error, 1)
+		select {
+		case reloads <- errc:
+		case <-ctx.Done():
+			return ctx.Err()
 		}
+		return <-errc
+	}
 
+	as, err := serveAPI(conf.SocketFilePath(), sched, requestReload)
+	if err != nil {
+		slog.Warn("running without control API", "err", err)
+	} else {
+		defer as.stop()
+	}
+
+	ms := &metricsServer{}
+	defer ms.stop()
+
+	_ = reload(sched, ms)
+	for {
 		select {
 		case <-gs.quit:
-			log.Println("[INFO] exiting service loop")
+			slog.Info("exiting service loop")
 			return
-		case <-time.After(cf.Period.Duration):
-			if err := performSync(context.Background(), cf); err != nil {
-				log.Printf("[ERROR] error syncing :: %v\n", err)
+		case <-cw.changed:
+			_ = reload(sched, ms)
+		case errc := <-reloads:
+			errc <- reload(sched, ms)
+		}
+	}
+}
+
+// reload reads and validates the config file, and applies it to the scheduler and to
+// the metrics server. The last good config keeps running if the new config is invalid.
+func reload(sched *scheduler, ms *metricsServer) error {
+	cf, err := conf.ReadConfig(conf.SettingsFilePath())
+	if err == nil {
+		err = cf.Validate()
+	}
+	if err != nil {
+		slog.Error("error in reading config file, keeping the last good config", "err", err)
+		return err
+	}
+
+	conf.SetLogLevel(cf.Logging)
+	sched.apply(cf)
+	ms.configure(cf.Metrics)
+	return nil
+}
+
+// SyncNow runs the syncs with the given names once, or all the syncs if no name
+// is given, and waits for them to finish. It returns the errors of all the syncs.
+// The runs are recorded in the status and the history of the syncs.
+func SyncNow(ctx context.Context, cf *conf.Config, names ...string) error {
+	syncs, err := selectSyncs(cf, names)
+	if err != nil {
+		return err
+	}
+
+	store := openStatusStore(conf.StatusFilePath(), conf.HistoryFilePath())
+	breakers.configure(cf.Breaker)
+	l := newLimiter(cf.Concurrency)
+	ctx = withRetry(withLimiter(ctx, l), cf.Retry)
+
+	var wg sync.WaitGroup
+	errs := make([]error, len(syncs))
+	for i, sc := range syncs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			release, err := l.acquireSync(ctx)
+			if err != nil {
+				errs[i] = err
+				return
+			}
+			defer release()
+
+			err = store.run(ctx, sc.Name, TriggerManual, func(ctx context.Context) error {
+				return syncOne(ctx, sc, syncAuth(sc, cf.Auth))
+			})
+			if err != nil {
+				errs[i] = fmt.Errorf("error syncing %v :: %w", sc.Name, err)
 			}
+		}()
+	}
+	wg.Wait()
+
+	return errors.Join(errs...)
+}
+
+// selectSyncs returns the syncs of the config with the given names, or all if no name is given.
+func selectSyncs(cf *conf.Config, names []string) ([]conf.SyncConfig, error) {
+	if len(names) == 0 {
+		return cf.Sync, nil
+	}
+
+	byName := make(map[string]conf.SyncConfig)
+	for _, sc := range cf.Sync {
+		byName[sc.Name] = sc
+	}
+
+	syncs := make([]conf.SyncConfig, 0, len(names))
+	for _, name := range names {
+		sc, ok := byName[name]
+		if !ok {
+			return nil, fmt.Errorf("sync [%v] not found in config", name)
 		}
+		syncs = append(syncs, sc)
 	}
+
+	return syncs, nil
 }
@@ -0,0 +1,214 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// syncSources syncs the repos of a sync config with sources, see conf.Source, using the local
+// clone in `repoFolder`. The sources are remotes of the local clone, which has a branch for each
+// branch of the `to` repos that sources are combined into.
+func syncSources(ctx context.Context, repoFolder string, sc conf.SyncConfig,
+	authMap map[string]*conf.AuthMethod) error {
+
+	_, donePhase := startPhase(ctx, sc.Name, phaseOpen)
+	repo, err := initRepo(repoFolder)
+	donePhase()
+	if err != nil {
+		return err
+	}
+
+	fetchCtx, donePhase := startPhase(ctx, sc.Name, phaseFetch)
+	heads, err := fetchSources(fetchCtx, repo, sc.Sources, authMap)
+	donePhase()
+	if err != nil {
+		return err
+	}
+	ctx, donePhase = startPhase(ctx, sc.Name, phasePush)
+	defer donePhase()
+
+	// the heads of the sources are reported as <source>/<branch>
+	if report := reportFrom(ctx); report != nil {
+		defer func() {
+			named := make(map[string]plumbing.ReferenceName)
+			for _, head := range heads {
+				named[head.Short()] = head
+			}
+			report.resolveHeads(repo, named)
+		}()
+	}
+
+	var refSpecs []config.RefSpec
+	var branches []string
+	combined := make(map[string][]int)
+	for i, src := range sc.Sources {
+		branch := src.TargetBranch()
+		if src.Path == "" {
+			refSpecs = append(refSpecs, refSpec(heads[i], branch))
+			continue
+		}
+		if _, ok := combined[branch]; !ok {
+			branches = append(branches, branch)
+		}
+		combined[branch] = append(combined[branch], i)
+	}
+	for _, branch := range branches {
+		if err := combineSources(ctx, repo, sc, branch, combined[branch], heads, authMap); err != nil {
+			return fmt.Errorf("error combining sources into [%v] :: %w", branch, err)
+		}
+		refSpecs = append(refSpecs, refSpec(plumbing.NewBranchReferenceName(branch), branch))
+	}
+
+	return pushTargets(ctx, repo, repoFolder, sc, sc.Name, refSpecs, nil, authMap)
+}
+
+// initRepo opens the git repo in the folder, or creates an empty one if it doesn't exist.
+func initRepo(folder string) (*git.Repository, error) {
+	repo, err := git.PlainOpen(folder)
+	if errors.Is(err, git.ErrRepositoryNotExists) {
+		repo, err = git.PlainInit(folder, false)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening the repo [%v] :: %w", folder, err)
+	}
+
+	return repo, nil
+}
+
+// fetchSources fetches the sources, and returns the remote
+// references of their default branches in the same order.
+func fetchSources(ctx context.Context, repo *git.Repository, sources []conf.Source,
+	authMap map[string]*conf.AuthMethod) ([]plumbing.ReferenceName, error) {
+
+	heads := make([]plumbing.ReferenceName, len(sources))
+	for i, src := range sources {
+		auth := repoAuth(src.Repo, authMap[src.AuthToUse])
+		remote, err := createRemote(repo, src.Name, repoURL(src.Repo))
+		if err != nil {
+			return nil, err
+		}
+		if err := fetch(ctx, remote, auth); err != nil {
+			return nil, err
+		}
+
+		branch, err := providerFor(src.Repo).defaultBranch(ctx, remote, auth)
+		if err != nil {
+			return nil, err
+		}
+		heads[i] = plumbing.NewRemoteReferenceName(src.Name, branch)
+	}
+
+	return heads, nil
+}
+
+// combineSources brings the files of the sources into their folders of the branch of the local
+// clone. A commit is added for each source whose files changed, with the head of the source as
+// its second parent so that the combined history includes the history of the source.
+func combineSources(ctx context.Context, repo *git.Repository, sc conf.SyncConfig, branch string,
+	sources []int, heads []plumbing.ReferenceName, authMap map[string]*conf.AuthMethod) error {
+
+	parent, err := combinedHead(ctx, repo, sc, branch, authMap)
+	if err != nil {
+		return err
+	}
+
+	for _, i := range sources {
+		src := sc.Sources[i]
+		head, err := refCommit(repo, heads[i])
+		if err != nil {
+			return err
+		}
+
+		files := make(map[string]treeFile)
+		if parent != nil {
+			if files, err = commitFiles(parent); err != nil {
+				return err
+			}
+		}
+		for p := range files {
+			if p == src.Path || strings.HasPrefix(p, src.Path+"/") {
+				delete(files, p)
+			}
+		}
+		srcFiles, err := commitFiles(head)
+		if err != nil {
+			return err
+		}
+		for p, f := range srcFiles {
+			files[src.Path+"/"+p] = f
+		}
+
+		treeHash, err := writeTree(repo.Storer, files)
+		if err != nil {
+			return err
+		}
+		if parent != nil && treeHash == parent.TreeHash {
+			continue
+		}
+
+		parents := []plumbing.Hash{head.Hash}
+		if parent != nil {
+			parents = []plumbing.Hash{parent.Hash, head.Hash}
+		}
+		message := fmt.Sprintf("Sync %v into %v\n\nFrom %v of %v.\n", src.Name, src.Path,
+			head.Hash, heads[i].Short())
+		hash, err := writeCommit(repo.Storer, treeHash, parents, message)
+		if err != nil {
+			return err
+		}
+		if err := setRef(repo, plumbing.NewBranchReferenceName(branch), hash); err != nil {
+			return err
+		}
+		if parent, err = repo.CommitObject(hash); err != nil {
+			return fmt.Errorf("error finding commit [%v] :: %w", hash, err)
+		}
+
+		loggerFrom(ctx).Info("combined source", "branch", branch, "source", src.Name, "commit", hash)
+	}
+
+	return nil
+}
+
+// combinedHead returns the head of the branch that sources are combined into, or nil if the
+// branch is yet to be started. A branch that isn't in the local clone, e.g. as the clone was
+// removed, is continued from the branch of the first `to` repo so that its history is kept.
+func combinedHead(ctx context.Context, repo *git.Repository, sc conf.SyncConfig, branch string,
+	authMap map[string]*conf.AuthMethod) (*object.Commit, error) {
+
+	c, err := refCommit(repo, plumbing.NewBranchReferenceName(branch))
+	if err == nil || !errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return c, err
+	}
+
+	to := sc.ToList[0]
+	toAuth := repoAuth(to, authMap[to.AuthToUse])
+	remote, err := createRemote(repo, to.Name, repoURL(to))
+	if err != nil {
+		return nil, err
+	}
+	if err := ensureTarget(ctx, to, toAuth); err != nil {
+		return nil, err
+	}
+	if err := fetch(ctx, remote, toAuth); err != nil && !errors.Is(err, transport.ErrEmptyRemoteRepository) {
+		return nil, err
+	}
+
+	c, err = refCommit(repo, plumbing.NewRemoteReferenceName(to.Name, branch))
+	if errors.Is(err, plumbing.ErrReferenceNotFound) {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	loggerFrom(ctx).Info("continuing branch of target", "branch", branch, "remote", to.Name)
+	return c, nil
+}
@@ -0,0 +1,96 @@
+package svc
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestSyncSources(t *testing.T) {
+	sourceDirs := make(map[string]string)
+	sourceRepos := make(map[string]*git.Repository)
+	for _, name := range []string{"intro", "results", "slides"} {
+		dir := filepath.Join(t.TempDir(), name)
+		createTestDir(t, dir)
+		repo, err := setupGitRepo(dir)
+		if err != nil {
+			t.Fatalf("error setting up %v :: %v", name, err)
+		}
+		sourceDirs[name], sourceRepos[name] = dir, repo
+	}
+	toDir, toRepo := setupSide(t)
+	defer deleteTestDir(t, toDir)
+
+	source := func(name, path, branch string) conf.Source {
+		return conf.Source{
+			Repo:   conf.Repo{Name: name, URLToRepo: fmt.Sprintf("file://%v", sourceDirs[name])},
+			Path:   path,
+			Branch: branch,
+		}
+	}
+	sc := conf.SyncConfig{
+		Name: "thesis",
+		Sources: []conf.Source{
+			source("intro", "chapters/intro", ""),
+			source("results", "chapters/results", ""),
+			source("slides", "", "slides"),
+		},
+		ToList: []conf.Repo{{Name: "github", URLToRepo: fmt.Sprintf("file://%v", toDir)}},
+	}
+	repoFolder := filepath.Join(t.TempDir(), sc.Name)
+	syncOnce := func() *object.Commit {
+		ctx := withLimiter(context.Background(), newLimiter(conf.ConcurrencyConfig{}))
+		if err := syncFolder(ctx, repoFolder, sc, nil); err != nil {
+			t.Fatalf("error syncing :: %v", err)
+		}
+		c, err := refCommit(toRepo, plumbing.NewBranchReferenceName("main"))
+		if err != nil {
+			t.Fatalf("error finding combined branch :: %v", err)
+		}
+		return c
+	}
+
+	combined := syncOnce()
+	files, err := commitFiles(combined)
+	if err != nil {
+		t.Fatalf("error listing files :: %v", err)
+	}
+	if _, ok := files["chapters/intro/README.md"]; !ok || len(files) != 2 {
+		t.Fatalf("unexpected files of combined branch: %v", files)
+	}
+	if len(combined.ParentHashes) != 2 || combined.ParentHashes[1] != masterHead(t, sourceRepos["results"]) {
+		t.Fatalf("history of source not kept: %v", combined.ParentHashes)
+	}
+	slides, err := refCommit(toRepo, plumbing.NewBranchReferenceName("slides"))
+	if err != nil || slides.Hash != masterHead(t, sourceRepos["slides"]) {
+		t.Fatalf("source not synced to its own branch: %v", err)
+	}
+
+	// nothing changed, hence nothing is committed
+	if c := syncOnce(); c.Hash != combined.Hash {
+		t.Fatalf("combined branch changed without changes to the sources: %v", c.Hash)
+	}
+
+	if err := createFile(filepath.Join(sourceDirs["intro"], "intro.tex"), "intro"); err != nil {
+		t.Fatalf("error creating file :: %v", err)
+	}
+	if err := commit(sourceRepos["intro"], "Add intro.tex"); err != nil {
+		t.Fatalf("error committing :: %v", err)
+	}
+	c := syncOnce()
+	if c.ParentHashes[0] != combined.Hash || c.ParentHashes[1] != masterHead(t, sourceRepos["intro"]) {
+		t.Fatalf("unexpected parents of combined commit: %v", c.ParentHashes)
+	}
+	if files, err = commitFiles(c); err != nil {
+		t.Fatalf("error listing files :: %v", err)
+	}
+	if _, ok := files["chapters/intro/intro.tex"]; !ok || len(files) != 3 {
+		t.Fatalf("unexpected files of combined branch: %v", files)
+	}
+}
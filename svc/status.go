@@ -0,0 +1,584 @@
+package svc
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// Triggers of a sync run, see RunRecord.Trigger.
+const (
+	TriggerSchedule = "schedule"
+	TriggerManual   = "manual"
+)
+
+type reportKey struct{}
+
+// SyncStatus is the state of a sync. Everything but the state of the
+// scheduler, i.e. paused, running and next run, is persisted across restarts.
+type SyncStatus struct {
+	Name         string        `json:"name"`
+	Paused       bool          `json:"paused"`
+	Running      bool          `json:"running"`
+	NextRun      time.Time     `json:"next_run,omitzero"`
+	LastAttempt  time.Time     `json:"last_attempt,omitzero"`
+	LastSuccess  time.Time     `json:"last_success,omitzero"`
+	LastDuration time.Duration `json:"last_duration,omitempty"`
+	LastError    string        `json:"last_error,omitempty"`
+	// Diverged is set if branches diverged between the repos in the last
+	// run and weren't synced because of it, see ReadConflicts.
+	Diverged bool `json:"diverged,omitempty"`
+	// ConsecutiveFailures is the number of failed runs since the last success.
+	ConsecutiveFailures int `json:"consecutive_failures"`
+
+	// SourceHeads maps the synced branches of the `from` repo to their commits,
+	// as of the last successful run.
+	SourceHeads map[string]string `json:"source_heads,omitempty"`
+	// Pushed maps each remote to the branches pushed to it and their commits,
+	// as of the last time the branch was pushed successfully.
+	Pushed map[string]map[string]string `json:"pushed,omitempty"`
+
+	// From, Sources and To are the repos of the sync as per the running config,
+	// they are only set in the status returned by the control API, not persisted.
+	From    conf.Repo     `json:"from,omitzero"`
+	Sources []conf.Source `json:"sources,omitempty"`
+	To      []conf.Repo   `json:"to,omitempty"`
+}
+
+const (
+	// cMaxReportedCommits is the maximum number of new commits recorded per branch and run.
+	cMaxReportedCommits = 10
+	// cMaxCountedCommits is the maximum number of new commits counted per branch and run.
+	cMaxCountedCommits = 10000
+)
+
+// CommitSummary describes a commit of the `from` repo that was new in a sync run.
+type CommitSummary struct {
+	Branch  string `json:"branch"`
+	Hash    string `json:"hash"`
+	Author  string `json:"author"`
+	Summary string `json:"summary"`
+}
+
+// RunRecord is an entry in the history of the sync runs.
+type RunRecord struct {
+	Sync        string                       `json:"sync"`
+	Trigger     string                       `json:"trigger"`
+	StartedAt   time.Time                    `json:"started_at"`
+	Duration    time.Duration                `json:"duration"`
+	Error       string                       `json:"error,omitempty"`
+	Diverged    bool                         `json:"diverged,omitempty"`
+	SourceHeads map[string]string            `json:"source_heads,omitempty"`
+	Pushed      map[string]map[string]string `json:"pushed,omitempty"`
+	// NewCommits are the commits of the `from` repo since the heads of the previous run.
+	NewCommits []CommitSummary `json:"new_commits,omitempty"`
+}
+
+// HistoryQuery selects the records of the history. Zero valued fields match every record.
+type HistoryQuery struct {
+	Sync  string
+	Since time.Time
+	Until time.Time
+	// Commit selects the successful pushes of the commit or of any of its descendants,
+	// i.e. the runs that delivered the commit, optionally only to the Target remote.
+	Commit string
+	Target string
+	// Limit returns only the last Limit records.
+	Limit int
+}
+
+// syncReport collects the commits of the branches synced by a run. It is
+// safe for concurrent use as the targets of a sync are pushed in parallel.
+type syncReport struct {
+	mu sync.Mutex
+	// previousHeads are the source heads of the previous run, to find the new commits
+	previousHeads map[string]string
+	sourceHeads   map[string]string
+	newCommits    []CommitSummary
+	commitCount   int
+	// pushed maps remote to branch to the local reference that was pushed,
+	// the references are resolved to commits at the end of the sync.
+	pushed map[string]map[string]plumbing.ReferenceName
+	hashes map[string]map[string]string
+}
+
+// withReport returns a context that carries the report of the sync run to the pushes.
+func withReport(ctx context.Context, r *syncReport) context.Context {
+	return context.WithValue(ctx, reportKey{}, r)
+}
+
+// reportFrom returns the report of the sync run carried by the context, if any.
+func reportFrom(ctx context.Context) *syncReport {
+	r, _ := ctx.Value(reportKey{}).(*syncReport)
+	return r
+}
+
+// recordPush records the branches pushed to the remote by the refspecs.
+func (r *syncReport) recordPush(remote string, refSpecs []config.RefSpec) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.pushed == nil {
+		r.pushed = make(map[string]map[string]plumbing.ReferenceName)
+	}
+	for _, rs := range refSpecs {
+		dst := rs.Dst("")
+		if rs.IsWildcard() || !dst.IsBranch() {
+			continue
+		}
+		if r.pushed[remote] == nil {
+			r.pushed[remote] = make(map[string]plumbing.ReferenceName)
+		}
+		r.pushed[remote][dst.Short()] = plumbing.ReferenceName(rs.Src())
+	}
+}
+
+// resolve records the heads of the synced branches of the `from` remote,
+// and resolves the pushed references to the commits they point to.
+func (r *syncReport) resolve(repo *git.Repository, from string, branches []branchMapping) {
+	heads := make(map[string]plumbing.ReferenceName)
+	for _, bm := range branches {
+		heads[bm.source] = plumbing.NewRemoteReferenceName(from, bm.source)
+	}
+
+	r.resolveHeads(repo, heads)
+}
+
+// resolveHeads records the heads of the synced branches given their local references
+// by the names they are reported by, and resolves the pushed references.
+func (r *syncReport) resolveHeads(repo *git.Repository, heads map[string]plumbing.ReferenceName) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.sourceHeads = make(map[string]string)
+	names := make([]string, 0, len(heads))
+	for name := range heads {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		ref, err := repo.Reference(heads[name], true)
+		if err != nil {
+			continue
+		}
+		r.sourceHeads[name] = ref.Hash().String()
+		if prev := r.previousHeads[name]; prev != "" && prev != ref.Hash().String() {
+			commits, count := newCommits(repo, name, ref.Hash(), plumbing.NewHash(prev))
+			r.newCommits, r.commitCount = append(r.newCommits, commits...), r.commitCount+count
+		}
+	}
+
+	r.hashes = make(map[string]map[string]string)
+	for remote, branches := range r.pushed {
+		for branch, local := range branches {
+			ref, err := repo.Reference(local, true)
+			if err != nil {
+				continue
+			}
+			if r.hashes[remote] == nil {
+				r.hashes[remote] = make(map[string]string)
+			}
+			r.hashes[remote][branch] = ref.Hash().String()
+		}
+	}
+}
+
+// newCommits returns the commits reachable from the head but not from the previous head,
+// newest first and at most cMaxReportedCommits, along with the number of such commits up
+// to cMaxCountedCommits. The log stops at the previous head, all the commits are listed
+// when the branch was rewritten, up to the maximum.
+func newCommits(repo *git.Repository, branch string, head, prev plumbing.Hash) (
+	[]CommitSummary, int) {
+
+	iter, err := repo.Log(&git.LogOptions{From: head})
+	if err != nil {
+		slog.Warn("unable to list new commits", "branch", branch, "err", err)
+		return nil, 0
+	}
+	defer iter.Close()
+
+	var commits []CommitSummary
+	count := 0
+	for ; count < cMaxCountedCommits; count++ {
+		c, err := iter.Next()
+		if err != nil || c.Hash == prev {
+			break
+		}
+		if len(commits) == cMaxReportedCommits {
+			continue
+		}
+		summary, _, _ := strings.Cut(strings.TrimSpace(c.Message), "\n")
+		commits = append(commits, CommitSummary{
+			Branch:  branch,
+			Hash:    c.Hash.String(),
+			Author:  c.Author.Name,
+			Summary: summary,
+		})
+	}
+
+	return commits, count
+}
+
+// statusStore keeps the status of every sync in the status file
+// and appends a record of every sync run to the history file.
+type statusStore struct {
+	statusPath  string
+	historyPath string
+
+	// onEvent is called with the events of the runs, e.g. a sync starting to fail
+	onEvent func(Event)
+
+	mu     sync.Mutex
+	status map[string]*SyncStatus
+}
+
+// openStatusStore loads the status of the syncs persisted by an earlier run.
+func openStatusStore(statusPath, historyPath string) *statusStore {
+	ss := &statusStore{
+		statusPath:  statusPath,
+		historyPath: historyPath,
+		status:      make(map[string]*SyncStatus),
+	}
+
+	status, err := readStatus(statusPath)
+	if err != nil {
+		slog.Warn("ignoring the persisted status of syncs", "err", err)
+	}
+	for _, st := range status {
+		st.Running, st.Paused, st.NextRun = false, false, time.Time{}
+		ss.status[st.Name] = &st
+	}
+
+	return ss
+}
+
+// add starts tracking the status of the sync, if it isn't already.
+func (ss *statusStore) add(name string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if st, ok := ss.status[name]; !ok {
+		ss.status[name] = &SyncStatus{Name: name}
+	} else {
+		metrics.setLastSuccess(name, st.LastSuccess)
+	}
+}
+
+// remove stops tracking the status of a sync that was removed from the config.
+func (ss *statusStore) remove(name string) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	delete(ss.status, name)
+	metrics.remove(name)
+	if err := ss.persist(); err != nil {
+		slog.Warn("error persisting status of syncs", "err", err)
+	}
+}
+
+func (ss *statusStore) update(name string, update func(*SyncStatus)) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if st, ok := ss.status[name]; ok {
+		update(st)
+	}
+}
+
+// list returns the status of all the syncs sorted by name.
+func (ss *statusStore) list() []SyncStatus {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	list := make([]SyncStatus, 0, len(ss.status))
+	for _, st := range ss.status {
+		list = append(list, *st)
+	}
+
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// run runs the sync, and records its outcome in the status and in the history.
+func (ss *statusStore) run(ctx context.Context, name, trigger string, syncFn func(context.Context) error) error {
+	ss.add(name)
+	ctx = withLogAttrs(ctx, "sync", name)
+	report := &syncReport{}
+	start := time.Now()
+	ss.update(name, func(st *SyncStatus) {
+		st.Running = true
+		report.previousHeads = st.SourceHeads
+	})
+
+	err := syncFn(withReport(ctx, report))
+
+	rec := RunRecord{
+		Sync:        name,
+		Trigger:     trigger,
+		StartedAt:   start,
+		Duration:    time.Since(start),
+		SourceHeads: report.sourceHeads,
+		Pushed:      report.hashes,
+		NewCommits:  report.newCommits,
+	}
+	if err != nil {
+		var errDiverged *ErrDiverged
+		rec.Error, rec.Diverged = err.Error(), errors.As(err, &errDiverged)
+	}
+	metrics.recordRun(rec, err, report.commitCount)
+	ss.record(rec)
+
+	return err
+}
+
+func (ss *statusStore) record(rec RunRecord) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	st, ok := ss.status[rec.Sync]
+	if !ok {
+		return
+	}
+
+	failures, prevHeads := st.ConsecutiveFailures, st.SourceHeads
+	st.Running, st.LastAttempt, st.LastDuration, st.LastError = false, rec.StartedAt, rec.Duration, rec.Error
+	st.Diverged = rec.Diverged
+	if rec.Error == "" {
+		st.LastSuccess, st.ConsecutiveFailures = rec.StartedAt, 0
+	} else {
+		st.ConsecutiveFailures++
+	}
+	// the heads of a failed run may not have been synced, the next run reports their commits
+	if rec.Error == "" && len(rec.SourceHeads) > 0 {
+		st.SourceHeads = rec.SourceHeads
+	}
+	// the maps are replaced instead of updated in place as the listed copies share them
+	if len(rec.Pushed) > 0 {
+		pushed := make(map[string]map[string]string)
+		for _, m := range []map[string]map[string]string{st.Pushed, rec.Pushed} {
+			for remote, branches := range m {
+				if pushed[remote] == nil {
+					pushed[remote] = make(map[string]string)
+				}
+				for branch, hash := range branches {
+					pushed[remote][branch] = hash
+				}
+			}
+		}
+		st.Pushed = pushed
+	}
+
+	if err := ss.persist(); err != nil {
+		slog.Warn("error persisting status of syncs", "err", err)
+	}
+	if err := appendHistory(ss.historyPath, rec); err != nil {
+		slog.Warn("error recording sync run in history", "sync", rec.Sync, "err", err)
+	}
+
+	if ss.onEvent != nil {
+		for _, ev := range runEvents(rec, failures, prevHeads) {
+			ss.onEvent(ev)
+		}
+	}
+}
+
+// persist writes the status of all the syncs, the caller must hold the lock.
+func (ss *statusStore) persist() error {
+	list := make([]SyncStatus, 0, len(ss.status))
+	for _, st := range ss.status {
+		list = append(list, *st)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+
+	data, err := json.MarshalIndent(list, "", "  ")
+	if err != nil {
+		return fmt.Errorf("error marshalling status :: %w", err)
+	}
+
+	// write to a temporary file first so that a crash doesn't lose the status
+	tmpPath := ss.statusPath + ".tmp"
+	if err := os.WriteFile(tmpPath, data, conf.SecureFilePerm()); err != nil {
+		return fmt.Errorf("error writing status file :: %w", err)
+	}
+	if err := os.Rename(tmpPath, ss.statusPath); err != nil {
+		return fmt.Errorf("error replacing status file :: %w", err)
+	}
+
+	return nil
+}
+
+// ReadStatus returns the status of the syncs persisted in the status file.
+func ReadStatus(statusPath string) ([]SyncStatus, error) {
+	return readStatus(statusPath)
+}
+
+func readStatus(statusPath string) ([]SyncStatus, error) {
+	data, err := os.ReadFile(statusPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error reading status file :: %w", err)
+	}
+
+	var status []SyncStatus
+	if err := json.Unmarshal(data, &status); err != nil {
+		return nil, fmt.Errorf("error unmarshalling status file :: %w", err)
+	}
+
+	return status, nil
+}
+
+func appendHistory(historyPath string, rec RunRecord) error {
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("error marshalling run record :: %w", err)
+	}
+
+	f, err := os.OpenFile(historyPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, conf.SecureFilePerm())
+	if err != nil {
+		return fmt.Errorf("error opening history file :: %w", err)
+	}
+	if _, err := f.Write(append(data, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("error writing history file :: %w", err)
+	}
+
+	return f.Close()
+}
+
+// ReadHistory returns the records of the history file that match the query, oldest first.
+// The commit of the query is looked up in the local clone of the sync of each record.
+func ReadHistory(historyPath string, q HistoryQuery) ([]RunRecord, error) {
+	return readHistory(historyPath, q, conf.GetSyncTarget)
+}
+
+// readHistory reads the history, looking up the commit of the query in the
+// local clone of each sync found using `repoFolder`.
+func readHistory(historyPath string, q HistoryQuery, repoFolder func(string) string) (
+	[]RunRecord, error) {
+
+	f, err := os.Open(historyPath)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("error opening history file :: %w", err)
+	}
+	defer f.Close()
+
+	delivered := &deliveryCheck{
+		commit:     q.Commit,
+		repoFolder: repoFolder,
+		repos:      make(map[string]*git.Repository),
+		checked:    make(map[string]bool),
+	}
+	var records []RunRecord
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1<<20)
+	for scanner.Scan() {
+		var rec RunRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			// a crash may leave a partially written record behind
+			slog.Warn("skipping invalid record in history", "err", err)
+			continue
+		}
+
+		switch {
+		case q.Sync != "" && rec.Sync != q.Sync:
+		case !q.Since.IsZero() && rec.StartedAt.Before(q.Since):
+		case !q.Until.IsZero() && rec.StartedAt.After(q.Until):
+		case q.Commit != "" && !delivered.in(rec, q.Target):
+		default:
+			records = append(records, rec)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading history file :: %w", err)
+	}
+
+	if q.Limit > 0 && len(records) > q.Limit {
+		records = records[len(records)-q.Limit:]
+	}
+
+	return records, nil
+}
+
+// deliveryCheck finds whether a run pushed a commit or any of its descendants.
+type deliveryCheck struct {
+	commit     string
+	repoFolder func(string) string
+	repos      map[string]*git.Repository
+	// checked caches whether the commit is an ancestor of a pushed commit
+	checked map[string]bool
+}
+
+func (dc *deliveryCheck) in(rec RunRecord, target string) bool {
+	repo, ok := dc.repos[rec.Sync]
+	if !ok {
+		var err error
+		if repo, err = git.PlainOpen(dc.repoFolder(rec.Sync)); err != nil {
+			slog.Warn("unable to open the local clone", "sync", rec.Sync, "err", err)
+		}
+		dc.repos[rec.Sync] = repo
+	}
+
+	for remote, branches := range rec.Pushed {
+		if target != "" && remote != target {
+			continue
+		}
+		for _, hash := range branches {
+			if strings.HasPrefix(hash, dc.commit) {
+				return true
+			}
+			ok, checked := dc.checked[hash]
+			if !checked && repo != nil {
+				ok = isAncestor(repo, dc.commit, hash)
+				dc.checked[hash] = ok
+			}
+			if ok {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// isAncestor returns whether the commit, which may be abbreviated, is an ancestor of the descendant.
+func isAncestor(repo *git.Repository, commit, descendant string) bool {
+	hash, err := repo.ResolveRevision(plumbing.Revision(commit))
+	if err != nil {
+		return false
+	}
+	c, err := repo.CommitObject(*hash)
+	if err != nil {
+		return false
+	}
+	d, err := repo.CommitObject(plumbing.NewHash(descendant))
+	if err != nil {
+		return false
+	}
+
+	ok, err := c.IsAncestor(d)
+	return err == nil && ok
+}
@@ -0,0 +1,91 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func testStatusStore(t *testing.T) *statusStore {
+	dir := t.TempDir()
+	return openStatusStore(filepath.Join(dir, "status.json"), filepath.Join(dir, "history.jsonl"))
+}
+
+func TestStatusStore(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+	toDir, _ := setupSide(t)
+	defer deleteTestDir(t, toDir)
+
+	sc := conf.SyncConfig{
+		Name:     "project",
+		From:     conf.Repo{Name: "overleaf", URLToRepo: fmt.Sprintf("file://%v", fromDir)},
+		ToList:   []conf.Repo{{Name: "github", URLToRepo: fmt.Sprintf("file://%v", toDir)}},
+		Branches: conf.BranchPolicy{{Pattern: "master", Rename: "main"}},
+	}
+	repoDir := filepath.Join(t.TempDir(), "project")
+	syncProject := func(ctx context.Context) error {
+		return syncFolder(ctx, repoDir, sc, nil)
+	}
+
+	store := testStatusStore(t)
+	ctx := context.Background()
+	if err := store.run(ctx, sc.Name, TriggerSchedule, syncProject); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	first := masterHead(t, fromRepo).String()
+
+	commitOnMaster(t, fromDir, fromRepo, "paper.tex", "edited on overleaf")
+	if err := store.run(ctx, sc.Name, TriggerManual, syncProject); err != nil {
+		t.Fatalf("error syncing :: %v", err)
+	}
+	second := masterHead(t, fromRepo).String()
+
+	errDown := errors.New("remote is down")
+	for i := 0; i < 2; i++ {
+		_ = store.run(ctx, sc.Name, TriggerSchedule, func(context.Context) error { return errDown })
+	}
+
+	// the status survives a restart
+	store = openStatusStore(store.statusPath, store.historyPath)
+	status := store.list()
+	if len(status) != 1 {
+		t.Fatalf("unexpected status: %+v", status)
+	}
+	st := status[0]
+	if st.ConsecutiveFailures != 2 || st.LastError != errDown.Error() || st.LastSuccess.IsZero() ||
+		!st.LastAttempt.After(st.LastSuccess) {
+		t.Fatalf("unexpected status: %+v", st)
+	}
+	if st.SourceHeads["master"] != second || st.Pushed["github"]["main"] != second {
+		t.Fatalf("unexpected commits in status: %+v", st)
+	}
+
+	folder := func(string) string { return repoDir }
+	records, err := readHistory(store.historyPath, HistoryQuery{Sync: sc.Name}, folder)
+	if err != nil || len(records) != 4 || records[1].Trigger != TriggerManual {
+		t.Fatalf("unexpected history: %+v :: %v", records, err)
+	}
+	if len(records[0].NewCommits) != 0 || len(records[1].NewCommits) != 1 ||
+		records[1].NewCommits[0].Hash != second || records[1].NewCommits[0].Summary != "Update paper.tex" {
+		t.Fatalf("unexpected new commits in history: %+v", records)
+	}
+
+	// the first commit was delivered by both the successful runs, the second only by the last
+	records, err = readHistory(store.historyPath, HistoryQuery{Commit: first, Target: "github"}, folder)
+	if err != nil || len(records) != 2 {
+		t.Fatalf("unexpected deliveries of %v: %+v :: %v", first, records, err)
+	}
+	records, err = readHistory(store.historyPath, HistoryQuery{Commit: second[:8]}, folder)
+	if err != nil || len(records) != 1 {
+		t.Fatalf("unexpected deliveries of %v: %+v :: %v", second, records, err)
+	}
+	records, err = readHistory(store.historyPath, HistoryQuery{Limit: 1}, folder)
+	if err != nil || len(records) != 1 || records[0].Error != errDown.Error() {
+		t.Fatalf("unexpected last record: %+v :: %v", records, err)
+	}
+}
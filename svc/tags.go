@@ -0,0 +1,176 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/config"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/transport"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+const (
+	// cTagsRefPrefix is followed by <from>/<tag>, the ref holds the commit of
+	// the tag on the `from` repo as of the last fetch.
+	cTagsRefPrefix = "refs/giggle/tags/"
+	// cMovedTagsRefPrefix is followed by <from>/<tag>, the ref holds the commit
+	// that the tag pointed to on the `from` repo before it was last moved.
+	cMovedTagsRefPrefix = "refs/giggle/moved-tags/"
+)
+
+// fetchTags fetches the tags of the `from` remote selected by the tag config into the tag
+// namespace of the remote, see cTagsRefPrefix. The previous commit of a tag that was moved
+// on the remote since the last fetch is kept, so that the tag is known to have moved.
+func fetchTags(ctx context.Context, repo *git.Repository, from *git.Remote,
+	tc *conf.TagConfig, am *conf.AuthMethod) error {
+
+	if tc == nil {
+		return nil
+	}
+
+	remoteTags, err := listRemoteTags(ctx, from, am)
+	if err != nil {
+		return err
+	}
+
+	name := from.Config().Name
+	var refSpecs []config.RefSpec
+	for tag, hash := range remoteTags {
+		if !tc.Match(tag.Short()) {
+			continue
+		}
+
+		local := tagRefName(cTagsRefPrefix, name, tag.Short())
+		if prev, err := repo.Reference(local, true); err == nil && prev.Hash() != hash {
+			moved := tagRefName(cMovedTagsRefPrefix, name, tag.Short())
+			if err := setRef(repo, moved, prev.Hash()); err != nil {
+				return err
+			}
+		}
+		refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("+%v:%v", tag, local)))
+	}
+	if len(refSpecs) == 0 {
+		return nil
+	}
+
+	sort.Slice(refSpecs, func(i, j int) bool { return refSpecs[i] < refSpecs[j] })
+	return fetch(ctx, from, am, refSpecs...)
+}
+
+// pushTags pushes the tags of the `from` remote selected by the tag config to the `to` remote.
+// A tag that exists on the `to` remote at the commit it pointed to before it was moved on the
+// `from` repo is only updated if the config allows forcing it. A tag that exists on the `to`
+// remote at any other commit, e.g. a tag created on the `to` repo, is never overwritten and
+// an *ErrDiverged is returned with the conflicts, after the other tags are pushed.
+func pushTags(ctx context.Context, repo *git.Repository, to *git.Remote, from string,
+	tc *conf.TagConfig, am *conf.AuthMethod) error {
+
+	if tc == nil {
+		return nil
+	}
+
+	remoteTags, err := listRemoteTags(ctx, to, am)
+	if err != nil {
+		return err
+	}
+
+	refs, err := repo.References()
+	if err != nil {
+		return fmt.Errorf("error listing tags :: %w", err)
+	}
+	defer refs.Close()
+
+	prefix := cTagsRefPrefix + from + "/"
+	var refSpecs []config.RefSpec
+	var conflicts []Conflict
+	err = refs.ForEach(func(ref *plumbing.Reference) error {
+		tag, ok := strings.CutPrefix(ref.Name().String(), prefix)
+		if !ok || !tc.Match(tag) {
+			return nil
+		}
+
+		remoteHash, exists := remoteTags[plumbing.NewTagReferenceName(tag)]
+		moved, _ := repo.Reference(tagRefName(cMovedTagsRefPrefix, from, tag), true)
+		switch {
+		case !exists:
+			refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("%v:refs/tags/%v", ref.Name(), tag)))
+		case remoteHash == ref.Hash():
+		case moved == nil || moved.Hash() != remoteHash:
+			loggerFrom(ctx).Warn("tag differs on the target, not updating it", "tag", tag,
+				"source", ref.Hash(), "target", remoteHash)
+			conflicts = append(conflicts, Conflict{
+				Remote:     to.Config().Name,
+				Tag:        tag,
+				SourceHead: ref.Hash().String(),
+				TargetHead: remoteHash.String(),
+				DetectedAt: time.Now(),
+			})
+		case tc.Force:
+			loggerFrom(ctx).Info("tag moved, force updating it", "tag", tag,
+				"from", remoteHash, "to", ref.Hash())
+			refSpecs = append(refSpecs, config.RefSpec(fmt.Sprintf("+%v:refs/tags/%v", ref.Name(), tag)))
+		default:
+			loggerFrom(ctx).Warn("tag moved, not updating it without force", "tag", tag,
+				"from", remoteHash, "to", ref.Hash())
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("error iterating tags :: %w", err)
+	}
+
+	if len(refSpecs) > 0 {
+		sort.Slice(refSpecs, func(i, j int) bool { return refSpecs[i] < refSpecs[j] })
+		if err := push(ctx, to, refSpecs, am); err != nil {
+			return err
+		}
+	}
+	if len(conflicts) > 0 {
+		sort.Slice(conflicts, func(i, j int) bool { return conflicts[i].Tag < conflicts[j].Tag })
+		return &ErrDiverged{Conflicts: conflicts}
+	}
+
+	return nil
+}
+
+func tagRefName(prefix, from, tag string) plumbing.ReferenceName {
+	return plumbing.ReferenceName(prefix + from + "/" + tag)
+}
+
+// listRemoteTags returns the tags of the remote along with the hashes they point to.
+func listRemoteTags(ctx context.Context, remote *git.Remote, am *conf.AuthMethod) (
+	map[plumbing.ReferenceName]plumbing.Hash, error) {
+
+	auth, err := am.GetAuth(remote.Config().URLs[0])
+	if err != nil {
+		return nil, fmt.Errorf("error in auth for [%v] :: %w", remote.Config().Name, err)
+	}
+
+	var refs []*plumbing.Reference
+	err = withRemote(ctx, remote.Config().URLs[0], func() error {
+		var err error
+		refs, err = remote.ListContext(ctx, &git.ListOptions{Auth: auth})
+		if errors.Is(err, transport.ErrEmptyRemoteRepository) {
+			return nil
+		}
+		return err
+	})
+	if err != nil {
+		return nil, fmt.Errorf("error listing refs of [%v] :: %w", remote.Config().Name, err)
+	}
+
+	tags := make(map[plumbing.ReferenceName]plumbing.Hash)
+	for _, ref := range refs {
+		if ref.Name().IsTag() {
+			tags[ref.Name()] = ref.Hash()
+		}
+	}
+
+	return tags, nil
+}
@@ -0,0 +1,115 @@
+package svc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"testing"
+
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func tagHash(t *testing.T, repo *git.Repository, tag string) plumbing.Hash {
+	ref, err := repo.Tag(tag)
+	if err == git.ErrTagNotFound {
+		return plumbing.ZeroHash
+	} else if err != nil {
+		t.Fatalf("error finding tag %v :: %v", tag, err)
+	}
+	return ref.Hash()
+}
+
+func TestPushTags(t *testing.T) {
+	fromDir, fromRepo := setupSide(t)
+	defer deleteTestDir(t, fromDir)
+	toDir, toRepo := setupSide(t)
+	defer deleteTestDir(t, toDir)
+	for _, tag := range []string{"camera-ready", "v1"} {
+		if _, err := fromRepo.CreateTag(tag, masterHead(t, fromRepo), nil); err != nil {
+			t.Fatalf("error creating tag :: %v", err)
+		}
+	}
+
+	repoDir := path.Join(os.TempDir(), fmt.Sprintf("testdir_%d", rand.Intn(100000)))
+	defer deleteTestDir(t, repoDir)
+	cr := conf.Repo{Name: "overleaf", URLToRepo: fmt.Sprintf("file://%v", fromDir)}
+	repo, err := openRepo(context.Background(), cr, nil, repoDir)
+	if err != nil {
+		t.Fatalf("error opening repo :: %v", err)
+	}
+	fromRemote, err := repo.Remote("overleaf")
+	if err != nil {
+		t.Fatalf("error finding remote :: %v", err)
+	}
+	toRemote, err := createRemote(repo, "github", fmt.Sprintf("file://%v", toDir))
+	if err != nil {
+		t.Fatalf("error creating remote :: %v", err)
+	}
+
+	tc := &conf.TagConfig{Patterns: []string{"camera-*"}}
+	pushOnce := func() error {
+		if err := fetchTags(context.Background(), repo, fromRemote, tc, nil); err != nil {
+			t.Fatalf("error fetching :: %v", err)
+		}
+		return pushTags(context.Background(), repo, toRemote, "overleaf", tc, nil)
+	}
+	syncTags := func() {
+		if err := pushOnce(); err != nil {
+			t.Fatalf("error pushing tags :: %v", err)
+		}
+	}
+
+	syncTags()
+	if tagHash(t, toRepo, "camera-ready") != masterHead(t, fromRepo) {
+		t.Fatal("camera-ready tag wasn't pushed")
+	}
+	if tagHash(t, toRepo, "v1") != plumbing.ZeroHash {
+		t.Fatal("v1 tag shouldn't be pushed")
+	}
+	if _, err := repo.Reference(tagRefName(cTagsRefPrefix, "overleaf", "v1"), true); err == nil {
+		t.Fatal("v1 tag shouldn't be fetched")
+	}
+
+	// move the tag on the source
+	oldHead := masterHead(t, fromRepo)
+	commitOnMaster(t, fromDir, fromRepo, "main.tex", "final\n")
+	if err := fromRepo.DeleteTag("camera-ready"); err != nil {
+		t.Fatalf("error deleting tag :: %v", err)
+	}
+	if _, err := fromRepo.CreateTag("camera-ready", masterHead(t, fromRepo), nil); err != nil {
+		t.Fatalf("error creating tag :: %v", err)
+	}
+
+	syncTags()
+	if tagHash(t, toRepo, "camera-ready") != oldHead {
+		t.Fatal("moved tag was updated without force")
+	}
+
+	tc.Force = true
+	syncTags()
+	if tagHash(t, toRepo, "camera-ready") != masterHead(t, fromRepo) {
+		t.Fatal("moved tag wasn't force updated")
+	}
+
+	// a tag of the same name created on the target isn't overwritten, even with force
+	commitOnMaster(t, toDir, toRepo, "release.tex", "release\n")
+	if _, err := toRepo.CreateTag("camera-release", masterHead(t, toRepo), nil); err != nil {
+		t.Fatalf("error creating tag :: %v", err)
+	}
+	if _, err := fromRepo.CreateTag("camera-release", masterHead(t, fromRepo), nil); err != nil {
+		t.Fatalf("error creating tag :: %v", err)
+	}
+	var errDiverged *ErrDiverged
+	if err := pushOnce(); !errors.As(err, &errDiverged) || len(errDiverged.Conflicts) != 1 ||
+		errDiverged.Conflicts[0].Tag != "camera-release" {
+		t.Fatalf("expected conflict for tag created on the target, got %v", err)
+	}
+	if tagHash(t, toRepo, "camera-release") != masterHead(t, toRepo) {
+		t.Fatal("tag created on the target was overwritten")
+	}
+}
@@ -0,0 +1,113 @@
+package svc
+
+import (
+	"log/slog"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	// cReloadDelay groups the burst of events that editors generate while saving a file.
+	cReloadDelay = 500 * time.Millisecond
+	// cReloadPollPeriod is used to check the config file when it cannot be watched.
+	cReloadPollPeriod = time.Minute
+)
+
+// configWatcher notifies on `changed` whenever the config file may have changed.
+type configWatcher struct {
+	changed chan struct{}
+	quit    chan struct{}
+	done    chan struct{}
+}
+
+// watchConfig starts watching the config file. The folder of the file is watched
+// instead of the file itself as editors often replace the file while saving it.
+// It falls back to polling if the folder cannot be watched, e.g. if it doesn't exist yet.
+func watchConfig(configFile string) *configWatcher {
+	cw := &configWatcher{
+		changed: make(chan struct{}, 1),
+		quit:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+
+	w, err := fsnotify.NewWatcher()
+	if err == nil {
+		if err = w.Add(filepath.Dir(configFile)); err != nil {
+			_ = w.Close()
+		}
+	}
+	if err != nil {
+		slog.Warn("unable to watch config file, polling it", "period", cReloadPollPeriod, "err", err)
+		go cw.poll()
+		return cw
+	}
+
+	slog.Info("watching config file for changes", "file", configFile)
+	go cw.watch(w, filepath.Clean(configFile))
+	return cw
+}
+
+func (cw *configWatcher) stop() {
+	close(cw.quit)
+	<-cw.done
+}
+
+func (cw *configWatcher) notify() {
+	select {
+	case cw.changed <- struct{}{}:
+	default:
+	}
+}
+
+func (cw *configWatcher) watch(w *fsnotify.Watcher, configFile string) {
+	defer close(cw.done)
+	defer func() {
+		if err := w.Close(); err != nil {
+			slog.Warn("unable to close config watcher", "err", err)
+		}
+	}()
+
+	var delay <-chan time.Time
+	for {
+		select {
+		case <-cw.quit:
+			return
+		case event, ok := <-w.Events:
+			if !ok {
+				return
+			}
+			// reading the config resets its permissions, which shouldn't trigger a reload.
+			if event.Op == fsnotify.Chmod {
+				continue
+			}
+			if filepath.Clean(event.Name) == configFile {
+				delay = time.After(cReloadDelay)
+			}
+		case err, ok := <-w.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("error watching config file", "err", err)
+		case <-delay:
+			delay = nil
+			cw.notify()
+		}
+	}
+}
+
+func (cw *configWatcher) poll() {
+	defer close(cw.done)
+
+	ticker := time.NewTicker(cReloadPollPeriod)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-cw.quit:
+			return
+		case <-ticker.C:
+			cw.notify()
+		}
+	}
+}
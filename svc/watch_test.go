@@ -0,0 +1,42 @@
+package svc
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestWatchConfig(t *testing.T) {
+	configFile := filepath.Join(t.TempDir(), "config.json")
+	if err := createFile(configFile, "{}"); err != nil {
+		t.Fatalf("error creating config :: %v", err)
+	}
+
+	cw := watchConfig(configFile)
+	defer cw.stop()
+
+	// changing permissions doesn't trigger a reload
+	if err := os.Chmod(configFile, 0600); err != nil {
+		t.Fatalf("error changing permissions :: %v", err)
+	}
+	select {
+	case <-cw.changed:
+		t.Fatal("unexpected reload on chmod")
+	case <-time.After(2 * cReloadDelay):
+	}
+
+	// replacing the file triggers a reload
+	tmpFile := configFile + ".tmp"
+	if err := createFile(tmpFile, `{"period": "1m"}`); err != nil {
+		t.Fatalf("error creating config :: %v", err)
+	}
+	if err := os.Rename(tmpFile, configFile); err != nil {
+		t.Fatalf("error replacing config :: %v", err)
+	}
+	select {
+	case <-cw.changed:
+	case <-time.After(5 * time.Second):
+		t.Fatal("no reload after changing config")
+	}
+}
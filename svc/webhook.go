@@ -0,0 +1,101 @@
+package svc
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+// webhookPayload is the body posted to a webhook of the json format.
+type webhookPayload struct {
+	App     string  `json:"app"`
+	Summary string  `json:"summary"`
+	Message string  `json:"message"`
+	Events  []Event `json:"events"`
+}
+
+// chatPayload is the body posted to the incoming webhooks of Slack and of the Matrix bridges.
+type chatPayload struct {
+	Text     string `json:"text"`
+	Username string `json:"username,omitempty"`
+}
+
+// webhookNotifier posts the events to a webhook.
+type webhookNotifier struct {
+	messenger
+	url     string
+	format  string
+	headers map[string]string
+	hc      *http.Client
+}
+
+func newWebhookNotifier(wc conf.WebhookConfig, m messenger) *webhookNotifier {
+	return &webhookNotifier{
+		messenger: m,
+		url:       wc.URL,
+		format:    wc.WebhookFormat(),
+		headers:   wc.Headers,
+		hc:        &http.Client{},
+	}
+}
+
+func (wn *webhookNotifier) notify(ctx context.Context, events []Event) error {
+	msg, err := wn.message(events)
+	if err != nil {
+		return err
+	}
+
+	var payload interface{}
+	switch wn.format {
+	case conf.WebhookSlack:
+		payload = chatPayload{Text: msg}
+	case conf.WebhookMatrix:
+		payload = chatPayload{Text: msg, Username: conf.AppName()}
+	default:
+		payload = webhookPayload{App: conf.AppName(), Summary: summarize(events), Message: msg, Events: events}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("error marshalling webhook payload :: %w", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, wn.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("error creating webhook request :: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for key, value := range wn.headers {
+		req.Header.Set(key, value)
+	}
+
+	resp, err := wn.hc.Do(req)
+	if err != nil {
+		// the url may carry a secret, e.g. the incoming webhooks of Slack, and is not logged
+		return fmt.Errorf("error posting to %v webhook :: %w", wn.format, errorWithoutURL(err))
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected response [%v] from %v webhook", resp.Status, wn.format)
+	}
+
+	return nil
+}
+
+// errorWithoutURL returns the error without the url of the request, if it has one.
+func errorWithoutURL(err error) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		return ue.Err
+	}
+
+	return err
+}
@@ -0,0 +1,59 @@
+package svc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mangalaman93/giggle/conf"
+)
+
+func TestWebhookNotifier(t *testing.T) {
+	bodies := make(chan map[string]interface{}, 2)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer s3cret" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		var body map[string]interface{}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		bodies <- body
+	}))
+	defer server.Close()
+
+	failed := []Event{{Kind: conf.EventFailed, Sync: "paper", Error: "authentication required"}}
+	for _, format := range []string{conf.WebhookJSON, conf.WebhookSlack} {
+		wc := conf.WebhookConfig{
+			URL:     server.URL,
+			Format:  format,
+			Headers: map[string]string{"Authorization": "Bearer s3cret"},
+		}
+		notifiers, err := newNotifiers(conf.NotifyConfig{Webhooks: []conf.WebhookConfig{wc}})
+		if err != nil {
+			t.Fatalf("error creating notifiers :: %v", err)
+		}
+		if err := notifiers[0].notify(context.Background(), failed); err != nil {
+			t.Fatalf("error notifying %v webhook :: %v", format, err)
+		}
+
+		body := <-bodies
+		want := "paper is failing\nauthentication required"
+		if format == conf.WebhookSlack && body["text"] != want {
+			t.Fatalf("unexpected slack body: %v", body)
+		}
+		if format == conf.WebhookJSON && (body["message"] != want || len(body["events"].([]interface{})) != 1) {
+			t.Fatalf("unexpected json body: %v", body)
+		}
+	}
+
+	wc := conf.WebhookConfig{URL: server.URL}
+	notifiers, _ := newNotifiers(conf.NotifyConfig{Webhooks: []conf.WebhookConfig{wc}})
+	if err := notifiers[0].notify(context.Background(), failed); err == nil {
+		t.Fatal("expected error for unauthorized webhook")
+	}
+}
@@ -0,0 +1,69 @@
+package tray
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/png"
+	"log/slog"
+)
+
+// States of the app shown by the tray icon.
+const (
+	stateIdle    = "idle"
+	stateSyncing = "syncing"
+	stateError   = "error"
+)
+
+// badgeColors are the colors of the badge drawn on the app icon for each state.
+var badgeColors = map[string]color.RGBA{
+	stateSyncing: {R: 0x21, G: 0x96, B: 0xf3, A: 0xff},
+	stateError:   {R: 0xe5, G: 0x39, B: 0x35, A: 0xff},
+}
+
+// stateIcons returns the app icon for each state. The icons for the states other than idle
+// are the app icon with a colored badge in the bottom right corner, and fall back to the
+// app icon if the badge can't be drawn.
+func stateIcons(appIcon []byte) map[string][]byte {
+	icons := map[string][]byte{stateIdle: appIcon}
+	for state, c := range badgeColors {
+		icon, err := badgeIcon(appIcon, c)
+		if err != nil {
+			slog.Warn("unable to draw icon", "state", state, "err", err)
+			icon = appIcon
+		}
+		icons[state] = icon
+	}
+
+	return icons
+}
+
+func badgeIcon(appIcon []byte, c color.RGBA) ([]byte, error) {
+	src, err := png.Decode(bytes.NewReader(appIcon))
+	if err != nil {
+		return nil, err
+	}
+
+	b := src.Bounds()
+	img := image.NewRGBA(b)
+	draw.Draw(img, b, src, b.Min, draw.Src)
+
+	// a filled circle with a diameter of 40% of the icon
+	r := min(b.Dx(), b.Dy()) / 5
+	cx, cy := b.Max.X-r-1, b.Max.Y-r-1
+	for y := cy - r; y <= cy+r; y++ {
+		for x := cx - r; x <= cx+r; x++ {
+			if (x-cx)*(x-cx)+(y-cy)*(y-cy) <= r*r {
+				img.Set(x, y, c)
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := png.Encode(&buf, img); err != nil {
+		return nil, err
+	}
+
+	return buf.Bytes(), nil
+}
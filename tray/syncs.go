@@ -0,0 +1,333 @@
+package tray
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/getlantern/systray"
+	"github.com/mangalaman93/giggle/conf"
+	"github.com/mangalaman93/giggle/svc"
+	"github.com/skratchdot/open-golang/open"
+)
+
+const (
+	cRefreshPeriod  = 5 * time.Second
+	cRequestTimeout = 3 * time.Second
+	cTimeLayout     = "Jan 2 15:04"
+
+	cSyncNowMenuEntry    = "Sync now"
+	cPauseMenuEntry      = "Pause"
+	cResumeMenuEntry     = "Resume"
+	cOpenSourceMenuEntry = "Open source in browser"
+	cOpenTargetMenuEntry = "Open target in browser"
+	cOpenCloneMenuEntry  = "Open local clone"
+)
+
+// syncMenu is the submenu of a sync. The menu items of the system tray can't be
+// removed, so the submenu of a sync removed from the config is hidden and reused.
+type syncMenu struct {
+	gt *GTray
+
+	mu     sync.Mutex
+	status svc.SyncStatus
+
+	item       *systray.MenuItem
+	info       *systray.MenuItem
+	syncNow    *systray.MenuItem
+	pause      *systray.MenuItem
+	openSource *systray.MenuItem
+	openTarget *systray.MenuItem
+	openClone  *systray.MenuItem
+}
+
+func (gt *GTray) newSyncMenu(name string) *syncMenu {
+	sm := &syncMenu{gt: gt, status: svc.SyncStatus{Name: name}}
+	sm.item = gt.syncsMenu.AddSubMenuItem(name, "")
+	sm.info = sm.item.AddSubMenuItem("", "")
+	sm.info.Disable()
+	sm.syncNow = sm.item.AddSubMenuItem(cSyncNowMenuEntry, "")
+	sm.pause = sm.item.AddSubMenuItem(cPauseMenuEntry, "")
+	sm.openSource = sm.item.AddSubMenuItem(cOpenSourceMenuEntry, "")
+	sm.openTarget = sm.item.AddSubMenuItem(cOpenTargetMenuEntry, "")
+	sm.openClone = sm.item.AddSubMenuItem(cOpenCloneMenuEntry, "")
+	sm.render()
+
+	gt.wg.Add(1)
+	go sm.handleClicks()
+	return sm
+}
+
+func (sm *syncMenu) handleClicks() {
+	defer sm.gt.wg.Done()
+
+	for {
+		select {
+		case <-sm.gt.quit:
+			return
+		case <-sm.syncNow.ClickedCh:
+			sm.onSyncNowClick()
+		case <-sm.pause.ClickedCh:
+			sm.onPauseClick()
+		case <-sm.openSource.ClickedCh:
+			sm.onOpenSourceClick()
+		case <-sm.openTarget.ClickedCh:
+			sm.onOpenTargetClick()
+		case <-sm.openClone.ClickedCh:
+			sm.onOpenCloneClick()
+		}
+	}
+}
+
+func (sm *syncMenu) current() svc.SyncStatus {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	return sm.status
+}
+
+func (sm *syncMenu) onSyncNowClick() {
+	st := sm.current()
+	slog.Info("sync now menu option selected", "sync", st.Name)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cRequestTimeout)
+	defer cancel()
+	if _, err := sm.gt.client.SyncNow(ctx, false, st.Name); err != nil {
+		slog.Error("unable to sync", "sync", st.Name, "err", err)
+	}
+	sm.gt.refresh()
+}
+
+func (sm *syncMenu) onPauseClick() {
+	st := sm.current()
+	slog.Info("pause menu option selected", "sync", st.Name, "paused", st.Paused)
+
+	ctx, cancel := context.WithTimeout(context.Background(), cRequestTimeout)
+	defer cancel()
+	action := sm.gt.client.Pause
+	if st.Paused {
+		action = sm.gt.client.Resume
+	}
+	if err := action(ctx, st.Name); err != nil {
+		slog.Error("unable to pause or resume", "sync", st.Name, "err", err)
+	}
+	sm.gt.refresh()
+}
+
+func (sm *syncMenu) onOpenSourceClick() {
+	st := sm.current()
+	slog.Info("open source menu option selected", "sync", st.Name)
+	if len(st.Sources) == 0 {
+		openURL(st.From)
+	}
+	for _, src := range st.Sources {
+		openURL(src.Repo)
+	}
+}
+
+func (sm *syncMenu) onOpenTargetClick() {
+	st := sm.current()
+	slog.Info("open target menu option selected", "sync", st.Name)
+	for _, to := range st.To {
+		openURL(to)
+	}
+}
+
+func (sm *syncMenu) onOpenCloneClick() {
+	st := sm.current()
+	slog.Info("open local clone menu option selected", "sync", st.Name)
+	folder := conf.GetSyncTarget(st.Name)
+	if err := open.Start(folder); err != nil {
+		slog.Error("unable to open local clone", "path", folder, "err", err)
+	}
+}
+
+func (sm *syncMenu) update(st svc.SyncStatus) {
+	sm.mu.Lock()
+	sm.status = st
+	sm.mu.Unlock()
+
+	sm.render()
+	sm.item.Show()
+}
+
+// render updates the menu items as per the status of the sync.
+func (sm *syncMenu) render() {
+	st := sm.current()
+
+	state := "never synced"
+	switch {
+	case st.Running:
+		state = "syncing"
+	case st.Diverged:
+		state = fmt.Sprintf("diverged %v", st.LastAttempt.Local().Format(cTimeLayout))
+	case st.LastError != "":
+		state = fmt.Sprintf("failed %v", st.LastAttempt.Local().Format(cTimeLayout))
+	case !st.LastSuccess.IsZero():
+		state = fmt.Sprintf("synced %v", st.LastSuccess.Local().Format(cTimeLayout))
+	}
+	if st.Paused {
+		state += ", paused"
+	}
+
+	sm.item.SetTitle(fmt.Sprintf("%v (%v)", st.Name, state))
+	sm.info.SetTitle(state)
+	sm.info.SetTooltip(st.LastError)
+	if st.Paused {
+		sm.pause.SetTitle(cResumeMenuEntry)
+	} else {
+		sm.pause.SetTitle(cPauseMenuEntry)
+	}
+
+	for _, item := range []*systray.MenuItem{sm.syncNow, sm.pause, sm.openSource, sm.openTarget} {
+		if st.From.URLToRepo == "" && len(st.Sources) == 0 {
+			item.Disable()
+		} else {
+			item.Enable()
+		}
+	}
+}
+
+// refreshLoop refreshes the submenus of the syncs and the icon
+// of the tray as per the status of the service, periodically.
+func (gt *GTray) refreshLoop() {
+	defer gt.wg.Done()
+
+	ticker := time.NewTicker(cRefreshPeriod)
+	defer ticker.Stop()
+
+	for {
+		gt.refresh()
+		select {
+		case <-gt.quit:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (gt *GTray) refresh() {
+	ctx, cancel := context.WithTimeout(context.Background(), cRequestTimeout)
+	defer cancel()
+
+	status, err := gt.client.Status(ctx)
+
+	gt.mu.Lock()
+	defer gt.mu.Unlock()
+
+	// the service may not be serving the control API yet, the error is only logged once
+	if err != nil {
+		if !gt.statusFailed {
+			slog.Warn("unable to get status of syncs", "err", err)
+		}
+		gt.statusFailed = true
+		return
+	}
+	gt.statusFailed = false
+
+	wanted := make(map[string]bool)
+	for _, st := range status.Syncs {
+		wanted[st.Name] = true
+	}
+
+	state := stateIdle
+	failing := 0
+	seen := make(map[*syncMenu]bool)
+	for _, st := range status.Syncs {
+		sm := gt.menuFor(st.Name, wanted, seen)
+		seen[sm] = true
+		sm.update(st)
+
+		if st.LastError != "" {
+			failing++
+		}
+		if st.Running {
+			state = stateSyncing
+		}
+	}
+	for _, sm := range gt.menus {
+		if !seen[sm] {
+			sm.item.Hide()
+		}
+	}
+	if len(status.Syncs) == 0 {
+		gt.syncsMenu.Disable()
+	} else {
+		gt.syncsMenu.Enable()
+	}
+
+	if state != stateSyncing && failing > 0 {
+		state = stateError
+	}
+	if state != gt.state {
+		systray.SetIcon(gt.icons[state])
+		gt.state = state
+	}
+
+	tooltip := conf.AppName()
+	if failing > 0 {
+		tooltip = fmt.Sprintf("%v - %v sync(s) failing", conf.AppName(), failing)
+	}
+	systray.SetTooltip(tooltip)
+}
+
+// menuFor returns the submenu of the sync, reusing the submenu of a removed sync
+// if possible, or creating a new one otherwise. The caller must hold the lock.
+func (gt *GTray) menuFor(name string, wanted map[string]bool, taken map[*syncMenu]bool) *syncMenu {
+	for _, sm := range gt.menus {
+		if sm.current().Name == name {
+			return sm
+		}
+	}
+	for _, sm := range gt.menus {
+		if !taken[sm] && !wanted[sm.current().Name] {
+			return sm
+		}
+	}
+
+	sm := gt.newSyncMenu(name)
+	gt.menus = append(gt.menus, sm)
+	return sm
+}
+
+// configuredSyncs returns the names of the syncs in the config file, so that their submenus
+// can be added before the service starts serving the status. The secrets aren't resolved.
+func configuredSyncs() []string {
+	data, err := os.ReadFile(conf.SettingsFilePath())
+	if err != nil {
+		slog.Warn("unable to read config file", "err", err)
+		return nil
+	}
+
+	var cf struct {
+		Sync []struct {
+			Name string `json:"name"`
+		} `json:"sync"`
+	}
+	if err := json.Unmarshal(data, &cf); err != nil {
+		slog.Warn("unable to parse config file", "err", err)
+		return nil
+	}
+
+	names := make([]string, 0, len(cf.Sync))
+	for _, sc := range cf.Sync {
+		names = append(names, sc.Name)
+	}
+
+	return names
+}
+
+// openURL opens the web page of the repo in the browser.
+func openURL(r conf.Repo) {
+	if r.URLToRepo == "" {
+		return
+	}
+
+	webURL := svc.WebURL(r)
+	if err := open.Start(webURL); err != nil {
+		slog.Error("unable to open web page", "url", webURL, "err", err)
+	}
+}
@@ -1,16 +1,18 @@
 package tray
 
 import (
-	"log"
+	"log/slog"
 	"sync"
 
 	"github.com/getlantern/systray"
 	"github.com/mangalaman93/giggle/conf"
 	"github.com/mangalaman93/giggle/content"
+	"github.com/mangalaman93/giggle/svc"
 	"github.com/skratchdot/open-golang/open"
 )
 
 const (
+	cSyncsMenuEntry    = "Syncs"
 	cSettingsMenuEntry = "Settings"
 	cLogFileMenuEntry  = "Open Logs"
 	cExitMenuEntry     = "Exit"
@@ -21,48 +23,74 @@ type GTray struct {
 	mainQuit chan struct{}
 	quit     chan struct{}
 	wg       sync.WaitGroup
+	client   *svc.Client
+	icons    map[string][]byte
+
+	// syncsMenu is the parent of the submenus of the syncs, so that the submenus of the
+	// syncs added after the start are listed above the other entries of the menu.
+	syncsMenu *systray.MenuItem
+
+	// mu guards the submenus of the syncs and the state shown by the icon.
+	mu           sync.Mutex
+	menus        []*syncMenu
+	state        string
+	statusFailed bool
 }
 
 // Start starts the system tray.
 func Start(mainQuit chan struct{}) *GTray {
-	log.Println("[INFO] starting giggle tray")
-	return &GTray{mainQuit: mainQuit, quit: make(chan struct{})}
+	slog.Info("starting giggle tray")
+	return &GTray{
+		mainQuit: mainQuit,
+		quit:     make(chan struct{}),
+		client:   svc.NewClient(conf.SocketFilePath()),
+	}
 }
 
 // Stop stops the system tray.
 func (gt *GTray) Stop() error {
-	log.Println("[INFO] stopping giggle tray")
-	gt.quit <- struct{}{}
+	slog.Info("stopping giggle tray")
+	close(gt.quit)
 	gt.wg.Wait()
 	return nil
 }
 
 func (gt *GTray) onSettingsMenuClick() {
-	log.Println("[INFO] settings menu option selected")
+	slog.Info("settings menu option selected")
 	settingsPath := conf.SettingsFilePath()
 	if err := open.Start(settingsPath); err != nil {
-		log.Printf("[ERROR] unable to open %s :: %v\n", settingsPath, err)
+		slog.Error("unable to open settings", "path", settingsPath, "err", err)
 	}
 }
 
 func (gt *GTray) onLogFileMenuClick() {
-	log.Println("[INFO] log file menu option selected")
+	slog.Info("log file menu option selected")
 	logFolder := conf.LogFolder()
 	if err := open.Start(logFolder); err != nil {
-		log.Printf("[ERROR] unable to open %s :: %v\n", logFolder, err)
+		slog.Error("unable to open log folder", "path", logFolder, "err", err)
 	}
 }
 
 func (gt *GTray) onExitMenuClick() {
-	log.Println("[INFO] exit menu option selected")
+	slog.Info("exit menu option selected")
 	gt.mainQuit <- struct{}{}
 }
 
 // OnReady is the function that is passed to systray.Run.
 func (gt *GTray) OnReady() {
-	systray.SetIcon(getIcon(conf.IconFile()))
+	gt.icons = stateIcons(getIcon(conf.IconFile()))
+	gt.state = stateIdle
+	systray.SetIcon(gt.icons[gt.state])
 	systray.SetTooltip(conf.AppName())
 
+	gt.syncsMenu = systray.AddMenuItem(cSyncsMenuEntry, "")
+	gt.mu.Lock()
+	for _, name := range configuredSyncs() {
+		gt.menus = append(gt.menus, gt.newSyncMenu(name))
+	}
+	gt.mu.Unlock()
+	systray.AddSeparator()
+
 	settingsMenu := systray.AddMenuItem(cSettingsMenuEntry, "")
 	settingsMenu.SetIcon(getIcon(conf.SettingsIconFile()))
 
@@ -72,9 +100,10 @@ func (gt *GTray) OnReady() {
 	exitMenu := systray.AddMenuItem(cExitMenuEntry, "")
 	exitMenu.SetIcon(getIcon(conf.ExitIconFile()))
 
-	gt.wg.Add(1)
+	gt.wg.Add(2)
 	go gt.handleClicks(settingsMenu, logFileMenu, exitMenu)
-	log.Println("[INFO] constructed system tray menu")
+	go gt.refreshLoop()
+	slog.Info("constructed system tray menu")
 }
 
 func (gt *GTray) handleClicks(settingsMenu, logFileMenu, exitMenu *systray.MenuItem) {
@@ -97,7 +126,7 @@ func (gt *GTray) handleClicks(settingsMenu, logFileMenu, exitMenu *systray.MenuI
 func getIcon(iconFile string) []byte {
 	iconData, err := content.Asset(iconFile)
 	if err != nil {
-		log.Printf("[ERROR] unable to open icon for %v\n", iconFile)
+		slog.Error("unable to open icon", "file", iconFile)
 		panic(err)
 	}
 